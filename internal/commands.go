@@ -0,0 +1,167 @@
+package internal
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/florianloch/cassette/internal/constants"
+	"github.com/florianloch/cassette/internal/persistence"
+	"github.com/florianloch/cassette/internal/util"
+)
+
+// RunMigrateCommand upgrades all documents stored by the configured backend to the version
+// understood by this binary. With '-dry-run' it only reports how many documents are at each version.
+func RunMigrateCommand(args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only report how many documents are at each version")
+	_ = flags.Parse(args) // exits on error
+
+	ctx, cancelFn := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancelFn()
+
+	p := connectPersistence(util.Env(constants.EnvENV, "") == "DEV")
+
+	migrator, ok := p.(persistence.DocumentMigrator)
+	if !ok {
+		log.Info().Msg("The configured backend does not store versioned documents, its schema gets migrated on startup. Nothing to do.")
+		return
+	}
+
+	reportDocumentVersions(ctx, migrator)
+
+	if *dryRun {
+		return
+	}
+
+	migrated, err := migrator.MigrateDocuments(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Int("migrated", migrated).Msg("Failed migrating documents.")
+	}
+
+	log.Info().Msgf("Migrated %d document(s).", migrated)
+
+	reportDocumentVersions(ctx, migrator)
+}
+
+func reportDocumentVersions(ctx context.Context, migrator persistence.DocumentMigrator) {
+	versions, err := migrator.DocumentVersions(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed counting documents per version.")
+	}
+
+	sorted := make([]int, 0, len(versions))
+	for version := range versions {
+		sorted = append(sorted, version)
+	}
+	sort.Ints(sorted)
+
+	for _, version := range sorted {
+		log.Info().Int("version", version).Int64("documents", versions[version]).Msg("")
+	}
+}
+
+// RunReencryptCommand seals all stored player states with the current master key, run it after rotating
+// the master keys before dropping the previous ones. With '-dry-run' it only reports how many player states
+// are sealed with each master key.
+func RunReencryptCommand(args []string) {
+	flags := flag.NewFlagSet("reencrypt", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only report how many player states are sealed with each master key")
+	_ = flags.Parse(args) // exits on error
+
+	ctx, cancelFn := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancelFn()
+
+	p := connectPersistence(util.Env(constants.EnvENV, "") == "DEV")
+
+	reencryptor, ok := p.(persistence.Reencryptor)
+	if !ok {
+		log.Info().Msg("The configured backend does not encrypt player states. Nothing to do.")
+		return
+	}
+
+	reportKeyUsage(ctx, reencryptor)
+
+	if *dryRun {
+		return
+	}
+
+	reencrypted, err := reencryptor.ReencryptDocuments(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Int("reencrypted", reencrypted).Msg("Failed re-encrypting documents.")
+	}
+
+	log.Info().Msgf("Re-encrypted %d document(s).", reencrypted)
+
+	reportKeyUsage(ctx, reencryptor)
+}
+
+func reportKeyUsage(ctx context.Context, reencryptor persistence.Reencryptor) {
+	usage, err := reencryptor.KeyUsage(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed counting player states per master key.")
+	}
+
+	sorted := make([]string, 0, len(usage))
+	for keyID := range usage {
+		sorted = append(sorted, keyID)
+	}
+	sort.Strings(sorted)
+
+	for _, keyID := range sorted {
+		if keyID == "" {
+			log.Info().Str("keyID", "<plaintext>").Int64("playerStates", usage[keyID]).Msg("")
+			continue
+		}
+
+		log.Info().Str("keyID", keyID).Int64("playerStates", usage[keyID]).Msg("")
+	}
+}
+
+// RunRekeyCommand moves all records stored under the plain hash of a user ID to the peppered one, run it after
+// configuring a pepper before no longer accepting legacy user IDs. With '-dry-run' it only reports how many
+// records are still stored under legacy user IDs.
+func RunRekeyCommand(args []string) {
+	flags := flag.NewFlagSet("rekey", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only report how many records are stored under legacy user IDs")
+	_ = flags.Parse(args) // exits on error
+
+	ctx, cancelFn := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancelFn()
+
+	p := connectPersistence(util.Env(constants.EnvENV, "") == "DEV")
+
+	rekeyer, ok := p.(persistence.UserIDRekeyer)
+	if !ok {
+		log.Info().Msg("The configured backend does not support rekeying user IDs. Nothing to do.")
+		return
+	}
+
+	reportLegacyUserIDs(ctx, rekeyer)
+
+	if *dryRun {
+		return
+	}
+
+	rekeyed, err := rekeyer.RekeyUserIDs(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Int("rekeyed", rekeyed).Msg("Failed rekeying user IDs.")
+	}
+
+	log.Info().Msgf("Rekeyed %d record(s).", rekeyed)
+
+	reportLegacyUserIDs(ctx, rekeyer)
+}
+
+func reportLegacyUserIDs(ctx context.Context, rekeyer persistence.UserIDRekeyer) {
+	count, err := rekeyer.LegacyUserIDs(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed counting records stored under legacy user IDs.")
+	}
+
+	log.Info().Int64("records", count).Msg("Records stored under legacy user IDs.")
+}
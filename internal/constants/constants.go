@@ -12,6 +12,16 @@ const (
 	JumpBackNSeconds        = 10
 	WebStaticContentPath    = "./web/dist"
 	OAuthCallbackRoute      = "/spotify-oauth-callback"
+	DevPersistenceURI       = "memory://"
+	DefaultSpotifyTimeout   = "10s"
+	DefaultDBTimeout        = "5s"
+	DefaultTrashRetention   = "720h" // 30 days
+	DefaultAcceptLegacyIDs  = "true"
+	DefaultInactivityWindow = "8760h" // 365 days
+	DefaultMaxSlots         = "100"
+	DefaultQuotaPolicy      = "reject"
+	DefaultSpotifyBudget    = "20s"
+	DefaultContextCacheTTL  = "2m"
 
 	// Names of envs
 	EnvENV                      = "CASSETTE_ENV"
@@ -24,16 +34,39 @@ const (
 	EnvMongoURI                 = "CASSETTE_MONGODB_URI"
 	EnvSpotifyClientID          = "CASSETTE_SPOTIFY_CLIENT_ID"
 	EnvSpotifyClientSecret      = "CASSETTE_SPOTIFY_CLIENT_KEY"
+	EnvSpotifyTimeout           = "CASSETTE_SPOTIFY_TIMEOUT" // per call to Spotify's API, e.g. "10s"
+	EnvDBTimeout                = "CASSETTE_DB_TIMEOUT"      // per call to the persistence backend, e.g. "5s"
+	EnvTrashRetention           = "CASSETTE_TRASH_RETENTION" // how long removed slots are kept in the trash, e.g. "720h"
+	EnvMasterKeys               = "CASSETTE_MASTER_KEYS"     // keys for encrypting player states at rest, "<id>:<base64 encoded key>,...", the first one is the current one
+	EnvUserIDPepper             = "CASSETTE_USER_ID_PEPPER"  // secret for hashing user IDs, at least 32 characters
+	// whether records stored under the unpeppered hash of a user ID are still found, "true" or "false"
+	EnvAcceptLegacyUserIDs = "CASSETTE_ACCEPT_LEGACY_USER_IDS"
+	// how long users may be inactive before their records get deleted, e.g. "8760h"; "0" disables deleting them
+	EnvInactivityWindow = "CASSETTE_INACTIVITY_WINDOW"
+	// whether inactive users only get counted instead of deleted, "true" or "false"
+	EnvRetentionDryRun = "CASSETTE_RETENTION_DRY_RUN"
+	EnvMaxSlots        = "CASSETTE_MAX_SLOTS"    // maximum number of slots per user, "0" means there is no limit
+	EnvQuotaPolicy     = "CASSETTE_QUOTA_POLICY" // what happens when adding slots beyond the maximum, "reject" or "evict"
+	// total time spent on a call to Spotify's API including all retries, e.g. "20s"
+	EnvSpotifyBudget = "CASSETTE_SPOTIFY_RETRY_BUDGET"
+	// how long the items of albums, playlists etc. are kept once looked into, e.g. "2m"; "0" disables keeping them
+	EnvContextCacheTTL = "CASSETTE_CONTEXT_CACHE_TTL"
+)
 
-	// Keys for context fields
+// Keys for context fields
+const (
 	FieldKeySession = ctxKey(iota)
 	FieldKeyDao
 	FieldKeySlot
 	FieldKeyUser
 	FieldKeySpotifyClient
+	FieldKeyContextCache
+)
 
-	// Keys for session values, as these are stored in the session cookie use something small
-	SessionKeyUser = sessionKey(iota)
+// Keys for session values, as these are stored in the session cookie use something small. Their values must never
+// change, otherwise existing sessions cannot be read anymore. Hence, they are pinned to the ones of the first release.
+const (
+	SessionKeyUser = sessionKey(iota + 26)
 	SessionKeySpotifyToken
 	SessionKeyInitiallyRequestedRoute
 	SessionKeyOAuthRandomState
@@ -0,0 +1,16 @@
+package constants
+
+import "testing"
+
+func TestSessionKeysKeepTheirValues(t *testing.T) {
+	for key, expected := range map[sessionKey]int{
+		SessionKeyUser:                    26,
+		SessionKeySpotifyToken:            27,
+		SessionKeyInitiallyRequestedRoute: 28,
+		SessionKeyOAuthRandomState:        29,
+	} {
+		if int(key) != expected {
+			t.Errorf("expected session key to be %d, got %d", expected, key)
+		}
+	}
+}
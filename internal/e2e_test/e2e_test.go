@@ -1,11 +1,13 @@
 package e2e_test
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"path/filepath"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -29,6 +31,7 @@ const (
 
 var (
 	dummyOAuthToken = &oauth2.Token{}
+	testQuota       = persistence.Quota{MaxSlots: 10, Policy: persistence.QuotaPolicyEvict}
 	dummyUser       = &spotifyAPI.PrivateUser{
 		User: spotifyAPI.User{
 			ID: dummyUserID,
@@ -93,28 +96,207 @@ func TestRetrievalOfPlayerStates(t *testing.T) {
 
 	login(t, e, authMock)
 
-	daoMock.EXPECT().LoadPlayerStates(dummyUserID).Times(1).
-		Return([]*persistence.PlayerState{dummyPlayerState("book 1"), dummyPlayerState("book 2")}, nil)
+	daoMock.EXPECT().LoadPlayerStates(gomock.Any(), dummyUserID).Times(1).
+		Return([]*persistence.PlayerState{dummyPlayerState("book 1"), dummyPlayerState("book 2")}, int64(7), nil)
 
 	// currentUser gets stored in the session so should only be called once in the scope of a test
-	clientMock.EXPECT().CurrentUser().Times(1).Return(dummyUser, nil)
+	clientMock.EXPECT().CurrentUser(gomock.Any()).Times(1).Return(dummyUser, nil)
 
 	r := e.GET("/api/playerStates").Expect()
 	r.Status(http.StatusOK)
 	r.HasContentType("application/json")
+	r.Header("ETag").IsEqual(`"7"`)
 	a := r.JSON().Array()
 	a.Length().IsEqual(2)
 	a.Value(0).Object().Value("albumName").String().IsEqual("book 1")
 	a.Value(1).Object().Value("albumName").String().IsEqual("book 2")
 }
 
+func TestRetrievalOfSlotHistory(t *testing.T) {
+	e, ctrl, daoMock, authMock, clientMock := beforeEach(t)
+	defer ctrl.Finish()
+
+	login(t, e, authMock)
+
+	slot := dummyPlayerState("book 3")
+	slot.ID = "slot1"
+	slot.History = []*persistence.PlayerState{dummyPlayerState("book 2"), dummyPlayerState("book 1")}
+
+	daoMock.EXPECT().LoadPlayerStates(gomock.Any(), dummyUserID).Times(2).
+		Return([]*persistence.PlayerState{slot}, int64(7), nil)
+	clientMock.EXPECT().CurrentUser(gomock.Any()).Times(1).Return(dummyUser, nil)
+
+	// The history is not part of the listing of slots...
+	r := e.GET("/api/playerStates").Expect()
+	r.Status(http.StatusOK)
+	r.JSON().Array().Value(0).Object().NotContainsKey("history")
+
+	// ... but has its own route
+	r = e.GET("/api/playerStates/slot1/history").Expect()
+	r.Status(http.StatusOK)
+	r.HasContentType("application/json")
+	r.Header("ETag").IsEqual(`"7"`)
+	a := r.JSON().Array()
+	a.Length().IsEqual(2)
+	a.Value(0).Object().Value("albumName").String().IsEqual("book 2")
+	a.Value(1).Object().Value("albumName").String().IsEqual("book 1")
+}
+
+func TestRevertOfSlot(t *testing.T) {
+	e, ctrl, daoMock, authMock, clientMock := beforeEach(t)
+	defer ctrl.Finish()
+
+	login(t, e, authMock)
+
+	slot := dummyPlayerState("book 3")
+	slot.ID = "slot1"
+	slot.History = []*persistence.PlayerState{dummyPlayerState("book 2"), dummyPlayerState("book 1")}
+
+	daoMock.EXPECT().LoadPlayerStates(gomock.Any(), dummyUserID).Times(2).
+		Return([]*persistence.PlayerState{dummyPlayerState("book 0"), slot}, int64(7), nil)
+	daoMock.EXPECT().ReplaceState(gomock.Any(), dummyUserID, int64(7), 1, slot.History[1]).Times(1).Return(nil)
+	clientMock.EXPECT().CurrentUser(gomock.Any()).Times(1).Return(dummyUser, nil)
+
+	csrfToken := e.HEAD("/api/csrfToken").Expect().Header(constants.CSRFHeaderName).Raw()
+
+	r := e.POST("/api/playerStates/slot1/history/2/revert").
+		WithHeader(constants.CSRFHeaderName, csrfToken).
+		WithHeader("Referer", "https://cassette-for-spotify.app/").
+		Expect()
+	r.Status(http.StatusBadRequest)
+
+	r = e.POST("/api/playerStates/slot1/history/1/revert").
+		WithHeader(constants.CSRFHeaderName, csrfToken).
+		WithHeader("Referer", "https://cassette-for-spotify.app/").
+		WithHeader("If-Match", `"7"`).
+		Expect()
+	r.Status(http.StatusOK)
+}
+
+func TestRetrievalOfTrash(t *testing.T) {
+	e, ctrl, daoMock, authMock, clientMock := beforeEach(t)
+	defer ctrl.Finish()
+
+	login(t, e, authMock)
+
+	removed := dummyPlayerState("book 1")
+	removed.ID = "slot1"
+	removed.RemovedAtTs = 1600000000
+
+	daoMock.EXPECT().LoadTrash(gomock.Any(), dummyUserID).Times(1).Return([]*persistence.PlayerState{removed}, nil)
+	clientMock.EXPECT().CurrentUser(gomock.Any()).Times(1).Return(dummyUser, nil)
+
+	r := e.GET("/api/trash").Expect()
+	r.Status(http.StatusOK)
+	r.HasContentType("application/json")
+	a := r.JSON().Array()
+	a.Length().IsEqual(1)
+	o := a.Value(0).Object()
+	o.Value("id").String().IsEqual("slot1")
+	o.Value("albumName").String().IsEqual("book 1")
+	o.Value("removedAtTs").Number().IsEqual(1600000000)
+}
+
+func TestRestoreFromTrash(t *testing.T) {
+	e, ctrl, daoMock, authMock, clientMock := beforeEach(t)
+	defer ctrl.Finish()
+
+	login(t, e, authMock)
+
+	daoMock.EXPECT().RestoreFromTrash(gomock.Any(), dummyUserID, "slot1").Times(1).Return(nil)
+	daoMock.EXPECT().RestoreFromTrash(gomock.Any(), dummyUserID, "unknown").Times(1).Return(persistence.ErrNotInTrash)
+	daoMock.EXPECT().RestoreFromTrash(gomock.Any(), dummyUserID, "slot2").Times(1).Return(persistence.ErrQuotaExceeded)
+	clientMock.EXPECT().CurrentUser(gomock.Any()).Times(1).Return(dummyUser, nil)
+
+	csrfToken := e.HEAD("/api/csrfToken").Expect().Header(constants.CSRFHeaderName).Raw()
+
+	r := e.POST("/api/trash/slot1/restore").
+		WithHeader(constants.CSRFHeaderName, csrfToken).
+		WithHeader("Referer", "https://cassette-for-spotify.app/").
+		Expect()
+	r.Status(http.StatusOK)
+
+	r = e.POST("/api/trash/unknown/restore").
+		WithHeader(constants.CSRFHeaderName, csrfToken).
+		WithHeader("Referer", "https://cassette-for-spotify.app/").
+		Expect()
+	r.Status(http.StatusNotFound)
+
+	r = e.POST("/api/trash/slot2/restore").
+		WithHeader(constants.CSRFHeaderName, csrfToken).
+		WithHeader("Referer", "https://cassette-for-spotify.app/").
+		Expect()
+	r.Status(http.StatusInsufficientStorage)
+}
+
+func TestPinningOfSlot(t *testing.T) {
+	e, ctrl, daoMock, authMock, clientMock := beforeEach(t)
+	defer ctrl.Finish()
+
+	login(t, e, authMock)
+
+	slot := dummyPlayerState("book 1")
+	slot.ID = "slot1"
+
+	daoMock.EXPECT().LoadPlayerStates(gomock.Any(), dummyUserID).Times(2).
+		Return([]*persistence.PlayerState{dummyPlayerState("book 0"), slot}, int64(7), nil)
+	daoMock.EXPECT().SetPinned(gomock.Any(), dummyUserID, int64(7), 1, true).Times(1).Return(nil)
+	daoMock.EXPECT().SetPinned(gomock.Any(), dummyUserID, int64(7), 1, false).Times(1).Return(persistence.ErrConflict)
+	clientMock.EXPECT().CurrentUser(gomock.Any()).Times(1).Return(dummyUser, nil)
+
+	csrfToken := e.HEAD("/api/csrfToken").Expect().Header(constants.CSRFHeaderName).Raw()
+
+	r := e.PUT("/api/playerStates/slot1/pin").
+		WithHeader(constants.CSRFHeaderName, csrfToken).
+		WithHeader("Referer", "https://cassette-for-spotify.app/").
+		WithHeader("If-Match", `"7"`).
+		Expect()
+	r.Status(http.StatusOK)
+
+	r = e.DELETE("/api/playerStates/slot1/pin").
+		WithHeader(constants.CSRFHeaderName, csrfToken).
+		WithHeader("Referer", "https://cassette-for-spotify.app/").
+		WithHeader("If-Match", `"7"`).
+		Expect()
+	r.Status(http.StatusConflict)
+}
+
+func TestRetrievalOfQuota(t *testing.T) {
+	e, ctrl, _, authMock, clientMock := beforeEach(t)
+	defer ctrl.Finish()
+
+	login(t, e, authMock)
+
+	clientMock.EXPECT().CurrentUser(gomock.Any()).Times(1).Return(dummyUser, nil)
+
+	r := e.GET("/api/quota").Expect()
+	r.Status(http.StatusOK)
+	r.HasContentType("application/json")
+	o := r.JSON().Object()
+	o.Value("maxSlots").Number().IsEqual(10)
+	o.Value("policy").String().IsEqual("evict")
+}
+
+func TestRevokedTokenWhileFetchingUserAsksToLogInAgain(t *testing.T) {
+	e, ctrl, _, authMock, clientMock := beforeEach(t)
+	defer ctrl.Finish()
+
+	login(t, e, authMock)
+
+	clientMock.EXPECT().CurrentUser(gomock.Any()).Times(1).Return(nil, &spotify.Error{Kind: spotify.ErrTokenRevoked})
+
+	r := e.GET("/api/quota").Expect()
+	r.Status(http.StatusUnauthorized)
+	r.Body().Contains("log in again")
+}
+
 func TestRetrievalOfActiveDevices(t *testing.T) {
 	e, ctrl, _, authMock, clientMock := beforeEach(t)
 	defer ctrl.Finish()
 
 	login(t, e, authMock)
 
-	clientMock.EXPECT().PlayerDevices().Times(1).Return(dummyDevices, nil)
+	clientMock.EXPECT().PlayerDevices(gomock.Any()).Times(1).Return(dummyDevices, nil)
 
 	r := e.GET("/api/activeDevices").Expect()
 	r.Status(http.StatusOK)
@@ -129,6 +311,72 @@ func TestRetrievalOfActiveDevices(t *testing.T) {
 	o2.Value("active").Boolean().IsTrue()
 }
 
+func TestSpotifyBeingUnavailable(t *testing.T) {
+	e, ctrl, _, authMock, clientMock := beforeEach(t)
+	defer ctrl.Finish()
+
+	login(t, e, authMock)
+
+	clientMock.EXPECT().PlayerDevices(gomock.Any()).Times(1).Return(nil, &spotify.UnavailableError{RetryAfter: 29500 * time.Millisecond})
+
+	r := e.GET("/api/activeDevices").Expect()
+	r.Status(http.StatusServiceUnavailable)
+	r.Header("Retry-After").IsEqual("30")
+}
+
+func TestSpotifyErrorsAreMapped(t *testing.T) {
+	e, ctrl, _, authMock, clientMock := beforeEach(t)
+	defer ctrl.Finish()
+
+	login(t, e, authMock)
+
+	for kind, status := range map[error]int{
+		spotify.ErrNoActiveDevice:             http.StatusConflict,
+		spotify.ErrPremiumRequired:            http.StatusForbidden,
+		spotify.ErrTokenRevoked:               http.StatusUnauthorized,
+		spotify.ErrNotFound:                   http.StatusNotFound,
+		spotify.ErrContextUnavailableInMarket: http.StatusUnavailableForLegalReasons,
+		spotify.ErrUpstream:                   http.StatusBadGateway,
+	} {
+		clientMock.EXPECT().PlayerDevices(gomock.Any()).Times(1).Return(nil, &spotify.Error{Kind: kind})
+
+		e.GET("/api/activeDevices").Expect().Status(status)
+	}
+
+	clientMock.EXPECT().PlayerDevices(gomock.Any()).Times(1).Return(nil, &spotify.Error{Kind: spotify.ErrRateLimited, RetryAfter: 4 * time.Second})
+
+	r := e.GET("/api/activeDevices").Expect()
+	r.Status(http.StatusTooManyRequests)
+	r.Header("Retry-After").IsEqual("4")
+}
+
+func TestRefreshedTokenIsStoredInSession(t *testing.T) {
+	e, ctrl, _, authMock, clientMock := beforeEach(t)
+	defer ctrl.Finish()
+
+	login(t, e, authMock)
+
+	refreshedToken := &oauth2.Token{AccessToken: "refreshed", RefreshToken: "rotated"}
+
+	// Registered before the ones of the creator, therefore these take precedence
+	var onRefresh func(token *oauth2.Token)
+	authMock.EXPECT().NewClient(dummyOAuthToken, gomock.Any()).MinTimes(1).Do(func(token *oauth2.Token, f func(token *oauth2.Token)) {
+		onRefresh = f
+	})
+	authMock.EXPECT().NewClient(refreshedToken, gomock.Any()).MinTimes(1)
+
+	// The token gets refreshed while talking to Spotify...
+	clientMock.EXPECT().PlayerDevices(gomock.Any()).Times(1).DoAndReturn(func(ctx context.Context) ([]spotifyAPI.PlayerDevice, error) {
+		onRefresh(refreshedToken)
+		return dummyDevices, nil
+	})
+	// ... and is used with the next request
+	clientMock.EXPECT().PlayerDevices(gomock.Any()).Times(1).Return(dummyDevices, nil)
+
+	e.GET("/api/activeDevices").Expect().Status(http.StatusOK)
+	e.GET("/api/activeDevices").Expect().Status(http.StatusOK)
+}
+
 func TestSavePlayerState(t *testing.T) {
 	// TODO: implement!
 	// 1. With invalid/not-attached CSRF token
@@ -142,6 +390,49 @@ func TestRestorePlayerState(t *testing.T) {
 	// 2. With default device
 }
 
+func TestRestoreOfTrackGoneFromContext(t *testing.T) {
+	e, ctrl, daoMock, authMock, clientMock := beforeEach(t)
+	defer ctrl.Finish()
+
+	login(t, e, authMock)
+
+	slot := dummyPlayerState("book 1")
+	slot.ID = "slot1"
+	slot.PlaybackContextURI = "spotify:album:album1"
+	slot.PlaybackItemURI = "spotify:track:removed"
+	slot.TrackName = "Chapter 2"
+	slot.TrackIndex = 2
+
+	tracks := &spotifyAPI.SimpleTrackPage{Tracks: []spotifyAPI.SimpleTrack{
+		{ID: "track1", URI: "spotify:track:track1", Name: "Chapter 1"},
+		{ID: "track2", URI: "spotify:track:track2", Name: "Chapter 2 (Remastered)"},
+	}}
+	tracks.Total = 2
+
+	daoMock.EXPECT().LoadPlayerStates(gomock.Any(), dummyUserID).Times(1).
+		Return([]*persistence.PlayerState{dummyPlayerState("book 0"), slot}, int64(7), nil)
+	clientMock.EXPECT().CurrentUser(gomock.Any()).Times(1).Return(dummyUser, nil)
+	clientMock.EXPECT().Pause(gomock.Any()).Times(1).Return(nil)
+	clientMock.EXPECT().Shuffle(gomock.Any(), false).Times(1).Return(nil)
+	clientMock.EXPECT().GetAlbumTracksOpt(gomock.Any(), spotifyAPI.ID("album1"), gomock.Any()).Times(1).Return(tracks, nil)
+	clientMock.EXPECT().PlayOpt(gomock.Any(), gomock.Any()).Times(1).DoAndReturn(func(ctx context.Context, opt *spotifyAPI.PlayOptions) error {
+		if opt.PlaybackOffset.URI != "spotify:track:track2" {
+			t.Errorf("expected track at saved index to be played, got %s", opt.PlaybackOffset.URI)
+		}
+		return nil
+	})
+
+	csrfToken := e.HEAD("/api/csrfToken").Expect().Header(constants.CSRFHeaderName).Raw()
+
+	r := e.POST("/api/playerStates/slot1/restore").
+		WithQuery("deviceID", "device1").
+		WithHeader(constants.CSRFHeaderName, csrfToken).
+		WithHeader("Referer", "https://cassette-for-spotify.app/").
+		Expect()
+	r.Status(http.StatusOK)
+	r.JSON().Object().Value("strategy").String().IsEqual("index")
+}
+
 func TestDeletePlayerState(t *testing.T) {
 	// TODO: implement!
 }
@@ -150,6 +441,145 @@ func TestExportUserData(t *testing.T) {
 	// TODO: implement!
 }
 
+func TestImportUserData(t *testing.T) {
+	e, ctrl, daoMock, authMock, clientMock := beforeEach(t)
+	defer ctrl.Finish()
+
+	login(t, e, authMock)
+
+	dump := `{"_id": "ABC", "version": 4, "playerStates": [
+		{"id": "0000000000000000000000000000000a", "playbackContextURI": "spotify:album:a", "playbackItemURI": "spotify:track:a"},
+		{"id": "0000000000000000000000000000000b", "playbackContextURI": "spotify:album:b", "playbackItemURI": "spotify:track:b"},
+		{"id": "0000000000000000000000000000000c", "playbackItemURI": "spotify:track:c"}
+	]}`
+
+	existing := dummyPlayerState("book a")
+	existing.ID = "0000000000000000000000000000000a"
+
+	daoMock.EXPECT().LoadPlayerStates(gomock.Any(), dummyUserID).Times(1).Return([]*persistence.PlayerState{existing}, int64(1), nil)
+	daoMock.EXPECT().AppendState(gomock.Any(), dummyUserID, &persistence.PlayerState{
+		ID:                 "0000000000000000000000000000000b",
+		PlaybackContextURI: "spotify:album:b",
+		PlaybackItemURI:    "spotify:track:b",
+	}).Times(1).Return(nil)
+	daoMock.EXPECT().SavePlayerStates(gomock.Any(), dummyUserID, gomock.Len(2)).Times(1).Return(nil)
+	clientMock.EXPECT().CurrentUser(gomock.Any()).Times(1).Return(dummyUser, nil)
+
+	csrfToken := e.HEAD("/api/csrfToken").Expect().Header(constants.CSRFHeaderName).Raw()
+
+	r := e.POST("/api/you/import").
+		WithHeader(constants.CSRFHeaderName, csrfToken).
+		WithHeader("Referer", "https://cassette-for-spotify.app/").
+		WithText(dump).
+		Expect()
+	r.Status(http.StatusOK)
+	o := r.JSON().Object()
+	o.Value("mode").String().IsEqual("merge")
+	o.Value("imported").Number().IsEqual(1)
+	o.Value("skipped").Array().Length().IsEqual(1)
+	o.Value("skipped").Array().Value(0).Object().Value("id").String().IsEqual("0000000000000000000000000000000a")
+	o.Value("invalid").Array().Length().IsEqual(1)
+	o.Value("invalid").Array().Value(0).Object().Value("index").Number().IsEqual(2)
+
+	r = e.POST("/api/you/import").
+		WithQuery("mode", "replace").
+		WithHeader(constants.CSRFHeaderName, csrfToken).
+		WithHeader("Referer", "https://cassette-for-spotify.app/").
+		WithText(dump).
+		Expect()
+	r.Status(http.StatusOK)
+	r.JSON().Object().Value("imported").Number().IsEqual(2)
+
+	r = e.POST("/api/you/import").
+		WithQuery("mode", "overwrite").
+		WithHeader(constants.CSRFHeaderName, csrfToken).
+		WithHeader("Referer", "https://cassette-for-spotify.app/").
+		WithText(dump).
+		Expect()
+	r.Status(http.StatusBadRequest)
+
+	r = e.POST("/api/you/import").
+		WithHeader(constants.CSRFHeaderName, csrfToken).
+		WithHeader("Referer", "https://cassette-for-spotify.app/").
+		WithText(`{"version": 99}`).
+		Expect()
+	r.Status(http.StatusBadRequest)
+}
+
+func TestImportUserDataDoesNotEvictSlots(t *testing.T) {
+	e, ctrl, daoMock, authMock, clientMock := beforeEach(t)
+	defer ctrl.Finish()
+
+	login(t, e, authMock)
+
+	dump := `{"_id": "ABC", "version": 4, "playerStates": [
+		{"id": "fffffffffffffffffffffffffffffff1", "playbackContextURI": "spotify:album:x", "playbackItemURI": "spotify:track:x"},
+		{"id": "fffffffffffffffffffffffffffffff2", "playbackContextURI": "spotify:album:y", "playbackItemURI": "spotify:track:y"},
+		{"id": "fffffffffffffffffffffffffffffff3", "playbackContextURI": "spotify:album:z", "playbackItemURI": "spotify:track:z"}
+	]}`
+
+	existing := make([]*persistence.PlayerState, testQuota.MaxSlots-1)
+	for i := range existing {
+		existing[i] = dummyPlayerState(fmt.Sprintf("book %d", i))
+		existing[i].ID = fmt.Sprintf("%d", i)
+	}
+
+	daoMock.EXPECT().LoadPlayerStates(gomock.Any(), dummyUserID).Times(1).Return(existing, int64(1), nil)
+	daoMock.EXPECT().AppendState(gomock.Any(), dummyUserID, &persistence.PlayerState{
+		ID:                 "fffffffffffffffffffffffffffffff1",
+		PlaybackContextURI: "spotify:album:x",
+		PlaybackItemURI:    "spotify:track:x",
+	}).Times(1).Return(nil)
+	clientMock.EXPECT().CurrentUser(gomock.Any()).Times(1).Return(dummyUser, nil)
+
+	csrfToken := e.HEAD("/api/csrfToken").Expect().Header(constants.CSRFHeaderName).Raw()
+
+	r := e.POST("/api/you/import").
+		WithHeader(constants.CSRFHeaderName, csrfToken).
+		WithHeader("Referer", "https://cassette-for-spotify.app/").
+		WithText(dump).
+		Expect()
+	r.Status(http.StatusOK)
+	o := r.JSON().Object()
+	o.Value("imported").Number().IsEqual(1)
+	skipped := o.Value("skipped").Array()
+	skipped.Length().IsEqual(2)
+	skipped.Value(0).Object().Value("id").String().IsEqual("fffffffffffffffffffffffffffffff2")
+	skipped.Value(1).Object().Value("id").String().IsEqual("fffffffffffffffffffffffffffffff3")
+}
+
+func TestImportUserDataSkipsSlotsBeyondQuotaWhenReplacing(t *testing.T) {
+	e, ctrl, daoMock, authMock, clientMock := beforeEach(t)
+	defer ctrl.Finish()
+
+	login(t, e, authMock)
+
+	entries := make([]string, testQuota.MaxSlots+2)
+	for i := range entries {
+		entries[i] = fmt.Sprintf(`{"id": "%032x", "playbackContextURI": "spotify:album:%d", "playbackItemURI": "spotify:track:%d"}`, i+1, i, i)
+	}
+	dump := `{"_id": "ABC", "version": 4, "playerStates": [` + strings.Join(entries, ",") + `]}`
+
+	daoMock.EXPECT().SavePlayerStates(gomock.Any(), dummyUserID, gomock.Len(testQuota.MaxSlots)).Times(1).Return(nil)
+	clientMock.EXPECT().CurrentUser(gomock.Any()).Times(1).Return(dummyUser, nil)
+
+	csrfToken := e.HEAD("/api/csrfToken").Expect().Header(constants.CSRFHeaderName).Raw()
+
+	r := e.POST("/api/you/import").
+		WithQuery("mode", "replace").
+		WithHeader(constants.CSRFHeaderName, csrfToken).
+		WithHeader("Referer", "https://cassette-for-spotify.app/").
+		WithText(dump).
+		Expect()
+	r.Status(http.StatusOK)
+	o := r.JSON().Object()
+	o.Value("imported").Number().IsEqual(testQuota.MaxSlots)
+	skipped := o.Value("skipped").Array()
+	skipped.Length().IsEqual(2)
+	skipped.Value(0).Object().Value("id").String().IsEqual(fmt.Sprintf("%032x", testQuota.MaxSlots+1))
+	skipped.Value(0).Object().Value("reason").String().IsEqual("maximum number of slots reached")
+}
+
 func TestDeleteUserData(t *testing.T) {
 	// TODO: implement!
 }
@@ -160,11 +590,11 @@ func beforeEach(t *testing.T) (*httpexpect.Expect, *gomock.Controller, *mocks.Mo
 	daoMock := mocks.NewMockPlayerStatesPersistor(ctrl)
 	authMock := mocks.NewMockSpotAuthenticator(ctrl)
 	clientMock := mocks.NewMockSpotClient(ctrl)
-	spotClientMockCreator := func(token *oauth2.Token) spotify.SpotClient {
+	spotClientMockCreator := func(token *oauth2.Token, onRefresh func(token *oauth2.Token)) spotify.SpotClient {
 		// Just for completeness and to check that the token is what we expect it to be
 		// Can get called quite often, requests to almost any route cause a spotClient to be attached
-		authMock.EXPECT().NewClient(dummyOAuthToken).AnyTimes()
-		authMock.NewClient(token)
+		authMock.EXPECT().NewClient(dummyOAuthToken, gomock.Any()).AnyTimes()
+		authMock.NewClient(token, onRefresh)
 
 		return clientMock
 	}
@@ -174,7 +604,7 @@ func beforeEach(t *testing.T) (*httpexpect.Expect, *gomock.Controller, *mocks.Mo
 		t.Fatalf("Could not get path of web root: %s", err)
 	}
 
-	handler := main.SetupForTest(daoMock, authMock, spotClientMockCreator, webRoot)
+	handler := main.SetupForTest(daoMock, testQuota, authMock, spotClientMockCreator, webRoot)
 
 	e := httpexpect.WithConfig(httpexpect.Config{
 		BaseURL: "http://cassette-for-spotify.app",
@@ -5,88 +5,235 @@
 package mocks
 
 import (
+	context "context"
+	reflect "reflect"
+	time "time"
+
 	persistence "github.com/florianloch/cassette/internal/persistence"
 	gomock "github.com/golang/mock/gomock"
-	reflect "reflect"
 )
 
-// MockPlayerStatesPersistor is a mock of PlayerStatesPersistor interface
+// MockPlayerStatesPersistor is a mock of PlayerStatesPersistor interface.
 type MockPlayerStatesPersistor struct {
 	ctrl     *gomock.Controller
 	recorder *MockPlayerStatesPersistorMockRecorder
 }
 
-// MockPlayerStatesPersistorMockRecorder is the mock recorder for MockPlayerStatesPersistor
+// MockPlayerStatesPersistorMockRecorder is the mock recorder for MockPlayerStatesPersistor.
 type MockPlayerStatesPersistorMockRecorder struct {
 	mock *MockPlayerStatesPersistor
 }
 
-// NewMockPlayerStatesPersistor creates a new mock instance
+// NewMockPlayerStatesPersistor creates a new mock instance.
 func NewMockPlayerStatesPersistor(ctrl *gomock.Controller) *MockPlayerStatesPersistor {
 	mock := &MockPlayerStatesPersistor{ctrl: ctrl}
 	mock.recorder = &MockPlayerStatesPersistorMockRecorder{mock}
 	return mock
 }
 
-// EXPECT returns an object that allows the caller to indicate expected use
+// EXPECT returns an object that allows the caller to indicate expected use.
 func (m *MockPlayerStatesPersistor) EXPECT() *MockPlayerStatesPersistorMockRecorder {
 	return m.recorder
 }
 
-// LoadPlayerStates mocks base method
-func (m *MockPlayerStatesPersistor) LoadPlayerStates(userID string) ([]*persistence.PlayerState, error) {
+// AppendState mocks base method.
+func (m *MockPlayerStatesPersistor) AppendState(ctx context.Context, userID string, playerState *persistence.PlayerState) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "LoadPlayerStates", userID)
-	ret0, _ := ret[0].([]*persistence.PlayerState)
+	ret := m.ctrl.Call(m, "AppendState", ctx, userID, playerState)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AppendState indicates an expected call of AppendState.
+func (mr *MockPlayerStatesPersistorMockRecorder) AppendState(ctx, userID, playerState interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AppendState", reflect.TypeOf((*MockPlayerStatesPersistor)(nil).AppendState), ctx, userID, playerState)
+}
+
+// CountInactiveUsers mocks base method.
+func (m *MockPlayerStatesPersistor) CountInactiveUsers(ctx context.Context, inactiveSince time.Time) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CountInactiveUsers", ctx, inactiveSince)
+	ret0, _ := ret[0].(int64)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// LoadPlayerStates indicates an expected call of LoadPlayerStates
-func (mr *MockPlayerStatesPersistorMockRecorder) LoadPlayerStates(userID interface{}) *gomock.Call {
+// CountInactiveUsers indicates an expected call of CountInactiveUsers.
+func (mr *MockPlayerStatesPersistorMockRecorder) CountInactiveUsers(ctx, inactiveSince interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadPlayerStates", reflect.TypeOf((*MockPlayerStatesPersistor)(nil).LoadPlayerStates), userID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountInactiveUsers", reflect.TypeOf((*MockPlayerStatesPersistor)(nil).CountInactiveUsers), ctx, inactiveSince)
 }
 
-// SavePlayerStates mocks base method
-func (m *MockPlayerStatesPersistor) SavePlayerStates(userID string, playerStates []*persistence.PlayerState) error {
+// DeleteUserRecord mocks base method.
+func (m *MockPlayerStatesPersistor) DeleteUserRecord(ctx context.Context, userID string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SavePlayerStates", userID, playerStates)
+	ret := m.ctrl.Call(m, "DeleteUserRecord", ctx, userID)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// SavePlayerStates indicates an expected call of SavePlayerStates
-func (mr *MockPlayerStatesPersistorMockRecorder) SavePlayerStates(userID, playerStates interface{}) *gomock.Call {
+// DeleteUserRecord indicates an expected call of DeleteUserRecord.
+func (mr *MockPlayerStatesPersistorMockRecorder) DeleteUserRecord(ctx, userID interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SavePlayerStates", reflect.TypeOf((*MockPlayerStatesPersistor)(nil).SavePlayerStates), userID, playerStates)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteUserRecord", reflect.TypeOf((*MockPlayerStatesPersistor)(nil).DeleteUserRecord), ctx, userID)
 }
 
-// FetchJSONDump mocks base method
-func (m *MockPlayerStatesPersistor) FetchJSONDump(userID string) ([]byte, error) {
+// FetchJSONDump mocks base method.
+func (m *MockPlayerStatesPersistor) FetchJSONDump(ctx context.Context, userID string) ([]byte, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "FetchJSONDump", userID)
+	ret := m.ctrl.Call(m, "FetchJSONDump", ctx, userID)
 	ret0, _ := ret[0].([]byte)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// FetchJSONDump indicates an expected call of FetchJSONDump
-func (mr *MockPlayerStatesPersistorMockRecorder) FetchJSONDump(userID interface{}) *gomock.Call {
+// FetchJSONDump indicates an expected call of FetchJSONDump.
+func (mr *MockPlayerStatesPersistorMockRecorder) FetchJSONDump(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FetchJSONDump", reflect.TypeOf((*MockPlayerStatesPersistor)(nil).FetchJSONDump), ctx, userID)
+}
+
+// LoadPlayerStates mocks base method.
+func (m *MockPlayerStatesPersistor) LoadPlayerStates(ctx context.Context, userID string) ([]*persistence.PlayerState, int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LoadPlayerStates", ctx, userID)
+	ret0, _ := ret[0].([]*persistence.PlayerState)
+	ret1, _ := ret[1].(int64)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// LoadPlayerStates indicates an expected call of LoadPlayerStates.
+func (mr *MockPlayerStatesPersistorMockRecorder) LoadPlayerStates(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadPlayerStates", reflect.TypeOf((*MockPlayerStatesPersistor)(nil).LoadPlayerStates), ctx, userID)
+}
+
+// LoadTrash mocks base method.
+func (m *MockPlayerStatesPersistor) LoadTrash(ctx context.Context, userID string) ([]*persistence.PlayerState, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LoadTrash", ctx, userID)
+	ret0, _ := ret[0].([]*persistence.PlayerState)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LoadTrash indicates an expected call of LoadTrash.
+func (mr *MockPlayerStatesPersistorMockRecorder) LoadTrash(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadTrash", reflect.TypeOf((*MockPlayerStatesPersistor)(nil).LoadTrash), ctx, userID)
+}
+
+// MoveState mocks base method.
+func (m *MockPlayerStatesPersistor) MoveState(ctx context.Context, userID string, revision int64, from, to int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MoveState", ctx, userID, revision, from, to)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MoveState indicates an expected call of MoveState.
+func (mr *MockPlayerStatesPersistorMockRecorder) MoveState(ctx, userID, revision, from, to interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MoveState", reflect.TypeOf((*MockPlayerStatesPersistor)(nil).MoveState), ctx, userID, revision, from, to)
+}
+
+// PurgeInactiveUsers mocks base method.
+func (m *MockPlayerStatesPersistor) PurgeInactiveUsers(ctx context.Context, inactiveSince time.Time) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PurgeInactiveUsers", ctx, inactiveSince)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PurgeInactiveUsers indicates an expected call of PurgeInactiveUsers.
+func (mr *MockPlayerStatesPersistorMockRecorder) PurgeInactiveUsers(ctx, inactiveSince interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PurgeInactiveUsers", reflect.TypeOf((*MockPlayerStatesPersistor)(nil).PurgeInactiveUsers), ctx, inactiveSince)
+}
+
+// PurgeTrash mocks base method.
+func (m *MockPlayerStatesPersistor) PurgeTrash(ctx context.Context, removedBefore time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PurgeTrash", ctx, removedBefore)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PurgeTrash indicates an expected call of PurgeTrash.
+func (mr *MockPlayerStatesPersistorMockRecorder) PurgeTrash(ctx, removedBefore interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PurgeTrash", reflect.TypeOf((*MockPlayerStatesPersistor)(nil).PurgeTrash), ctx, removedBefore)
+}
+
+// RemoveState mocks base method.
+func (m *MockPlayerStatesPersistor) RemoveState(ctx context.Context, userID string, revision int64, slot int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RemoveState", ctx, userID, revision, slot)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveState indicates an expected call of RemoveState.
+func (mr *MockPlayerStatesPersistorMockRecorder) RemoveState(ctx, userID, revision, slot interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveState", reflect.TypeOf((*MockPlayerStatesPersistor)(nil).RemoveState), ctx, userID, revision, slot)
+}
+
+// ReplaceState mocks base method.
+func (m *MockPlayerStatesPersistor) ReplaceState(ctx context.Context, userID string, revision int64, slot int, playerState *persistence.PlayerState) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReplaceState", ctx, userID, revision, slot, playerState)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReplaceState indicates an expected call of ReplaceState.
+func (mr *MockPlayerStatesPersistorMockRecorder) ReplaceState(ctx, userID, revision, slot, playerState interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReplaceState", reflect.TypeOf((*MockPlayerStatesPersistor)(nil).ReplaceState), ctx, userID, revision, slot, playerState)
+}
+
+// RestoreFromTrash mocks base method.
+func (m *MockPlayerStatesPersistor) RestoreFromTrash(ctx context.Context, userID, slotID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RestoreFromTrash", ctx, userID, slotID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RestoreFromTrash indicates an expected call of RestoreFromTrash.
+func (mr *MockPlayerStatesPersistorMockRecorder) RestoreFromTrash(ctx, userID, slotID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestoreFromTrash", reflect.TypeOf((*MockPlayerStatesPersistor)(nil).RestoreFromTrash), ctx, userID, slotID)
+}
+
+// SavePlayerStates mocks base method.
+func (m *MockPlayerStatesPersistor) SavePlayerStates(ctx context.Context, userID string, playerStates []*persistence.PlayerState) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SavePlayerStates", ctx, userID, playerStates)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SavePlayerStates indicates an expected call of SavePlayerStates.
+func (mr *MockPlayerStatesPersistorMockRecorder) SavePlayerStates(ctx, userID, playerStates interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FetchJSONDump", reflect.TypeOf((*MockPlayerStatesPersistor)(nil).FetchJSONDump), userID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SavePlayerStates", reflect.TypeOf((*MockPlayerStatesPersistor)(nil).SavePlayerStates), ctx, userID, playerStates)
 }
 
-// DeleteUserRecord mocks base method
-func (m *MockPlayerStatesPersistor) DeleteUserRecord(userID string) error {
+// SetPinned mocks base method.
+func (m *MockPlayerStatesPersistor) SetPinned(ctx context.Context, userID string, revision int64, slot int, pinned bool) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DeleteUserRecord", userID)
+	ret := m.ctrl.Call(m, "SetPinned", ctx, userID, revision, slot, pinned)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// DeleteUserRecord indicates an expected call of DeleteUserRecord
-func (mr *MockPlayerStatesPersistorMockRecorder) DeleteUserRecord(userID interface{}) *gomock.Call {
+// SetPinned indicates an expected call of SetPinned.
+func (mr *MockPlayerStatesPersistorMockRecorder) SetPinned(ctx, userID, revision, slot, pinned interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteUserRecord", reflect.TypeOf((*MockPlayerStatesPersistor)(nil).DeleteUserRecord), userID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetPinned", reflect.TypeOf((*MockPlayerStatesPersistor)(nil).SetPinned), ctx, userID, revision, slot, pinned)
 }
@@ -5,37 +5,40 @@
 package mocks
 
 import (
-	gomock "github.com/golang/mock/gomock"
-	spotify "github.com/zmb3/spotify"
-	oauth2 "golang.org/x/oauth2"
+	context "context"
 	http "net/http"
 	reflect "reflect"
+
+	spotify "github.com/florianloch/cassette/internal/spotify"
+	gomock "github.com/golang/mock/gomock"
+	spotify0 "github.com/zmb3/spotify"
+	oauth2 "golang.org/x/oauth2"
 )
 
-// MockSpotAuthenticator is a mock of SpotAuthenticator interface
+// MockSpotAuthenticator is a mock of SpotAuthenticator interface.
 type MockSpotAuthenticator struct {
 	ctrl     *gomock.Controller
 	recorder *MockSpotAuthenticatorMockRecorder
 }
 
-// MockSpotAuthenticatorMockRecorder is the mock recorder for MockSpotAuthenticator
+// MockSpotAuthenticatorMockRecorder is the mock recorder for MockSpotAuthenticator.
 type MockSpotAuthenticatorMockRecorder struct {
 	mock *MockSpotAuthenticator
 }
 
-// NewMockSpotAuthenticator creates a new mock instance
+// NewMockSpotAuthenticator creates a new mock instance.
 func NewMockSpotAuthenticator(ctrl *gomock.Controller) *MockSpotAuthenticator {
 	mock := &MockSpotAuthenticator{ctrl: ctrl}
 	mock.recorder = &MockSpotAuthenticatorMockRecorder{mock}
 	return mock
 }
 
-// EXPECT returns an object that allows the caller to indicate expected use
+// EXPECT returns an object that allows the caller to indicate expected use.
 func (m *MockSpotAuthenticator) EXPECT() *MockSpotAuthenticatorMockRecorder {
 	return m.recorder
 }
 
-// AuthURL mocks base method
+// AuthURL mocks base method.
 func (m *MockSpotAuthenticator) AuthURL(state string) string {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "AuthURL", state)
@@ -43,39 +46,39 @@ func (m *MockSpotAuthenticator) AuthURL(state string) string {
 	return ret0
 }
 
-// AuthURL indicates an expected call of AuthURL
+// AuthURL indicates an expected call of AuthURL.
 func (mr *MockSpotAuthenticatorMockRecorder) AuthURL(state interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AuthURL", reflect.TypeOf((*MockSpotAuthenticator)(nil).AuthURL), state)
 }
 
-// NewClient mocks base method
-func (m *MockSpotAuthenticator) NewClient(token *oauth2.Token) spotify.Client {
+// NewClient mocks base method.
+func (m *MockSpotAuthenticator) NewClient(token *oauth2.Token, onRefresh func(*oauth2.Token)) *http.Client {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "NewClient", token)
-	ret0, _ := ret[0].(spotify.Client)
+	ret := m.ctrl.Call(m, "NewClient", token, onRefresh)
+	ret0, _ := ret[0].(*http.Client)
 	return ret0
 }
 
-// NewClient indicates an expected call of NewClient
-func (mr *MockSpotAuthenticatorMockRecorder) NewClient(token interface{}) *gomock.Call {
+// NewClient indicates an expected call of NewClient.
+func (mr *MockSpotAuthenticatorMockRecorder) NewClient(token, onRefresh interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewClient", reflect.TypeOf((*MockSpotAuthenticator)(nil).NewClient), token)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewClient", reflect.TypeOf((*MockSpotAuthenticator)(nil).NewClient), token, onRefresh)
 }
 
-// SetAuthInfo mocks base method
+// SetAuthInfo mocks base method.
 func (m *MockSpotAuthenticator) SetAuthInfo(clientID, secretKey string) {
 	m.ctrl.T.Helper()
 	m.ctrl.Call(m, "SetAuthInfo", clientID, secretKey)
 }
 
-// SetAuthInfo indicates an expected call of SetAuthInfo
+// SetAuthInfo indicates an expected call of SetAuthInfo.
 func (mr *MockSpotAuthenticatorMockRecorder) SetAuthInfo(clientID, secretKey interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetAuthInfo", reflect.TypeOf((*MockSpotAuthenticator)(nil).SetAuthInfo), clientID, secretKey)
 }
 
-// Token mocks base method
+// Token mocks base method.
 func (m *MockSpotAuthenticator) Token(state string, r *http.Request) (*oauth2.Token, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "Token", state, r)
@@ -84,163 +87,223 @@ func (m *MockSpotAuthenticator) Token(state string, r *http.Request) (*oauth2.To
 	return ret0, ret1
 }
 
-// Token indicates an expected call of Token
+// Token indicates an expected call of Token.
 func (mr *MockSpotAuthenticatorMockRecorder) Token(state, r interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Token", reflect.TypeOf((*MockSpotAuthenticator)(nil).Token), state, r)
 }
 
-// MockSpotClient is a mock of SpotClient interface
+// MockSpotClient is a mock of SpotClient interface.
 type MockSpotClient struct {
 	ctrl     *gomock.Controller
 	recorder *MockSpotClientMockRecorder
 }
 
-// MockSpotClientMockRecorder is the mock recorder for MockSpotClient
+// MockSpotClientMockRecorder is the mock recorder for MockSpotClient.
 type MockSpotClientMockRecorder struct {
 	mock *MockSpotClient
 }
 
-// NewMockSpotClient creates a new mock instance
+// NewMockSpotClient creates a new mock instance.
 func NewMockSpotClient(ctrl *gomock.Controller) *MockSpotClient {
 	mock := &MockSpotClient{ctrl: ctrl}
 	mock.recorder = &MockSpotClientMockRecorder{mock}
 	return mock
 }
 
-// EXPECT returns an object that allows the caller to indicate expected use
+// EXPECT returns an object that allows the caller to indicate expected use.
 func (m *MockSpotClient) EXPECT() *MockSpotClientMockRecorder {
 	return m.recorder
 }
 
-// CurrentUser mocks base method
-func (m *MockSpotClient) CurrentUser() (*spotify.PrivateUser, error) {
+// CurrentUser mocks base method.
+func (m *MockSpotClient) CurrentUser(ctx context.Context) (*spotify0.PrivateUser, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CurrentUser")
-	ret0, _ := ret[0].(*spotify.PrivateUser)
+	ret := m.ctrl.Call(m, "CurrentUser", ctx)
+	ret0, _ := ret[0].(*spotify0.PrivateUser)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// CurrentUser indicates an expected call of CurrentUser
-func (mr *MockSpotClientMockRecorder) CurrentUser() *gomock.Call {
+// CurrentUser indicates an expected call of CurrentUser.
+func (mr *MockSpotClientMockRecorder) CurrentUser(ctx interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CurrentUser", reflect.TypeOf((*MockSpotClient)(nil).CurrentUser))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CurrentUser", reflect.TypeOf((*MockSpotClient)(nil).CurrentUser), ctx)
 }
 
-// GetAlbumTracksOpt mocks base method
-func (m *MockSpotClient) GetAlbumTracksOpt(id spotify.ID, opt *spotify.Options) (*spotify.SimpleTrackPage, error) {
+// CurrentUsersTracksOpt mocks base method.
+func (m *MockSpotClient) CurrentUsersTracksOpt(ctx context.Context, opt *spotify0.Options) (*spotify0.SavedTrackPage, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetAlbumTracksOpt", id, opt)
-	ret0, _ := ret[0].(*spotify.SimpleTrackPage)
+	ret := m.ctrl.Call(m, "CurrentUsersTracksOpt", ctx, opt)
+	ret0, _ := ret[0].(*spotify0.SavedTrackPage)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetAlbumTracksOpt indicates an expected call of GetAlbumTracksOpt
-func (mr *MockSpotClientMockRecorder) GetAlbumTracksOpt(id, opt interface{}) *gomock.Call {
+// CurrentUsersTracksOpt indicates an expected call of CurrentUsersTracksOpt.
+func (mr *MockSpotClientMockRecorder) CurrentUsersTracksOpt(ctx, opt interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAlbumTracksOpt", reflect.TypeOf((*MockSpotClient)(nil).GetAlbumTracksOpt), id, opt)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CurrentUsersTracksOpt", reflect.TypeOf((*MockSpotClient)(nil).CurrentUsersTracksOpt), ctx, opt)
 }
 
-// GetPlaylistOpt mocks base method
-func (m *MockSpotClient) GetPlaylistOpt(playlistID spotify.ID, fields string) (*spotify.FullPlaylist, error) {
+// GetAlbumTracksOpt mocks base method.
+func (m *MockSpotClient) GetAlbumTracksOpt(ctx context.Context, id spotify0.ID, opt *spotify0.Options) (*spotify0.SimpleTrackPage, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetPlaylistOpt", playlistID, fields)
-	ret0, _ := ret[0].(*spotify.FullPlaylist)
+	ret := m.ctrl.Call(m, "GetAlbumTracksOpt", ctx, id, opt)
+	ret0, _ := ret[0].(*spotify0.SimpleTrackPage)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetPlaylistOpt indicates an expected call of GetPlaylistOpt
-func (mr *MockSpotClientMockRecorder) GetPlaylistOpt(playlistID, fields interface{}) *gomock.Call {
+// GetAlbumTracksOpt indicates an expected call of GetAlbumTracksOpt.
+func (mr *MockSpotClientMockRecorder) GetAlbumTracksOpt(ctx, id, opt interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPlaylistOpt", reflect.TypeOf((*MockSpotClient)(nil).GetPlaylistOpt), playlistID, fields)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAlbumTracksOpt", reflect.TypeOf((*MockSpotClient)(nil).GetAlbumTracksOpt), ctx, id, opt)
 }
 
-// GetPlaylistTracksOpt mocks base method
-func (m *MockSpotClient) GetPlaylistTracksOpt(playlistID spotify.ID, opt *spotify.Options, fields string) (*spotify.PlaylistTrackPage, error) {
+// GetAudiobook mocks base method.
+func (m *MockSpotClient) GetAudiobook(ctx context.Context, id spotify0.ID) (*spotify.Audiobook, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetPlaylistTracksOpt", playlistID, opt, fields)
-	ret0, _ := ret[0].(*spotify.PlaylistTrackPage)
+	ret := m.ctrl.Call(m, "GetAudiobook", ctx, id)
+	ret0, _ := ret[0].(*spotify.Audiobook)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetPlaylistTracksOpt indicates an expected call of GetPlaylistTracksOpt
-func (mr *MockSpotClientMockRecorder) GetPlaylistTracksOpt(playlistID, opt, fields interface{}) *gomock.Call {
+// GetAudiobook indicates an expected call of GetAudiobook.
+func (mr *MockSpotClientMockRecorder) GetAudiobook(ctx, id interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPlaylistTracksOpt", reflect.TypeOf((*MockSpotClient)(nil).GetPlaylistTracksOpt), playlistID, opt, fields)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAudiobook", reflect.TypeOf((*MockSpotClient)(nil).GetAudiobook), ctx, id)
 }
 
-// Pause mocks base method
-func (m *MockSpotClient) Pause() error {
+// GetAudiobookChaptersOpt mocks base method.
+func (m *MockSpotClient) GetAudiobookChaptersOpt(ctx context.Context, id spotify0.ID, opt *spotify0.Options) (*spotify.ChapterPage, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Pause")
-	ret0, _ := ret[0].(error)
-	return ret0
+	ret := m.ctrl.Call(m, "GetAudiobookChaptersOpt", ctx, id, opt)
+	ret0, _ := ret[0].(*spotify.ChapterPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
-// Pause indicates an expected call of Pause
-func (mr *MockSpotClientMockRecorder) Pause() *gomock.Call {
+// GetAudiobookChaptersOpt indicates an expected call of GetAudiobookChaptersOpt.
+func (mr *MockSpotClientMockRecorder) GetAudiobookChaptersOpt(ctx, id, opt interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Pause", reflect.TypeOf((*MockSpotClient)(nil).Pause))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAudiobookChaptersOpt", reflect.TypeOf((*MockSpotClient)(nil).GetAudiobookChaptersOpt), ctx, id, opt)
 }
 
-// PlayerState mocks base method
-func (m *MockSpotClient) PlayerState() (*spotify.PlayerState, error) {
+// GetPlaylistOpt mocks base method.
+func (m *MockSpotClient) GetPlaylistOpt(ctx context.Context, playlistID spotify0.ID, fields string) (*spotify0.FullPlaylist, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "PlayerState")
-	ret0, _ := ret[0].(*spotify.PlayerState)
+	ret := m.ctrl.Call(m, "GetPlaylistOpt", ctx, playlistID, fields)
+	ret0, _ := ret[0].(*spotify0.FullPlaylist)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPlaylistOpt indicates an expected call of GetPlaylistOpt.
+func (mr *MockSpotClientMockRecorder) GetPlaylistOpt(ctx, playlistID, fields interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPlaylistOpt", reflect.TypeOf((*MockSpotClient)(nil).GetPlaylistOpt), ctx, playlistID, fields)
+}
+
+// GetPlaylistTracksOpt mocks base method.
+func (m *MockSpotClient) GetPlaylistTracksOpt(ctx context.Context, playlistID spotify0.ID, opt *spotify0.Options, fields string) (*spotify0.PlaylistTrackPage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPlaylistTracksOpt", ctx, playlistID, opt, fields)
+	ret0, _ := ret[0].(*spotify0.PlaylistTrackPage)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// PlayerState indicates an expected call of PlayerState
-func (mr *MockSpotClientMockRecorder) PlayerState() *gomock.Call {
+// GetPlaylistTracksOpt indicates an expected call of GetPlaylistTracksOpt.
+func (mr *MockSpotClientMockRecorder) GetPlaylistTracksOpt(ctx, playlistID, opt, fields interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PlayerState", reflect.TypeOf((*MockSpotClient)(nil).PlayerState))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPlaylistTracksOpt", reflect.TypeOf((*MockSpotClient)(nil).GetPlaylistTracksOpt), ctx, playlistID, opt, fields)
 }
 
-// PlayerDevices mocks base method
-func (m *MockSpotClient) PlayerDevices() ([]spotify.PlayerDevice, error) {
+// GetShowEpisodesOpt mocks base method.
+func (m *MockSpotClient) GetShowEpisodesOpt(ctx context.Context, id spotify0.ID, opt *spotify0.Options) (*spotify0.SimpleEpisodePage, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "PlayerDevices")
-	ret0, _ := ret[0].([]spotify.PlayerDevice)
+	ret := m.ctrl.Call(m, "GetShowEpisodesOpt", ctx, id, opt)
+	ret0, _ := ret[0].(*spotify0.SimpleEpisodePage)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// PlayerDevices indicates an expected call of PlayerDevices
-func (mr *MockSpotClientMockRecorder) PlayerDevices() *gomock.Call {
+// GetShowEpisodesOpt indicates an expected call of GetShowEpisodesOpt.
+func (mr *MockSpotClientMockRecorder) GetShowEpisodesOpt(ctx, id, opt interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PlayerDevices", reflect.TypeOf((*MockSpotClient)(nil).PlayerDevices))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetShowEpisodesOpt", reflect.TypeOf((*MockSpotClient)(nil).GetShowEpisodesOpt), ctx, id, opt)
 }
 
-// PlayOpt mocks base method
-func (m *MockSpotClient) PlayOpt(opt *spotify.PlayOptions) error {
+// Pause mocks base method.
+func (m *MockSpotClient) Pause(ctx context.Context) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "PlayOpt", opt)
+	ret := m.ctrl.Call(m, "Pause", ctx)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// PlayOpt indicates an expected call of PlayOpt
-func (mr *MockSpotClientMockRecorder) PlayOpt(opt interface{}) *gomock.Call {
+// Pause indicates an expected call of Pause.
+func (mr *MockSpotClientMockRecorder) Pause(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Pause", reflect.TypeOf((*MockSpotClient)(nil).Pause), ctx)
+}
+
+// PlayOpt mocks base method.
+func (m *MockSpotClient) PlayOpt(ctx context.Context, opt *spotify0.PlayOptions) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PlayOpt", ctx, opt)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PlayOpt indicates an expected call of PlayOpt.
+func (mr *MockSpotClientMockRecorder) PlayOpt(ctx, opt interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PlayOpt", reflect.TypeOf((*MockSpotClient)(nil).PlayOpt), ctx, opt)
+}
+
+// PlayerDevices mocks base method.
+func (m *MockSpotClient) PlayerDevices(ctx context.Context) ([]spotify0.PlayerDevice, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PlayerDevices", ctx)
+	ret0, _ := ret[0].([]spotify0.PlayerDevice)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PlayerDevices indicates an expected call of PlayerDevices.
+func (mr *MockSpotClientMockRecorder) PlayerDevices(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PlayerDevices", reflect.TypeOf((*MockSpotClient)(nil).PlayerDevices), ctx)
+}
+
+// PlayerState mocks base method.
+func (m *MockSpotClient) PlayerState(ctx context.Context) (*spotify.PlayerState, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PlayerState", ctx)
+	ret0, _ := ret[0].(*spotify.PlayerState)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PlayerState indicates an expected call of PlayerState.
+func (mr *MockSpotClientMockRecorder) PlayerState(ctx interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PlayOpt", reflect.TypeOf((*MockSpotClient)(nil).PlayOpt), opt)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PlayerState", reflect.TypeOf((*MockSpotClient)(nil).PlayerState), ctx)
 }
 
-// Shuffle mocks base method
-func (m *MockSpotClient) Shuffle(shuffle bool) error {
+// Shuffle mocks base method.
+func (m *MockSpotClient) Shuffle(ctx context.Context, shuffle bool) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Shuffle", shuffle)
+	ret := m.ctrl.Call(m, "Shuffle", ctx, shuffle)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// Shuffle indicates an expected call of Shuffle
-func (mr *MockSpotClientMockRecorder) Shuffle(shuffle interface{}) *gomock.Call {
+// Shuffle indicates an expected call of Shuffle.
+func (mr *MockSpotClientMockRecorder) Shuffle(ctx, shuffle interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Shuffle", reflect.TypeOf((*MockSpotClient)(nil).Shuffle), shuffle)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Shuffle", reflect.TypeOf((*MockSpotClient)(nil).Shuffle), ctx, shuffle)
 }
@@ -1,26 +1,61 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/florianloch/cassette/internal/constants"
 	"github.com/florianloch/cassette/internal/persistence"
 	"github.com/florianloch/cassette/internal/spotify"
+	"github.com/go-chi/chi"
 	"github.com/rs/zerolog/hlog"
 	spotifyAPI "github.com/zmb3/spotify"
 )
 
+const (
+	importModeMerge   = "merge"
+	importModeReplace = "replace"
+	// Dumps are small, even hundreds of slots including their history are way below this
+	maxDumpSize = 4 << 20
+)
+
+var errInvalidRevision = errors.New("invalid revision")
+
+// restoreReport tells how the item to resume has been located, see spotify.RestoreStrategy.
+type restoreReport struct {
+	Strategy spotify.RestoreStrategy `json:"strategy"`
+}
+
+// importReport tells the client what has become of the slots contained in an imported dump.
+type importReport struct {
+	Mode     string                     `json:"mode"`
+	Imported int                        `json:"imported"`
+	Skipped  []skippedEntry             `json:"skipped"` // valid slots which have not been imported
+	Invalid  []persistence.InvalidEntry `json:"invalid"`
+}
+
+type skippedEntry struct {
+	ID     string `json:"id"`
+	Reason string `json:"reason"`
+}
+
 func ActiveDevicesHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	spotifyClient := ctx.Value(constants.FieldKeySpotifyClient).(spotify.SpotClient)
 
-	playerDevices, err := spotify.ActiveSpotifyDevices(spotifyClient)
+	playerDevices, err := spotify.ActiveSpotifyDevices(ctx, spotifyClient)
 
 	if err != nil {
-		hlog.FromRequest(r).Error().Err(err).Msg("Could not fetch list of active devices.")
-		http.Error(w, "Could not fetch list of active devices from Spotify!", http.StatusInternalServerError)
+		RespondWithSpotifyError(w, r, err, "Could not fetch list of active devices from Spotify!", http.StatusInternalServerError)
+		return
 	}
 
 	jsonBytes, err := json.Marshal(playerDevices)
@@ -44,54 +79,45 @@ func PlayerStatesPostHandler(w http.ResponseWriter, r *http.Request) {
 	user := ctx.Value(constants.FieldKeyUser).(*spotifyAPI.PrivateUser)
 	spotifyClient := ctx.Value(constants.FieldKeySpotifyClient).(spotify.SpotClient)
 	dao := ctx.Value(constants.FieldKeyDao).(persistence.PlayerStatesPersistor)
-	slot, ok := ctx.Value(constants.FieldKeySlot).(int)
-	if !ok {
-		slot = -1
+	slotRef, replace := ctx.Value(constants.FieldKeySlot).(SlotRef)
+	contextCache, _ := ctx.Value(constants.FieldKeyContextCache).(*spotify.ContextCache)
+
+	// Where the user has been when saving the context last time hints at where to look for the current item
+	previousStates, _, err := dao.LoadPlayerStates(ctx, user.ID)
+	if err != nil {
+		// No need to stop processing this request because of this error...
+		hlog.FromRequest(r).Warn().Err(err).Msg("Could not load previous player states for locating the current item.")
 	}
 
-	currentState, err := spotify.CurrentPlayerState(spotifyClient)
+	currentState, err := spotify.CurrentPlayerState(ctx, spotifyClient, contextCache, previousStates)
 	if err != nil {
 		if err == spotify.ErrContextNotSuspendable {
 			hlog.FromRequest(r).Debug().Err(err).Msg("Requested player state resp. its context cannot be suspended.")
-			http.Error(w, "Only albums and playlists can be suspended.", http.StatusBadRequest)
+			http.Error(w, "Only albums, playlists, Liked Songs, artists, podcasts and audiobooks can be suspended.", http.StatusBadRequest)
 		} else {
-			hlog.FromRequest(r).Error().Err(err).Msg("Failed to get current state of player.")
-			http.Error(w, "Could not retrieve player state from Spotify. Please make sure your device is playing and online.", http.StatusInternalServerError)
+			RespondWithSpotifyError(w, r, err, "Could not retrieve player state from Spotify. Please make sure your device is playing and online.", http.StatusInternalServerError)
 		}
 		return
 	}
 
-	playerStates, err := dao.LoadPlayerStates(user.ID)
-	if err != nil {
-		hlog.FromRequest(r).Error().Err(err).Msg("Failed loading player states from DB.")
-		http.Error(w, "Could not retrieve player states from DB.", http.StatusInternalServerError)
-		return
-	}
-
-	// replace, if < 0 then append a new slot
-	if slot >= 0 {
-		if slot >= len(playerStates) {
-			http.Error(w, "'slot' is not in the range of existing slots.", http.StatusBadRequest)
-			hlog.FromRequest(r).Debug().Int("slot", slot).Msg("Slot is out of range.")
-			return
+	// replace in case a slot is given, otherwise append a new one
+	if replace {
+		var slot int
+		var revision int64
+		_, slot, revision, err = resolveSlot(ctx, r, dao, user.ID, slotRef)
+		if err == nil {
+			err = dao.ReplaceState(ctx, user.ID, revision, slot, currentState)
 		}
-
-		playerStates[slot] = currentState
 	} else {
-		playerStates = append(playerStates, currentState)
+		err = dao.AppendState(ctx, user.ID, currentState)
 	}
 
-	err = dao.SavePlayerStates(user.ID, playerStates)
 	if err != nil {
-		hlog.FromRequest(r).Error().
-			Err(err).
-			Interface("playerStates", playerStates).
-			Msg("Could not persist player states in DB.")
-		http.Error(w, "Could not persist player states in DB.", http.StatusInternalServerError)
+		respondWithPersistenceError(w, r, err, "Could not persist player state in DB.")
 		return
 	}
 
-	err = spotifyClient.Pause()
+	err = spotifyClient.Pause(ctx)
 	if err != nil {
 		// No serious error, we do not need to tell the client
 		hlog.FromRequest(r).Debug().Err(err).Msg("Could not pause player.")
@@ -105,13 +131,16 @@ func PlayerStatesGetHandler(w http.ResponseWriter, r *http.Request) {
 	user := ctx.Value(constants.FieldKeyUser).(*spotifyAPI.PrivateUser)
 	dao := ctx.Value(constants.FieldKeyDao).(persistence.PlayerStatesPersistor)
 
-	playerStates, err := dao.LoadPlayerStates(user.ID)
+	playerStates, revision, err := dao.LoadPlayerStates(ctx, user.ID)
 	if err != nil {
 		hlog.FromRequest(r).Error().Err(err).Msg("Failed loading player states from DB.")
 		http.Error(w, "Could not retrieve player states from DB.", http.StatusInternalServerError)
 		return
 	}
 
+	// Clients pass the revision back via 'If-Match' so modifications based on an outdated list get rejected
+	w.Header().Set("ETag", strconv.Quote(strconv.FormatInt(revision, 10)))
+
 	json, err := json.Marshal(playerStates)
 	if err != nil {
 		hlog.FromRequest(r).Error().
@@ -129,33 +158,77 @@ func PlayerStatesDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	user := ctx.Value(constants.FieldKeyUser).(*spotifyAPI.PrivateUser)
 	dao := ctx.Value(constants.FieldKeyDao).(persistence.PlayerStatesPersistor)
-	slot := ctx.Value(constants.FieldKeySlot).(int)
+	slotRef := ctx.Value(constants.FieldKeySlot).(SlotRef)
+
+	_, slot, revision, err := resolveSlot(ctx, r, dao, user.ID, slotRef)
+	if err == nil {
+		err = dao.RemoveState(ctx, user.ID, revision, slot)
+	}
 
-	playerStates, err := dao.LoadPlayerStates(user.ID)
 	if err != nil {
-		hlog.FromRequest(r).Error().Err(err).Msg("Failed loading player states from DB.")
-		http.Error(w, "Could not retrieve player states from DB.", http.StatusInternalServerError)
-		return
+		respondWithPersistenceError(w, r, err, "Could not delete player state in DB.")
 	}
+}
 
-	if slot >= len(playerStates) {
-		hlog.FromRequest(r).Debug().
-			Int("slot", slot).
-			Interface("playerStates", playerStates).
-			Msg("Unable to delete player state - slot out of range.")
-		http.Error(w, "'slot' is not in the range of existing slots.", http.StatusInternalServerError)
+func PlayerStatesPinHandler(w http.ResponseWriter, r *http.Request) {
+	setPinned(w, r, true)
+}
+
+func PlayerStatesUnpinHandler(w http.ResponseWriter, r *http.Request) {
+	setPinned(w, r, false)
+}
+
+func setPinned(w http.ResponseWriter, r *http.Request, pinned bool) {
+	ctx := r.Context()
+	user := ctx.Value(constants.FieldKeyUser).(*spotifyAPI.PrivateUser)
+	dao := ctx.Value(constants.FieldKeyDao).(persistence.PlayerStatesPersistor)
+	slotRef := ctx.Value(constants.FieldKeySlot).(SlotRef)
+
+	_, slot, revision, err := resolveSlot(ctx, r, dao, user.ID, slotRef)
+	if err == nil {
+		err = dao.SetPinned(ctx, user.ID, revision, slot, pinned)
+	}
+
+	if err != nil {
+		respondWithPersistenceError(w, r, err, "Could not pin resp. unpin player state in DB.")
+	}
+}
+
+// NewQuotaHandler returns a handler telling the client how many slots are allowed per user and
+// what happens when adding more.
+func NewQuotaHandler(quota persistence.Quota) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		json, err := json.Marshal(quota)
+		if err != nil {
+			hlog.FromRequest(r).Error().Err(err).Interface("quota", quota).Msg("Could not serialize quota to JSON.")
+			http.Error(w, "Failed to provide quota as JSON.", http.StatusInternalServerError)
+			return
+		}
+
+		respondWithJSON(w, r, json)
+	}
+}
+
+func PlayerStatesMoveHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := ctx.Value(constants.FieldKeyUser).(*spotifyAPI.PrivateUser)
+	dao := ctx.Value(constants.FieldKeyDao).(persistence.PlayerStatesPersistor)
+	slotRef := ctx.Value(constants.FieldKeySlot).(SlotRef)
+
+	to, err := strconv.Atoi(r.URL.Query().Get("to"))
+	if err != nil {
+		hlog.FromRequest(r).Debug().Err(err).Msg("Could not retrieve target slot from request.")
+		http.Error(w, "Query parameter 'to' is not a valid integer.", http.StatusBadRequest)
 		return
 	}
 
-	playerStates = append(playerStates[:slot], playerStates[slot+1:]...)
+	_, slot, revision, err := resolveSlot(ctx, r, dao, user.ID, slotRef)
+	if err == nil {
+		err = dao.MoveState(ctx, user.ID, revision, slot, to)
+	}
 
-	err = dao.SavePlayerStates(user.ID, playerStates)
 	if err != nil {
-		hlog.FromRequest(r).Error().
-			Err(err).
-			Interface("playerStates", playerStates).
-			Msg("Could not persist player states in DB.")
-		http.Error(w, "Could not persist player states in DB.", http.StatusInternalServerError)
+		respondWithPersistenceError(w, r, err, "Could not move player state in DB.")
 	}
 }
 
@@ -164,26 +237,17 @@ func PlayerStatesRestoreHandler(w http.ResponseWriter, r *http.Request) {
 	user := ctx.Value(constants.FieldKeyUser).(*spotifyAPI.PrivateUser)
 	spotifyClient := ctx.Value(constants.FieldKeySpotifyClient).(spotify.SpotClient)
 	dao := ctx.Value(constants.FieldKeyDao).(persistence.PlayerStatesPersistor)
-	slot := ctx.Value(constants.FieldKeySlot).(int)
+	slotRef := ctx.Value(constants.FieldKeySlot).(SlotRef)
+	contextCache, _ := ctx.Value(constants.FieldKeyContextCache).(*spotify.ContextCache)
 
 	deviceID := r.URL.Query().Get("deviceID")
-	playerStates, err := dao.LoadPlayerStates(user.ID)
+	playerStates, slot, _, err := resolveSlot(ctx, r, dao, user.ID, slotRef)
 	if err != nil {
-		hlog.FromRequest(r).Error().Err(err).Msg("Failed loading player states from DB.")
-		http.Error(w, "Could not retrieve player states from DB.", http.StatusInternalServerError)
+		respondWithPersistenceError(w, r, err, "Could not retrieve player states from DB.")
 		return
 	}
 
-	if slot >= len(playerStates) {
-		hlog.FromRequest(r).Debug().
-			Int("slot", slot).
-			Interface("playerStates", playerStates).
-			Msg("Unable to restore player state. Slot out of range.")
-		http.Error(w, "'slot' is not in the range of existing slots.", http.StatusBadRequest)
-		return
-	}
-
-	err = spotifyClient.Pause()
+	err = spotifyClient.Pause(ctx)
 	if err != nil {
 		// No serious error, we do not need to tell the client, he might notice anyway
 		hlog.FromRequest(r).Debug().Err(err).Msg("Could not pause player.")
@@ -191,7 +255,7 @@ func PlayerStatesRestoreHandler(w http.ResponseWriter, r *http.Request) {
 
 	stateToRestore := playerStates[slot]
 
-	err = spotify.RestorePlayerState(spotifyClient, stateToRestore, deviceID)
+	strategy, err := spotify.RestorePlayerState(ctx, spotifyClient, contextCache, stateToRestore, deviceID)
 	if err != nil {
 		hlog.FromRequest(r).Debug().
 			Err(err).
@@ -199,7 +263,128 @@ func PlayerStatesRestoreHandler(w http.ResponseWriter, r *http.Request) {
 			Str("deviceID", deviceID).
 			Interface("stateToRestore", stateToRestore).
 			Msg("Could not restore player state.")
-		http.Error(w, "Could not restore player state. Please check that there is at least one active device.", http.StatusBadRequest)
+		RespondWithSpotifyError(w, r, err, "Could not restore player state.", http.StatusBadRequest)
+		return
+	}
+
+	if strategy != spotify.RestoreStrategyURI {
+		hlog.FromRequest(r).Debug().
+			Int("slot", slot).
+			Str("strategy", string(strategy)).
+			Msg("Saved item could not be found in its context anymore.")
+	}
+
+	// Tells the client whether the saved item has been resumed, otherwise the user should be warned
+	json, err := json.Marshal(restoreReport{Strategy: strategy})
+	if err != nil {
+		hlog.FromRequest(r).Error().Err(err).Msg("Could not serialize restore report to JSON.")
+		return
+	}
+
+	respondWithJSON(w, r, json)
+}
+
+// PlayerStatesHistoryHandler lists the previous states of a slot, the most recent one first.
+func PlayerStatesHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := ctx.Value(constants.FieldKeyUser).(*spotifyAPI.PrivateUser)
+	dao := ctx.Value(constants.FieldKeyDao).(persistence.PlayerStatesPersistor)
+	slotRef := ctx.Value(constants.FieldKeySlot).(SlotRef)
+
+	playerStates, slot, revision, err := resolveSlot(ctx, r, dao, user.ID, slotRef)
+	if err != nil {
+		respondWithPersistenceError(w, r, err, "Could not retrieve player states from DB.")
+		return
+	}
+
+	history := playerStates[slot].History
+	if history == nil {
+		history = []*persistence.PlayerState{}
+	}
+
+	// Reverting is a modification of the slot, hence clients need the revision for it too
+	w.Header().Set("ETag", strconv.Quote(strconv.FormatInt(revision, 10)))
+
+	json, err := json.Marshal(history)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Interface("history", history).
+			Msg("Could not serialize history of slot to JSON.")
+		http.Error(w, "Failed to provide history of slot as JSON.", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, r, json)
+}
+
+// PlayerStatesRevertHandler replaces a slot with the n-th entry of its history. As the current state
+// becomes part of the history in turn, reverting can be undone.
+func PlayerStatesRevertHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := ctx.Value(constants.FieldKeyUser).(*spotifyAPI.PrivateUser)
+	dao := ctx.Value(constants.FieldKeyDao).(persistence.PlayerStatesPersistor)
+	slotRef := ctx.Value(constants.FieldKeySlot).(SlotRef)
+
+	n, err := strconv.Atoi(chi.URLParam(r, "n"))
+	if err != nil || n < 0 {
+		hlog.FromRequest(r).Debug().Err(err).Msg("Could not retrieve history entry from request.")
+		http.Error(w, "'n' is not a valid index into the history of the slot.", http.StatusBadRequest)
+		return
+	}
+
+	playerStates, slot, revision, err := resolveSlot(ctx, r, dao, user.ID, slotRef)
+	if err != nil {
+		respondWithPersistenceError(w, r, err, "Could not retrieve player states from DB.")
+		return
+	}
+
+	history := playerStates[slot].History
+	if n >= len(history) {
+		hlog.FromRequest(r).Debug().Int("n", n).Int("historyLength", len(history)).Msg("History entry does not exist.")
+		http.Error(w, "'n' does not refer to an existing entry of the history of the slot.", http.StatusBadRequest)
+		return
+	}
+
+	if err := dao.ReplaceState(ctx, user.ID, revision, slot, history[n]); err != nil {
+		respondWithPersistenceError(w, r, err, "Could not revert player state in DB.")
+	}
+}
+
+// TrashGetHandler lists the removed slots of the user, the most recently removed one first.
+func TrashGetHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := ctx.Value(constants.FieldKeyUser).(*spotifyAPI.PrivateUser)
+	dao := ctx.Value(constants.FieldKeyDao).(persistence.PlayerStatesPersistor)
+
+	trash, err := dao.LoadTrash(ctx, user.ID)
+	if err != nil {
+		hlog.FromRequest(r).Error().Err(err).Msg("Failed loading trash from DB.")
+		http.Error(w, "Could not retrieve trash from DB.", http.StatusInternalServerError)
+		return
+	}
+
+	json, err := json.Marshal(trash)
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Interface("trash", trash).
+			Msg("Could not serialize trash to JSON.")
+		http.Error(w, "Failed to provide trash as JSON.", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, r, json)
+}
+
+// TrashRestoreHandler moves a removed slot out of the trash, appending it to the slots of the user.
+func TrashRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := ctx.Value(constants.FieldKeyUser).(*spotifyAPI.PrivateUser)
+	dao := ctx.Value(constants.FieldKeyDao).(persistence.PlayerStatesPersistor)
+
+	if err := dao.RestoreFromTrash(ctx, user.ID, chi.URLParam(r, "id")); err != nil {
+		respondWithPersistenceError(w, r, err, "Could not restore player state from trash in DB.")
 	}
 }
 
@@ -208,7 +393,7 @@ func UserExportHandler(w http.ResponseWriter, r *http.Request) {
 	user := ctx.Value(constants.FieldKeyUser).(*spotifyAPI.PrivateUser)
 	dao := ctx.Value(constants.FieldKeyDao).(persistence.PlayerStatesPersistor)
 
-	json, err := dao.FetchJSONDump(user.ID)
+	json, err := dao.FetchJSONDump(ctx, user.ID)
 	if err != nil {
 		if errors.Is(err, persistence.ErrUserNotFound) {
 			hlog.FromRequest(r).Debug().Msg("User requested to exports her/his data - but nothing found in DB.")
@@ -224,12 +409,138 @@ func UserExportHandler(w http.ResponseWriter, r *http.Request) {
 	respondWithJSON(w, r, json)
 }
 
+// NewUserImportHandler returns a handler loading a dump as provided by UserExportHandler back into the DB. In mode
+// "merge", the default, the slots of the dump get appended to the existing ones unless there is a slot with the same
+// ID already. In mode "replace" the slots of the dump replace all existing ones. In both modes slots not fitting
+// into the quota are skipped, existing ones never get evicted for making room.
+func NewUserImportHandler(quota persistence.Quota) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		importUserData(w, r, quota)
+	}
+}
+
+func importUserData(w http.ResponseWriter, r *http.Request, quota persistence.Quota) {
+	ctx := r.Context()
+	user := ctx.Value(constants.FieldKeyUser).(*spotifyAPI.PrivateUser)
+	dao := ctx.Value(constants.FieldKeyDao).(persistence.PlayerStatesPersistor)
+
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = importModeMerge
+	}
+	if mode != importModeMerge && mode != importModeReplace {
+		http.Error(w, fmt.Sprintf("Query parameter 'mode' has to be either '%s' or '%s'.", importModeMerge, importModeReplace), http.StatusBadRequest)
+		return
+	}
+
+	dump, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxDumpSize))
+	if err != nil {
+		hlog.FromRequest(r).Debug().Err(err).Msg("Could not read dump from request.")
+		http.Error(w, "Could not read dump from request.", http.StatusBadRequest)
+		return
+	}
+
+	playerStates, invalid, err := persistence.ParseDump(dump)
+	if err != nil {
+		hlog.FromRequest(r).Debug().Err(err).Msg("Could not parse dump.")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report := importReport{Mode: mode, Skipped: make([]skippedEntry, 0), Invalid: invalid}
+
+	existingIDs := make(map[string]bool)
+	room := quota.MaxSlots
+	if mode == importModeMerge {
+		existing, _, err := dao.LoadPlayerStates(ctx, user.ID)
+		if err != nil {
+			hlog.FromRequest(r).Error().Err(err).Msg("Failed loading player states from DB.")
+			http.Error(w, "Could not retrieve player states from DB.", http.StatusInternalServerError)
+			return
+		}
+
+		for _, playerState := range existing {
+			existingIDs[playerState.ID] = true
+		}
+		room -= len(existing)
+	}
+
+	toImport := make([]*persistence.PlayerState, 0, len(playerStates))
+	seenIDs := make(map[string]bool)
+	for _, playerState := range playerStates {
+		switch {
+		case existingIDs[playerState.ID]:
+			report.Skipped = append(report.Skipped, skippedEntry{playerState.ID, "slot exists already"})
+		case seenIDs[playerState.ID]:
+			report.Skipped = append(report.Skipped, skippedEntry{playerState.ID, "slot is contained in dump more than once"})
+		default:
+			toImport = append(toImport, playerState)
+		}
+
+		// Dumps of older versions might contain slots without ID, these get one assigned when being stored
+		if playerState.ID != "" {
+			seenIDs[playerState.ID] = true
+		}
+	}
+
+	// Slots not fitting into the quota get skipped, existing ones never get evicted for making room
+	if quota.MaxSlots > 0 && len(toImport) > max(room, 0) {
+		for _, notImported := range toImport[max(room, 0):] {
+			report.Skipped = append(report.Skipped, skippedEntry{notImported.ID, "maximum number of slots reached"})
+		}
+		toImport = toImport[:max(room, 0)]
+	}
+
+	if mode == importModeReplace {
+		err = dao.SavePlayerStates(ctx, user.ID, toImport)
+		if err == nil {
+			report.Imported = len(toImport)
+		}
+	} else {
+		for i, playerState := range toImport {
+			if err = dao.AppendState(ctx, user.ID, playerState); err != nil {
+				// Slots might have been added concurrently
+				if errors.Is(err, persistence.ErrQuotaExceeded) {
+					for _, notImported := range toImport[i:] {
+						report.Skipped = append(report.Skipped, skippedEntry{notImported.ID, "maximum number of slots reached"})
+					}
+					err = nil
+				}
+
+				break
+			}
+
+			report.Imported++
+		}
+	}
+
+	if errors.Is(err, persistence.ErrQuotaExceeded) {
+		respondWithPersistenceError(w, r, err, "Could not import dump into DB.")
+		return
+	}
+
+	if err != nil {
+		hlog.FromRequest(r).Error().Err(err).Int("imported", report.Imported).Msg("Failed importing dump.")
+		http.Error(w, fmt.Sprintf("Could not import dump into DB, %d slot(s) have been imported.", report.Imported), http.StatusInternalServerError)
+		return
+	}
+
+	json, err := json.Marshal(report)
+	if err != nil {
+		hlog.FromRequest(r).Error().Err(err).Interface("report", report).Msg("Could not serialize import report to JSON.")
+		http.Error(w, "Failed to provide import report as JSON.", http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, r, json)
+}
+
 func UserDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	user := ctx.Value(constants.FieldKeyUser).(*spotifyAPI.PrivateUser)
 	dao := ctx.Value(constants.FieldKeyDao).(persistence.PlayerStatesPersistor)
 
-	err := dao.DeleteUserRecord(user.ID)
+	err := dao.DeleteUserRecord(ctx, user.ID)
 	if err != nil {
 		if errors.Is(err, persistence.ErrUserNotFound) {
 			hlog.FromRequest(r).Debug().Msg("User requested to delete her/his data - but nothing found in DB.")
@@ -241,6 +552,121 @@ func UserDeleteHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// SlotRef addresses a slot either by its stable ID or, deprecated, by its index.
+type SlotRef struct {
+	ID    string
+	Index int
+}
+
+// resolveSlot loads the player states of the user and determines the index of the referenced slot.
+// In case the client sends 'If-Match' the states have to be at the revision given there.
+func resolveSlot(ctx context.Context, r *http.Request, dao persistence.PlayerStatesPersistor, userID string, slotRef SlotRef) ([]*persistence.PlayerState, int, int64, error) {
+	playerStates, revision, err := dao.LoadPlayerStates(ctx, userID)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		expectedRevision, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`), 10, 64)
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("%w: 'If-Match' does not contain a valid revision", errInvalidRevision)
+		}
+
+		if expectedRevision != revision {
+			return nil, 0, 0, persistence.ErrConflict
+		}
+	}
+
+	if slotRef.ID == "" {
+		if slotRef.Index >= len(playerStates) {
+			return nil, 0, 0, persistence.ErrSlotNotFound
+		}
+
+		return playerStates, slotRef.Index, revision, nil
+	}
+
+	for i, playerState := range playerStates {
+		if playerState.ID == slotRef.ID {
+			return playerStates, i, revision, nil
+		}
+	}
+
+	return nil, 0, 0, persistence.ErrSlotNotFound
+}
+
+// respondWithPersistenceError maps the errors of the per-slot operations to the matching status codes.
+func respondWithPersistenceError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	switch {
+	case errors.Is(err, persistence.ErrConflict):
+		hlog.FromRequest(r).Debug().Err(err).Msg("Player states have been modified concurrently.")
+		http.Error(w, "Your player states have been changed in the meantime (e.g. on another device). Please reload and try again.", http.StatusConflict)
+	case errors.Is(err, persistence.ErrSlotNotFound):
+		hlog.FromRequest(r).Debug().Err(err).Msg("Slot does not exist.")
+		http.Error(w, "'slot' does not refer to an existing slot.", http.StatusBadRequest)
+	case errors.Is(err, persistence.ErrNotInTrash):
+		hlog.FromRequest(r).Debug().Err(err).Msg("Slot is not in trash.")
+		http.Error(w, "'id' does not refer to a slot in the trash.", http.StatusNotFound)
+	case errors.Is(err, persistence.ErrQuotaExceeded):
+		hlog.FromRequest(r).Debug().Err(err).Msg("Maximum number of slots reached.")
+		// 403 and 409 are taken by Spotify's errors already, this way clients can tell the quota apart
+		http.Error(w, "You have reached the maximum number of slots (pinned slots do not get replaced). Please remove some slots first.", http.StatusInsufficientStorage)
+	case errors.Is(err, errInvalidRevision):
+		hlog.FromRequest(r).Debug().Err(err).Msg("Could not retrieve revision from request.")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		hlog.FromRequest(r).Error().Err(err).Msg(msg)
+		http.Error(w, msg, http.StatusInternalServerError)
+	}
+}
+
+// RespondWithSpotifyError tells the client what went wrong in case Spotify's error is a known one, otherwise
+// it responds with the given message and status.
+func RespondWithSpotifyError(w http.ResponseWriter, r *http.Request, err error, msg string, status int) {
+	var unavailable *spotify.UnavailableError
+	if errors.As(err, &unavailable) {
+		hlog.FromRequest(r).Warn().Err(err).Msg("Spotify is unavailable.")
+		setRetryAfter(w, unavailable.RetryAfter)
+		http.Error(w, "Spotify is currently unavailable. Please try again later.", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch {
+	case errors.Is(err, spotify.ErrTrackNotFoundInContext):
+		msg, status = "The saved track could not be found in its album resp. playlist anymore.", http.StatusConflict
+	case errors.Is(err, spotify.ErrNoActiveDevice):
+		msg, status = "No active device found. Please start playback on one of your devices first.", http.StatusConflict
+	case errors.Is(err, spotify.ErrPremiumRequired):
+		msg, status = "Controlling playback requires Spotify Premium.", http.StatusForbidden
+	case errors.Is(err, spotify.ErrRateLimited):
+		var spotifyErr *spotify.Error
+		if errors.As(err, &spotifyErr) && spotifyErr.RetryAfter > 0 {
+			setRetryAfter(w, spotifyErr.RetryAfter)
+		}
+		msg, status = "Too many requests to Spotify. Please try again later.", http.StatusTooManyRequests
+	case errors.Is(err, spotify.ErrTokenRevoked):
+		msg, status = "Access to Spotify has expired or has been revoked. Please log in again.", http.StatusUnauthorized
+	case errors.Is(err, spotify.ErrScopeMissing):
+		msg, status = "Cassette needs further permissions to access your Spotify account. Please log in again.", http.StatusUnauthorized
+	case errors.Is(err, spotify.ErrNotFound):
+		msg, status = "Spotify could not find the requested item.", http.StatusNotFound
+	case errors.Is(err, spotify.ErrContextUnavailableInMarket):
+		msg, status = "This album or playlist is not available in your country.", http.StatusUnavailableForLegalReasons
+	case errors.Is(err, spotify.ErrUpstream):
+		msg, status = "Spotify failed to handle the request. Please try again later.", http.StatusBadGateway
+	}
+
+	if status >= http.StatusInternalServerError {
+		hlog.FromRequest(r).Error().Err(err).Msg(msg)
+	} else {
+		hlog.FromRequest(r).Debug().Err(err).Msg(msg)
+	}
+	http.Error(w, msg, status)
+}
+
+func setRetryAfter(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+}
+
 func respondWithJSON(w http.ResponseWriter, r *http.Request, json []byte) {
 	w.Header().Set("Content-Type", "application/json")
 	bytesWritten, err := w.Write(json)
@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog/log"
+
+	"github.com/florianloch/cassette/internal/persistence"
+)
+
+const (
+	trashPurgeInterval = time.Hour
+	retentionInterval  = time.Hour
+)
+
+var (
+	inactiveUsersGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "cassette",
+		Subsystem: "retention",
+		Name:      "inactive_users",
+		Help:      "Number of inactive users found by the last run of the retention job.",
+	})
+	purgedUsersCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "cassette",
+		Subsystem: "retention",
+		Name:      "purged_users_total",
+		Help:      "Number of inactive users whose records got deleted by the retention job.",
+	})
+	retentionRunsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cassette",
+		Subsystem: "retention",
+		Name:      "runs_total",
+		Help:      "Number of runs of the retention job by their result.",
+	}, []string{"result"})
+	lastRetentionRunGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "cassette",
+		Subsystem: "retention",
+		Name:      "last_run_timestamp_seconds",
+		Help:      "Unix timestamp of the last successful run of the retention job.",
+	})
+)
+
+// purgeTrashPeriodically permanently deletes the slots which have been in the trash for longer than
+// the given retention period. It returns once the given context gets cancelled.
+func purgeTrashPeriodically(ctx context.Context, p persistence.PlayerStatesPersistor, retention time.Duration) {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		removedBefore := time.Now().Add(-retention)
+
+		err := p.PurgeTrash(ctx, removedBefore)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			log.Error().Err(err).Time("removedBefore", removedBefore).Msg("Failed purging trash.")
+		default:
+			log.Debug().Time("removedBefore", removedBefore).Msg("Purged trash.")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeInactiveUsersPeriodically deletes the records of users who have been inactive for longer than the given
+// window. In dry-run mode inactive users are only counted. It returns once the given context gets cancelled.
+func purgeInactiveUsersPeriodically(ctx context.Context, p persistence.PlayerStatesPersistor, inactivity time.Duration, dryRun bool) {
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+
+	for {
+		inactiveSince := time.Now().Add(-inactivity)
+
+		err := enforceRetention(ctx, p, inactiveSince, dryRun)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			retentionRunsCounter.WithLabelValues("failure").Inc()
+			log.Error().Err(err).Time("inactiveSince", inactiveSince).Msg("Failed purging inactive users.")
+		default:
+			retentionRunsCounter.WithLabelValues("success").Inc()
+			lastRetentionRunGauge.SetToCurrentTime()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func enforceRetention(ctx context.Context, p persistence.PlayerStatesPersistor, inactiveSince time.Time, dryRun bool) error {
+	inactive, err := p.CountInactiveUsers(ctx, inactiveSince)
+	if err != nil {
+		return err
+	}
+
+	inactiveUsersGauge.Set(float64(inactive))
+
+	if dryRun {
+		log.Info().Int64("inactiveUsers", inactive).Time("inactiveSince", inactiveSince).Msg("Not purging inactive users as running in dry-run mode.")
+		return nil
+	}
+
+	purged, err := p.PurgeInactiveUsers(ctx, inactiveSince)
+	if err != nil {
+		return err
+	}
+
+	purgedUsersCounter.Add(float64(purged))
+
+	log.Info().Int64("inactiveUsers", inactive).Int64("purgedUsers", purged).Time("inactiveSince", inactiveSince).Msg("Purged inactive users.")
+
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/gob"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -40,12 +41,16 @@ var (
 	auth  spotify.SpotAuthenticator
 	store *sessions.CookieStore
 	dao   persistence.PlayerStatesPersistor
+	quota persistence.Quota
 	// createSpotClient is required to use different initilisation code for testing
 	// and for production environment
 	createSpotClient spotClientCreator
+	// contextCache is shared by all users, nil disables caching
+	contextCache *spotify.ContextCache
 )
 
-type spotClientCreator func(token *oauth2.Token) spotify.SpotClient
+// spotClientCreator returns a client authenticating with the given token, refreshed tokens get passed to onRefresh
+type spotClientCreator func(token *oauth2.Token, onRefresh func(token *oauth2.Token)) spotify.SpotClient
 type m map[string]interface{}
 
 func RunInProduction() {
@@ -59,14 +64,28 @@ func RunInProduction() {
 	port := util.Env(constants.EnvPort, os.Getenv("PORT"))
 	appURL := util.Env(constants.EnvAppURL, "http://"+networkInterface+":"+port+"/")
 
-	mongoDBURI := util.Env(constants.EnvMongoURI, "")
-	if mongoDBURI == "" {
-		log.Fatal().Msg("No URI for connecting to MongoDB given. Aborting.")
-	}
-	var err error
-	dao, err = persistence.Connect(mongoDBURI)
-	if err != nil {
-		log.Fatal().Err(err).Str("mongoDBURI", mongoDBURI).Msg("Failed connecting to MongoDB.")
+	dbTimeout := durationFromEnv(constants.EnvDBTimeout, constants.DefaultDBTimeout)
+	quota = quotaFromEnv()
+	// The quota is enforced outside of the timeouts, as making room for a new slot takes several calls.
+	// The metrics wrap the timeouts so that calls running into them are counted as such.
+	dao = persistence.NewPlayerStatesPersistorWithQuota(
+		persistence.NewPlayerStatesPersistorWithMetrics(
+			persistence.NewPlayerStatesPersistorWithTimeout(connectPersistence(isDevMode), persistorTimeouts(dbTimeout)),
+		),
+		quota,
+	)
+
+	go purgeTrashPeriodically(ctx, dao, durationFromEnv(constants.EnvTrashRetention, constants.DefaultTrashRetention))
+
+	if inactivity := durationFromEnv(constants.EnvInactivityWindow, constants.DefaultInactivityWindow); inactivity > 0 {
+		dryRun, err := strconv.ParseBool(util.Env(constants.EnvRetentionDryRun, "false"))
+		if err != nil {
+			log.Fatal().Err(err).Msgf("'%s' is not a valid boolean.", constants.EnvRetentionDryRun)
+		}
+
+		go purgeInactiveUsersPeriodically(ctx, dao, inactivity, dryRun)
+	} else {
+		log.Warn().Msg("Records of inactive users do not get deleted.")
 	}
 
 	redirectURL, err := url.Parse(appURL)
@@ -75,8 +94,7 @@ func RunInProduction() {
 	}
 	redirectURL.Path = constants.OAuthCallbackRoute
 
-	tmp := spotifyAPI.NewAuthenticator(redirectURL.String(), spotifyAPI.ScopeUserReadCurrentlyPlaying, spotifyAPI.ScopeUserReadPlaybackState, spotifyAPI.ScopeUserModifyPlaybackState)
-	auth = &tmp
+	auth = spotify.NewAuthenticator(redirectURL.String(), spotifyAPI.ScopeUserReadCurrentlyPlaying, spotifyAPI.ScopeUserReadPlaybackState, spotifyAPI.ScopeUserModifyPlaybackState, spotifyAPI.ScopeUserLibraryRead)
 
 	clientID := util.Env(constants.EnvSpotifyClientID, "")
 	clientSecret := util.Env(constants.EnvSpotifyClientSecret, "")
@@ -87,10 +105,37 @@ func RunInProduction() {
 
 	auth.SetAuthInfo(clientID, clientSecret)
 
-	createSpotClient = func(token *oauth2.Token) spotify.SpotClient {
-		client := auth.NewClient(token)
+	spotifyTimeout := durationFromEnv(constants.EnvSpotifyTimeout, constants.DefaultSpotifyTimeout)
 
-		return spotify.NewSpotClientWithRetry(&client, 2, 100*time.Millisecond)
+	retryPolicy := spotify.RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   100 * time.Millisecond,
+		MaxDelay:    2 * time.Second,
+		MaxTotal:    durationFromEnv(constants.EnvSpotifyBudget, constants.DefaultSpotifyBudget),
+	}
+
+	// Shared by the clients of all users
+	breakers := spotify.NewCircuitBreakers(spotify.CircuitBreakerConfig{
+		FailureThreshold: 5,
+		OpenFor:          30 * time.Second,
+	})
+
+	if ttl := durationFromEnv(constants.EnvContextCacheTTL, constants.DefaultContextCacheTTL); ttl > 0 {
+		contextCache = spotify.NewContextCache(ttl)
+	}
+
+	createSpotClient = func(token *oauth2.Token, onRefresh func(token *oauth2.Token)) spotify.SpotClient {
+		client := spotify.NewSpotClient(auth.NewClient(token, onRefresh))
+
+		// The timeout applies to every attempt, the retry policy caps the time spent on all of them together.
+		// Every attempt counts for the circuit breakers, once they open retrying stops right away.
+		return spotify.NewSpotClientWithRetry(
+			spotify.NewSpotClientWithCircuitBreaker(
+				spotify.NewSpotClientWithTimeout(client, spotClientTimeouts(spotifyTimeout)),
+				breakers,
+			),
+			retryPolicy,
+		)
 	}
 
 	cwd, err := os.Getwd()
@@ -117,13 +162,20 @@ func RunInProduction() {
 		util.Env(constants.EnvInternalNetworkInterface, constants.DefaultNetworkInterface),
 		util.Env(constants.EnvInternalPort, constants.DefaultInternalPort))
 
+	// The contexts of all requests derive from this one. It gets cancelled in case requests do not finish
+	// in time when shutting down, this way handlers waiting for Spotify or the db return right away.
+	requestsCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+
 	publicServer := http.Server{
-		Addr:    publicServerAddr,
-		Handler: publicRouter,
+		Addr:        publicServerAddr,
+		Handler:     publicRouter,
+		BaseContext: func(net.Listener) context.Context { return requestsCtx },
 	}
 	internalServer := http.Server{
-		Addr:    internalServerAddr,
-		Handler: internalRouter,
+		Addr:        internalServerAddr,
+		Handler:     internalRouter,
+		BaseContext: func(net.Listener) context.Context { return requestsCtx },
 	}
 
 	serveWG := &sync.WaitGroup{}
@@ -141,6 +193,7 @@ func RunInProduction() {
 
 		if err := publicServer.Shutdown(timeout); err != nil {
 			log.Error().Err(err).Msg("Failed to shutdown public server gracefully.")
+			cancelRequests()
 		}
 
 		timeout, cancelFn = context.WithTimeout(context.Background(), 10*time.Second)
@@ -148,6 +201,7 @@ func RunInProduction() {
 
 		if err := internalServer.Shutdown(timeout); err != nil {
 			log.Error().Err(err).Msg("Failed to shutdown internal server gracefully.")
+			cancelRequests()
 		}
 	}()
 
@@ -179,8 +233,119 @@ func RunInProduction() {
 	log.Info().Msg("Server have been shut down. Bye.")
 }
 
+func connectPersistence(isDevMode bool) persistence.PlayerStatesPersistor {
+	persistenceURI := util.Env(constants.EnvMongoURI, "")
+	if persistenceURI == "" {
+		if !isDevMode {
+			log.Fatal().Msg("No URI for connecting to the persistence backend given. Aborting.")
+		}
+
+		log.Warn().Msgf("No URI for connecting to the persistence backend given. Falling back to '%s' as running in DEV mode.", constants.DevPersistenceURI)
+		persistenceURI = constants.DevPersistenceURI
+	}
+
+	var keyring *persistence.Keyring
+	if masterKeys := util.Env(constants.EnvMasterKeys, ""); masterKeys != "" {
+		var err error
+		keyring, err = persistence.NewKeyring(masterKeys)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("'%s' is not set to a valid value.", constants.EnvMasterKeys)
+		}
+
+		log.Info().Str("keyID", keyring.CurrentKeyID()).Msg("Player states get encrypted at rest.")
+	}
+
+	var userIDs *persistence.UserIDHasher
+	if pepper := util.Env(constants.EnvUserIDPepper, ""); pepper != "" {
+		acceptLegacy, err := strconv.ParseBool(util.Env(constants.EnvAcceptLegacyUserIDs, constants.DefaultAcceptLegacyIDs))
+		if err != nil {
+			log.Fatal().Err(err).Msgf("'%s' is not a valid boolean.", constants.EnvAcceptLegacyUserIDs)
+		}
+
+		userIDs, err = persistence.NewUserIDHasher(pepper, acceptLegacy)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("'%s' is not set to a valid value.", constants.EnvUserIDPepper)
+		}
+	} else {
+		log.Warn().Msgf("'%s' is not set, user IDs get stored as plain hashes which can be reverted easily.", constants.EnvUserIDPepper)
+	}
+
+	p, err := persistence.Connect(persistenceURI, persistence.Options{Keyring: keyring, UserIDs: userIDs})
+	if err != nil {
+		log.Fatal().Err(err).Str("persistenceURI", persistenceURI).Msg("Failed connecting to persistence backend.")
+	}
+
+	return p
+}
+
+func quotaFromEnv() persistence.Quota {
+	rawMaxSlots := util.Env(constants.EnvMaxSlots, constants.DefaultMaxSlots)
+
+	maxSlots, err := strconv.Atoi(rawMaxSlots)
+	if err != nil || maxSlots < 0 {
+		log.Fatal().Err(err).Str(constants.EnvMaxSlots, rawMaxSlots).Msgf("'%s' is not a valid, non-negative number.", constants.EnvMaxSlots)
+	}
+
+	policy, err := persistence.ParseQuotaPolicy(util.Env(constants.EnvQuotaPolicy, constants.DefaultQuotaPolicy))
+	if err != nil {
+		log.Fatal().Err(err).Msgf("'%s' is not set to a valid value.", constants.EnvQuotaPolicy)
+	}
+
+	return persistence.Quota{MaxSlots: maxSlots, Policy: policy}
+}
+
+func durationFromEnv(envName, defaultValue string) time.Duration {
+	rawDuration := util.Env(envName, defaultValue)
+
+	duration, err := time.ParseDuration(rawDuration)
+	if err != nil {
+		log.Fatal().Err(err).Str(envName, rawDuration).Msgf("'%s' is not a valid duration.", envName)
+	}
+
+	return duration
+}
+
+func persistorTimeouts(timeout time.Duration) persistence.PlayerStatesPersistorWithTimeoutConfig {
+	return persistence.PlayerStatesPersistorWithTimeoutConfig{
+		AppendStateTimeout:        timeout,
+		CountInactiveUsersTimeout: timeout,
+		DeleteUserRecordTimeout:   timeout,
+		FetchJSONDumpTimeout:      timeout,
+		LoadPlayerStatesTimeout:   timeout,
+		LoadTrashTimeout:          timeout,
+		MoveStateTimeout:          timeout,
+		PurgeInactiveUsersTimeout: timeout,
+		PurgeTrashTimeout:         timeout,
+		RemoveStateTimeout:        timeout,
+		ReplaceStateTimeout:       timeout,
+		RestoreFromTrashTimeout:   timeout,
+		SavePlayerStatesTimeout:   timeout,
+		SetPinnedTimeout:          timeout,
+	}
+}
+
+func spotClientTimeouts(timeout time.Duration) spotify.SpotClientWithTimeoutConfig {
+	return spotify.SpotClientWithTimeoutConfig{
+		CurrentUserTimeout:             timeout,
+		CurrentUsersTracksOptTimeout:   timeout,
+		GetAlbumTracksOptTimeout:       timeout,
+		GetAudiobookTimeout:            timeout,
+		GetAudiobookChaptersOptTimeout: timeout,
+		GetPlaylistOptTimeout:          timeout,
+		GetPlaylistTracksOptTimeout:    timeout,
+		GetShowEpisodesOptTimeout:      timeout,
+		PauseTimeout:                   timeout,
+		PlayOptTimeout:                 timeout,
+		PlayerDevicesTimeout:           timeout,
+		PlayerStateTimeout:             timeout,
+		ShuffleTimeout:                 timeout,
+	}
+}
+
+// SetupForTest uses the given DAO as is, the given quota is only reported to clients but not enforced.
 func SetupForTest(
 	daoMock persistence.PlayerStatesPersistor,
+	testQuota persistence.Quota,
 	authMock spotify.SpotAuthenticator,
 	spotClientMockCreator spotClientCreator,
 	webRoot string) http.Handler {
@@ -188,6 +353,7 @@ func SetupForTest(
 	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
 
 	dao = daoMock
+	quota = testQuota
 
 	auth = authMock
 
@@ -270,10 +436,13 @@ func setupAPI(webRoot string, isDevMode bool, r chi.Router) {
 		r.With(attachDAO).With(attachUser).Route("/you", func(r chi.Router) {
 			r.Get("/", handler.UserExportHandler)
 			r.Delete("/", handler.UserDeleteHandler)
+			r.Post("/import", handler.NewUserImportHandler(quota))
 		})
 
 		r.With(attachSpotifyClient).Get("/activeDevices", handler.ActiveDevicesHandler)
 
+		r.With(attachUser).Get("/quota", handler.NewQuotaHandler(quota))
+
 		r.With(attachSpotifyClient).With(attachDAO).With(attachUser).Route("/playerStates", func(r chi.Router) {
 			r.Post("/", handler.PlayerStatesPostHandler)
 			r.Get("/", handler.PlayerStatesGetHandler)
@@ -281,9 +450,19 @@ func setupAPI(webRoot string, isDevMode bool, r chi.Router) {
 				r.Put("/", handler.PlayerStatesPostHandler)
 				r.Delete("/", handler.PlayerStatesDeleteHandler)
 				r.Post("/restore", handler.PlayerStatesRestoreHandler)
+				r.Post("/move", handler.PlayerStatesMoveHandler)
+				r.Put("/pin", handler.PlayerStatesPinHandler)
+				r.Delete("/pin", handler.PlayerStatesUnpinHandler)
+				r.Get("/history", handler.PlayerStatesHistoryHandler)
+				r.Post("/history/{n}/revert", handler.PlayerStatesRevertHandler)
 			})
 		})
 
+		r.With(attachDAO).With(attachUser).Route("/trash", func(r chi.Router) {
+			r.Get("/", handler.TrashGetHandler)
+			r.Post("/{id}/restore", handler.TrashRestoreHandler)
+		})
+
 		r.NotFound(http.NotFound)
 	})
 
@@ -326,16 +505,20 @@ func attachUser(next http.Handler) http.Handler {
 
 			// Once per session-lifetime we have to get the user ID from the spotifyClient.
 			// We then cache it in the session.
-			spotifyClient, err := spotifyClientFromSession(session)
+			// The session gets saved below anyway, a refreshed token only has to be put into it
+			spotifyClient, err := spotifyClientFromSession(session, func(token *oauth2.Token) {
+				session.Values[constants.SessionKeySpotifyToken] = token
+			})
 			if err != nil {
 				hlog.FromRequest(r).Error().Err(err).Msg("Could not initialize Spotify client for user!")
 				http.Error(w, err.Error(), http.StatusForbidden)
 				return
 			}
 
-			rawUser, err = spotifyClient.CurrentUser()
+			rawUser, err = spotifyClient.CurrentUser(ctx)
 			if err != nil {
-				hlog.FromRequest(r).Panic().Err(err).Msg("Could not fetch information on user from Spotify!")
+				// E.g. a revoked token asks the user to log in again, anything unknown ends up as 500
+				handler.RespondWithSpotifyError(w, r, err, "Could not fetch information on user from Spotify!", http.StatusInternalServerError)
 				return
 			}
 
@@ -367,7 +550,9 @@ func attachSpotifyClient(next http.Handler) http.Handler {
 		ctx := r.Context()
 		session := ctx.Value(constants.FieldKeySession).(*sessions.Session)
 
-		client, err := spotifyClientFromSession(session)
+		tokenSaver := newTokenSavingWriter(w, r, session)
+
+		client, err := spotifyClientFromSession(session, tokenSaver.tokenRefreshed)
 		if err != nil {
 			hlog.FromRequest(r).Error().Err(err).Msg("Could not initialize Spotify client for user!")
 			http.Error(w, err.Error(), http.StatusForbidden)
@@ -375,12 +560,16 @@ func attachSpotifyClient(next http.Handler) http.Handler {
 		}
 
 		newCtx := context.WithValue(ctx, constants.FieldKeySpotifyClient, client)
+		newCtx = context.WithValue(newCtx, constants.FieldKeyContextCache, contextCache)
 
-		next.ServeHTTP(w, r.WithContext(newCtx))
+		next.ServeHTTP(tokenSaver, r.WithContext(newCtx))
+
+		// In case the handler has not written a response the token has not been saved yet
+		tokenSaver.save()
 	})
 }
 
-func spotifyClientFromSession(session *sessions.Session) (spotify.SpotClient, error) {
+func spotifyClientFromSession(session *sessions.Session, onRefresh func(token *oauth2.Token)) (spotify.SpotClient, error) {
 	rawToken := session.Values[constants.SessionKeySpotifyToken]
 
 	tok, ok := rawToken.(*oauth2.Token)
@@ -389,7 +578,7 @@ func spotifyClientFromSession(session *sessions.Session) (spotify.SpotClient, er
 		return nil, errors.New("Could not read Spotify token from session. User probably did not log in.")
 	}
 
-	return createSpotClient(tok), nil
+	return createSpotClient(tok, onRefresh), nil
 }
 
 func attachDAO(next http.Handler) http.Handler {
@@ -402,35 +591,43 @@ func attachDAO(next http.Handler) http.Handler {
 
 func attachSlot(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		slot, err := checkSlotParameter(r)
+		slotRef, err := checkSlotParameter(r)
 		if err != nil {
 			hlog.FromRequest(r).Debug().Err(err).Msg("Could not retrieve slot from request.")
 			http.Error(w, fmt.Sprintf("Could not process request. Please make sure the given slot is valid: %s", err), http.StatusBadRequest)
 			return
 		}
 
-		newCtx := context.WithValue(r.Context(), constants.FieldKeySlot, slot)
+		if slotRef.ID == "" {
+			// Indices shift whenever a slot gets removed, clients should address slots by their ID instead
+			hlog.FromRequest(r).Debug().Int("slot", slotRef.Index).Msg("Slot is addressed by its deprecated index.")
+			w.Header().Set("Deprecation", "true")
+		}
+
+		newCtx := context.WithValue(r.Context(), constants.FieldKeySlot, slotRef)
 
 		next.ServeHTTP(w, r.WithContext(newCtx))
 	})
 }
 
-func checkSlotParameter(r *http.Request) (int, error) {
+// checkSlotParameter accepts either the ID of a slot or, for backwards compatibility, its index.
+func checkSlotParameter(r *http.Request) (handler.SlotRef, error) {
 	var slotStr = chi.URLParam(r, "slot")
 
 	if slotStr == "" {
-		return -1, errors.New("query parameter 'slot' not found")
+		return handler.SlotRef{}, errors.New("query parameter 'slot' not found")
 	}
 
 	var slot, err = strconv.Atoi(slotStr)
 	if err != nil {
-		return -1, errors.New("query parameter 'slot' is not a valid integer")
+		// Not being an integer it has to be an ID
+		return handler.SlotRef{ID: slotStr}, nil
 	}
 	if slot < 0 {
-		return -1, errors.New("query parameter 'slot' has to be >= 0")
+		return handler.SlotRef{}, errors.New("query parameter 'slot' has to be >= 0")
 	}
 
-	return slot, nil
+	return handler.SlotRef{Index: slot}, nil
 }
 
 type csrfErrorHandler struct{}
@@ -0,0 +1,1043 @@
+package persistence
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// persistorFactory returns a fresh, empty PlayerStatesPersistor for every call.
+type persistorFactory func(t *testing.T) PlayerStatesPersistor
+
+// runConformanceSuite contains the behaviour every implementation of PlayerStatesPersistor
+// has to provide. PlayerStatesDAO is the reference implementation.
+func runConformanceSuite(t *testing.T, newPersistor persistorFactory) {
+	t.Run("LoadingUnknownUserYieldsNoStates", func(t *testing.T) {
+		p := newPersistor(t)
+
+		playerStates, revision, err := p.LoadPlayerStates(t.Context(), "unknown_user")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if playerStates == nil || len(playerStates) != 0 {
+			t.Fatalf("expected an empty, non-nil slice, got %#v", playerStates)
+		}
+		if revision != 0 {
+			t.Fatalf("expected revision 0, got %d", revision)
+		}
+	})
+
+	t.Run("SavedStatesCanBeLoaded", func(t *testing.T) {
+		p := newPersistor(t)
+
+		expected := []*PlayerState{fullPlayerState("book 1"), fullPlayerState("book 2"), fullPlayerState("book 3")}
+
+		mustSave(t, p, "user", expected)
+
+		assertStates(t, mustLoad(t, p, "user"), expected)
+	})
+
+	t.Run("SavingReplacesPreviousStates", func(t *testing.T) {
+		p := newPersistor(t)
+
+		mustSave(t, p, "user", []*PlayerState{fullPlayerState("book 1"), fullPlayerState("book 2")})
+		expected := []*PlayerState{fullPlayerState("book 3")}
+		mustSave(t, p, "user", expected)
+
+		assertStates(t, mustLoad(t, p, "user"), expected)
+
+		mustSave(t, p, "user", []*PlayerState{})
+
+		assertStates(t, mustLoad(t, p, "user"), []*PlayerState{})
+	})
+
+	t.Run("UsersAreIsolated", func(t *testing.T) {
+		p := newPersistor(t)
+
+		statesOfA := []*PlayerState{fullPlayerState("book A")}
+		statesOfB := []*PlayerState{fullPlayerState("book B1"), fullPlayerState("book B2")}
+
+		mustSave(t, p, "user_a", statesOfA)
+		mustSave(t, p, "user_b", statesOfB)
+
+		assertStates(t, mustLoad(t, p, "user_a"), statesOfA)
+		assertStates(t, mustLoad(t, p, "user_b"), statesOfB)
+	})
+
+	t.Run("LoadedStatesAreDetachedFromStorage", func(t *testing.T) {
+		p := newPersistor(t)
+
+		saved := []*PlayerState{fullPlayerState("book 1")}
+		mustSave(t, p, "user", saved)
+		saved[0].AlbumName = "modified after saving"
+
+		loaded := mustLoad(t, p, "user")
+		loaded[0].AlbumName = "modified after loading"
+
+		assertStates(t, mustLoad(t, p, "user"), []*PlayerState{fullPlayerState("book 1")})
+	})
+
+	t.Run("DumpOfUnknownUserFails", func(t *testing.T) {
+		p := newPersistor(t)
+
+		_, err := p.FetchJSONDump(t.Context(), "unknown_user")
+		if !errors.Is(err, ErrUserNotFound) {
+			t.Fatalf("expected ErrUserNotFound, got %v", err)
+		}
+	})
+
+	t.Run("DumpContainsRecord", func(t *testing.T) {
+		p := newPersistor(t)
+
+		expected := []*PlayerState{fullPlayerState("book 1"), fullPlayerState("book 2")}
+		mustSave(t, p, "user", expected)
+
+		dump, err := p.FetchJSONDump(t.Context(), "user")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		var item persistenceItem
+		if err := json.Unmarshal(dump, &item); err != nil {
+			t.Fatalf("dump is not valid JSON: %s", err)
+		}
+
+		if item.UserID != legacyHashUserID("user") {
+			t.Errorf("expected '_id' to be the hashed user ID, got '%s'", item.UserID)
+		}
+		if item.Version != currentVersion {
+			t.Errorf("expected 'version' to be %d, got %d", currentVersion, item.Version)
+		}
+
+		// URIs are not part of the JSON representation
+		for _, state := range expected {
+			state.PlaybackContextURI = ""
+			state.PlaybackItemURI = ""
+		}
+		assertStates(t, item.PlayerStates, expected)
+	})
+
+	t.Run("DumpCanBeParsed", func(t *testing.T) {
+		p := newPersistor(t)
+
+		mustSave(t, p, "user", []*PlayerState{fullPlayerState("book 1"), fullPlayerState("book 2")})
+		_, revision := mustLoadWithRevision(t, p, "user")
+		if err := p.ReplaceState(t.Context(), "user", revision, 1, fullPlayerState("book 3")); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		dump, err := p.FetchJSONDump(t.Context(), "user")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		parsed, invalid, err := ParseDump(dump)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(invalid) != 0 {
+			t.Fatalf("expected no invalid entries, got %#v", invalid)
+		}
+
+		// Nothing gets lost, neither URIs nor IDs nor history
+		assertStates(t, parsed, mustLoad(t, p, "user"))
+	})
+
+	t.Run("DumpKeepsItsFormat", func(t *testing.T) {
+		p := newPersistor(t)
+
+		mustSave(t, p, "user", []*PlayerState{fullPlayerState("book 1")})
+
+		dump, err := p.FetchJSONDump(t.Context(), "user")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		var raw map[string]any
+		if err := json.Unmarshal(dump, &raw); err != nil {
+			t.Fatalf("dump is not valid JSON: %s", err)
+		}
+
+		if _, ok := raw["revision"]; ok {
+			t.Error("expected revision not to be exported")
+		}
+		if version, ok := raw["version"].(float64); !ok || int(version) != currentVersion {
+			t.Errorf("expected plain version %d, got %#v", currentVersion, raw["version"])
+		}
+
+		states, _ := raw["playerStates"].([]any)
+		if len(states) != 1 {
+			t.Fatalf("expected one player state, got %#v", raw["playerStates"])
+		}
+		state, _ := states[0].(map[string]any)
+		for _, key := range []string{"linkToContext", "contextType", "trackName", "trackIndex", "progress", "suspendedAtTs", "playbackContextURI", "playbackItemURI"} {
+			if _, ok := state[key]; !ok {
+				t.Errorf("expected player state to contain '%s', got %#v", key, state)
+			}
+		}
+	})
+
+	t.Run("DumpContainsTrash", func(t *testing.T) {
+		p := newPersistor(t)
+
+		mustSave(t, p, "user", []*PlayerState{fullPlayerState("book 1"), fullPlayerState("book 2")})
+		mustRemove(t, p, "user", 0)
+
+		dump, err := p.FetchJSONDump(t.Context(), "user")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		var item persistenceItem
+		if err := json.Unmarshal(dump, &item); err != nil {
+			t.Fatalf("dump is not valid JSON: %s", err)
+		}
+
+		expected := fullPlayerState("book 1")
+		expected.PlaybackContextURI = ""
+		expected.PlaybackItemURI = ""
+		assertTrash(t, item.Trash, []*PlayerState{expected})
+	})
+
+	t.Run("DeletingUnknownUserFails", func(t *testing.T) {
+		p := newPersistor(t)
+
+		err := p.DeleteUserRecord(t.Context(), "unknown_user")
+		if !errors.Is(err, ErrUserNotFound) {
+			t.Fatalf("expected ErrUserNotFound, got %v", err)
+		}
+	})
+
+	t.Run("DeletingRemovesRecord", func(t *testing.T) {
+		p := newPersistor(t)
+
+		mustSave(t, p, "user", []*PlayerState{fullPlayerState("book 1"), fullPlayerState("book 3")})
+		mustRemove(t, p, "user", 1)
+		mustSave(t, p, "other_user", []*PlayerState{fullPlayerState("book 2")})
+
+		if err := p.DeleteUserRecord(t.Context(), "user"); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		assertStates(t, mustLoad(t, p, "user"), []*PlayerState{})
+		assertStates(t, mustLoadTrash(t, p, "user"), []*PlayerState{})
+		if _, err := p.FetchJSONDump(t.Context(), "user"); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("expected ErrUserNotFound after deletion, got %v", err)
+		}
+		if err := p.DeleteUserRecord(t.Context(), "user"); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("expected ErrUserNotFound when deleting twice, got %v", err)
+		}
+
+		assertStates(t, mustLoad(t, p, "other_user"), []*PlayerState{fullPlayerState("book 2")})
+	})
+
+	t.Run("EveryModificationIncrementsRevision", func(t *testing.T) {
+		p := newPersistor(t)
+
+		mustSave(t, p, "user", []*PlayerState{fullPlayerState("book 1")})
+		_, afterSave := mustLoadWithRevision(t, p, "user")
+
+		mustAppend(t, p, "user", fullPlayerState("book 2"))
+		_, afterAppend := mustLoadWithRevision(t, p, "user")
+
+		if err := p.ReplaceState(t.Context(), "user", afterAppend, 0, fullPlayerState("book 3")); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		_, afterReplace := mustLoadWithRevision(t, p, "user")
+
+		if !(0 < afterSave && afterSave < afterAppend && afterAppend < afterReplace) {
+			t.Fatalf("expected strictly increasing revisions, got %d, %d, %d", afterSave, afterAppend, afterReplace)
+		}
+	})
+
+	t.Run("AppendingAddsSlotAtTheEnd", func(t *testing.T) {
+		p := newPersistor(t)
+
+		mustAppend(t, p, "user", fullPlayerState("book 1"))
+		mustAppend(t, p, "user", fullPlayerState("book 2"))
+
+		assertStates(t, mustLoad(t, p, "user"), []*PlayerState{fullPlayerState("book 1"), fullPlayerState("book 2")})
+	})
+
+	t.Run("ReplacingOverwritesSingleSlot", func(t *testing.T) {
+		p := newPersistor(t)
+
+		mustSave(t, p, "user", []*PlayerState{fullPlayerState("book 1"), fullPlayerState("book 2")})
+		_, revision := mustLoadWithRevision(t, p, "user")
+
+		if err := p.ReplaceState(t.Context(), "user", revision, 1, fullPlayerState("book 3")); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		replacement := fullPlayerState("book 3")
+		replacement.History = []*PlayerState{fullPlayerState("book 2")}
+
+		assertStates(t, mustLoad(t, p, "user"), []*PlayerState{fullPlayerState("book 1"), replacement})
+	})
+
+	t.Run("ReplacingKeepsBoundedHistory", func(t *testing.T) {
+		p := newPersistor(t)
+
+		mustSave(t, p, "user", []*PlayerState{fullPlayerState("book 0")})
+
+		for i := 1; i <= historyLength+2; i++ {
+			_, revision := mustLoadWithRevision(t, p, "user")
+
+			if err := p.ReplaceState(t.Context(), "user", revision, 0, fullPlayerState(fmt.Sprintf("book %d", i))); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		}
+
+		loaded := mustLoad(t, p, "user")
+		expectedHistory := make([]*PlayerState, 0, historyLength)
+		for i := historyLength + 1; i > 1; i-- {
+			expectedHistory = append(expectedHistory, fullPlayerState(fmt.Sprintf("book %d", i)))
+		}
+
+		assertStates(t, loaded[0].History, expectedHistory)
+
+		// The snapshots belong to the slot, so they share its ID
+		for _, snapshot := range loaded[0].History {
+			if snapshot.ID != loaded[0].ID {
+				t.Fatalf("expected snapshot to have ID '%s', got '%s'", loaded[0].ID, snapshot.ID)
+			}
+		}
+	})
+
+	t.Run("HistoryFollowsItsSlot", func(t *testing.T) {
+		p := newPersistor(t)
+
+		mustSave(t, p, "user", []*PlayerState{fullPlayerState("book 1"), fullPlayerState("book 2"), fullPlayerState("book 3")})
+		_, revision := mustLoadWithRevision(t, p, "user")
+
+		if err := p.ReplaceState(t.Context(), "user", revision, 2, fullPlayerState("book 4")); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := p.ReplaceState(t.Context(), "user", revision+1, 1, fullPlayerState("book 5")); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := p.MoveState(t.Context(), "user", revision+2, 2, 0); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := p.RemoveState(t.Context(), "user", revision+3, 2); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		moved := fullPlayerState("book 4")
+		moved.History = []*PlayerState{fullPlayerState("book 3")}
+
+		assertStates(t, mustLoad(t, p, "user"), []*PlayerState{moved, fullPlayerState("book 1")})
+	})
+
+	t.Run("SavedHistoryCanBeLoaded", func(t *testing.T) {
+		p := newPersistor(t)
+
+		withHistory := fullPlayerState("book 2")
+		withHistory.History = []*PlayerState{fullPlayerState("book 1"), fullPlayerState("book 0")}
+		expected := []*PlayerState{withHistory, fullPlayerState("book 3")}
+
+		mustSave(t, p, "user", expected)
+
+		loaded := mustLoad(t, p, "user")
+		assertStates(t, loaded, expected)
+
+		loaded[0].History[0].AlbumName = "modified"
+		assertStates(t, mustLoad(t, p, "user"), expected)
+	})
+
+	t.Run("RemovingShiftsFollowingSlots", func(t *testing.T) {
+		p := newPersistor(t)
+
+		mustSave(t, p, "user", []*PlayerState{fullPlayerState("book 1"), fullPlayerState("book 2"), fullPlayerState("book 3"), fullPlayerState("book 4")})
+		_, revision := mustLoadWithRevision(t, p, "user")
+
+		if err := p.RemoveState(t.Context(), "user", revision, 1); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		assertStates(t, mustLoad(t, p, "user"), []*PlayerState{fullPlayerState("book 1"), fullPlayerState("book 3"), fullPlayerState("book 4")})
+
+		// Appending afterwards must not collide with the shifted slots
+		mustAppend(t, p, "user", fullPlayerState("book 5"))
+
+		assertStates(t, mustLoad(t, p, "user"), []*PlayerState{fullPlayerState("book 1"), fullPlayerState("book 3"), fullPlayerState("book 4"), fullPlayerState("book 5")})
+	})
+
+	t.Run("RemovedSlotsGoToTrash", func(t *testing.T) {
+		p := newPersistor(t)
+
+		assertStates(t, mustLoadTrash(t, p, "user"), []*PlayerState{})
+
+		mustSave(t, p, "user", []*PlayerState{fullPlayerState("book 1"), fullPlayerState("book 2"), fullPlayerState("book 3")})
+		loaded := mustLoad(t, p, "user")
+
+		mustRemove(t, p, "user", 1)
+		mustRemove(t, p, "user", 0)
+
+		assertStates(t, mustLoad(t, p, "user"), []*PlayerState{loaded[2]})
+		// The most recently removed slot comes first
+		assertTrash(t, mustLoadTrash(t, p, "user"), []*PlayerState{loaded[0], loaded[1]})
+	})
+
+	t.Run("RestoringFromTrashAppendsSlot", func(t *testing.T) {
+		p := newPersistor(t)
+
+		mustSave(t, p, "user", []*PlayerState{fullPlayerState("book 1"), fullPlayerState("book 2")})
+		_, revision := mustLoadWithRevision(t, p, "user")
+
+		if err := p.ReplaceState(t.Context(), "user", revision, 0, fullPlayerState("book 3")); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		withHistory := mustLoad(t, p, "user")[0]
+
+		mustRemove(t, p, "user", 0)
+		// Saving the remaining slots must not affect the trash
+		mustSave(t, p, "user", mustLoad(t, p, "user"))
+		_, revision = mustLoadWithRevision(t, p, "user")
+
+		if err := p.RestoreFromTrash(t.Context(), "user", withHistory.ID); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		loaded, restoredRevision := mustLoadWithRevision(t, p, "user")
+		if restoredRevision <= revision {
+			t.Errorf("expected revision to be incremented, got %d after %d", restoredRevision, revision)
+		}
+		assertStates(t, loaded, []*PlayerState{fullPlayerState("book 2"), withHistory})
+		assertStates(t, mustLoadTrash(t, p, "user"), []*PlayerState{})
+
+		if err := p.RestoreFromTrash(t.Context(), "user", withHistory.ID); !errors.Is(err, ErrNotInTrash) {
+			t.Fatalf("expected ErrNotInTrash when restoring twice, got %v", err)
+		}
+	})
+
+	t.Run("RestoringUnknownSlotFails", func(t *testing.T) {
+		p := newPersistor(t)
+
+		if err := p.RestoreFromTrash(t.Context(), "unknown_user", "unknown"); !errors.Is(err, ErrNotInTrash) {
+			t.Fatalf("expected ErrNotInTrash, got %v", err)
+		}
+
+		mustSave(t, p, "user", []*PlayerState{fullPlayerState("book 1")})
+		slotID := mustLoad(t, p, "user")[0].ID
+
+		// Slots which have not been removed cannot be restored
+		if err := p.RestoreFromTrash(t.Context(), "user", slotID); !errors.Is(err, ErrNotInTrash) {
+			t.Fatalf("expected ErrNotInTrash, got %v", err)
+		}
+	})
+
+	t.Run("PurgingDeletesExpiredSlotsOfAllUsers", func(t *testing.T) {
+		p := newPersistor(t)
+
+		for _, userID := range []string{"user", "other_user"} {
+			mustSave(t, p, userID, []*PlayerState{fullPlayerState("book 1"), fullPlayerState("book 2")})
+			mustRemove(t, p, userID, 0)
+		}
+		expected := mustLoadTrash(t, p, "user")
+
+		if err := p.PurgeTrash(t.Context(), time.Now().Add(-time.Hour)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		assertStates(t, mustLoadTrash(t, p, "user"), expected)
+
+		if err := p.PurgeTrash(t.Context(), time.Now().Add(time.Hour)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		for _, userID := range []string{"user", "other_user"} {
+			assertStates(t, mustLoadTrash(t, p, userID), []*PlayerState{})
+			assertStates(t, mustLoad(t, p, userID), []*PlayerState{fullPlayerState("book 2")})
+		}
+	})
+
+	t.Run("MovingReordersSlots", func(t *testing.T) {
+		p := newPersistor(t)
+
+		mustSave(t, p, "user", []*PlayerState{fullPlayerState("book 1"), fullPlayerState("book 2"), fullPlayerState("book 3")})
+		_, revision := mustLoadWithRevision(t, p, "user")
+
+		if err := p.MoveState(t.Context(), "user", revision, 0, 2); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		assertStates(t, mustLoad(t, p, "user"), []*PlayerState{fullPlayerState("book 2"), fullPlayerState("book 3"), fullPlayerState("book 1")})
+
+		_, revision = mustLoadWithRevision(t, p, "user")
+
+		if err := p.MoveState(t.Context(), "user", revision, 2, 1); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		assertStates(t, mustLoad(t, p, "user"), []*PlayerState{fullPlayerState("book 2"), fullPlayerState("book 1"), fullPlayerState("book 3")})
+	})
+
+	t.Run("StaleRevisionConflicts", func(t *testing.T) {
+		p := newPersistor(t)
+
+		mustSave(t, p, "user", []*PlayerState{fullPlayerState("book 1"), fullPlayerState("book 2")})
+		_, staleRevision := mustLoadWithRevision(t, p, "user")
+		mustAppend(t, p, "user", fullPlayerState("book 3"))
+
+		if err := p.ReplaceState(t.Context(), "user", staleRevision, 0, fullPlayerState("book 4")); !errors.Is(err, ErrConflict) {
+			t.Errorf("expected ErrConflict when replacing, got %v", err)
+		}
+		if err := p.RemoveState(t.Context(), "user", staleRevision, 0); !errors.Is(err, ErrConflict) {
+			t.Errorf("expected ErrConflict when removing, got %v", err)
+		}
+		if err := p.MoveState(t.Context(), "user", staleRevision, 0, 1); !errors.Is(err, ErrConflict) {
+			t.Errorf("expected ErrConflict when moving, got %v", err)
+		}
+
+		assertStates(t, mustLoad(t, p, "user"), []*PlayerState{fullPlayerState("book 1"), fullPlayerState("book 2"), fullPlayerState("book 3")})
+	})
+
+	t.Run("NotExistingSlotIsRejected", func(t *testing.T) {
+		p := newPersistor(t)
+
+		if err := p.RemoveState(t.Context(), "unknown_user", 0, 0); !errors.Is(err, ErrSlotNotFound) {
+			t.Errorf("expected ErrSlotNotFound for unknown user, got %v", err)
+		}
+
+		mustSave(t, p, "user", []*PlayerState{fullPlayerState("book 1")})
+		_, revision := mustLoadWithRevision(t, p, "user")
+
+		if err := p.ReplaceState(t.Context(), "user", revision, 1, fullPlayerState("book 2")); !errors.Is(err, ErrSlotNotFound) {
+			t.Errorf("expected ErrSlotNotFound when replacing, got %v", err)
+		}
+		if err := p.RemoveState(t.Context(), "user", revision, -1); !errors.Is(err, ErrSlotNotFound) {
+			t.Errorf("expected ErrSlotNotFound when removing, got %v", err)
+		}
+		if err := p.MoveState(t.Context(), "user", revision, 0, 1); !errors.Is(err, ErrSlotNotFound) {
+			t.Errorf("expected ErrSlotNotFound when moving, got %v", err)
+		}
+
+		assertStates(t, mustLoad(t, p, "user"), []*PlayerState{fullPlayerState("book 1")})
+	})
+
+	t.Run("SlotsGetUniqueIDs", func(t *testing.T) {
+		p := newPersistor(t)
+
+		mustSave(t, p, "user", []*PlayerState{fullPlayerState("book 1"), fullPlayerState("book 2")})
+		mustAppend(t, p, "user", fullPlayerState("book 3"))
+
+		seen := make(map[string]bool)
+		for _, playerState := range mustLoad(t, p, "user") {
+			if playerState.ID == "" || seen[playerState.ID] {
+				t.Fatalf("expected a unique ID, got '%s'", playerState.ID)
+			}
+			seen[playerState.ID] = true
+		}
+	})
+
+	t.Run("GivenIDsAreKept", func(t *testing.T) {
+		p := newPersistor(t)
+
+		withID := fullPlayerState("book 1")
+		withID.ID = "some-id"
+		mustSave(t, p, "user", []*PlayerState{withID})
+
+		assertStates(t, mustLoad(t, p, "user"), []*PlayerState{withID})
+	})
+
+	t.Run("IDsAreStableAcrossModifications", func(t *testing.T) {
+		p := newPersistor(t)
+
+		mustSave(t, p, "user", []*PlayerState{fullPlayerState("book 1"), fullPlayerState("book 2"), fullPlayerState("book 3")})
+		initial, revision := mustLoadWithRevision(t, p, "user")
+
+		if err := p.RemoveState(t.Context(), "user", revision, 0); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		_, revision = mustLoadWithRevision(t, p, "user")
+
+		// The replacement carries no ID, it has to take over the one of the slot
+		if err := p.ReplaceState(t.Context(), "user", revision, 1, fullPlayerState("book 4")); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		_, revision = mustLoadWithRevision(t, p, "user")
+
+		if err := p.MoveState(t.Context(), "user", revision, 1, 0); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		loaded := mustLoad(t, p, "user")
+		if len(loaded) != 2 || loaded[0].ID != initial[2].ID || loaded[1].ID != initial[1].ID {
+			t.Fatalf("expected IDs '%s', '%s', got %#v", initial[2].ID, initial[1].ID, loaded)
+		}
+		if loaded[0].AlbumName != "book 4" {
+			t.Fatalf("expected replaced state in slot 0, got '%s'", loaded[0].AlbumName)
+		}
+	})
+
+	t.Run("ConcurrentAppendsAreNotLost", func(t *testing.T) {
+		p := newPersistor(t)
+
+		const n = 10
+
+		var wg sync.WaitGroup
+		errs := make(chan error, n)
+
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs <- p.AppendState(t.Context(), "user", fullPlayerState(fmt.Sprintf("book %d", i)))
+			}(i)
+		}
+
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		}
+
+		if playerStates := mustLoad(t, p, "user"); len(playerStates) != n {
+			t.Fatalf("expected %d player states, got %d", n, len(playerStates))
+		}
+	})
+
+	t.Run("OnlyOneOfConcurrentReplacesSucceeds", func(t *testing.T) {
+		p := newPersistor(t)
+
+		mustSave(t, p, "user", []*PlayerState{fullPlayerState("book 1")})
+		_, revision := mustLoadWithRevision(t, p, "user")
+
+		const n = 5
+
+		var wg sync.WaitGroup
+		errs := make(chan error, n)
+
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs <- p.ReplaceState(t.Context(), "user", revision, 0, fullPlayerState(fmt.Sprintf("book %d", i)))
+			}(i)
+		}
+
+		wg.Wait()
+		close(errs)
+
+		succeeded := 0
+		for err := range errs {
+			switch {
+			case err == nil:
+				succeeded++
+			case !errors.Is(err, ErrConflict):
+				t.Fatalf("expected ErrConflict, got %v", err)
+			}
+		}
+
+		if succeeded != 1 {
+			t.Fatalf("expected exactly one replace to succeed, %d did", succeeded)
+		}
+	})
+
+	t.Run("RecordsAreStoredUnderPepperedUserID", func(t *testing.T) {
+		p := newPersistor(t)
+		setUserIDs(t, p, mustNewUserIDHasher(t, false))
+
+		mustSave(t, p, "user", []*PlayerState{fullPlayerState("book 1")})
+
+		var item persistenceItem
+		if err := json.Unmarshal(mustFetchDump(t, p, "user"), &item); err != nil {
+			t.Fatalf("dump is not valid JSON: %s", err)
+		}
+
+		if isLegacyUserID(item.UserID) {
+			t.Errorf("expected '_id' to be the peppered user ID, got '%s'", item.UserID)
+		}
+
+		if count := mustCountLegacyUserIDs(t, p); count != 0 {
+			t.Errorf("expected no records stored under legacy user IDs, got %d", count)
+		}
+	})
+
+	t.Run("LegacyRecordsAreFoundOnlyWhileAcceptingLegacyUserIDs", func(t *testing.T) {
+		p := newPersistor(t)
+
+		mustSave(t, p, "user", []*PlayerState{fullPlayerState("book 1")})
+
+		setUserIDs(t, p, mustNewUserIDHasher(t, true))
+		mustAppend(t, p, "user", fullPlayerState("book 2"))
+		assertStates(t, mustLoad(t, p, "user"), []*PlayerState{fullPlayerState("book 1"), fullPlayerState("book 2")})
+
+		if count := mustCountLegacyUserIDs(t, p); count != 1 {
+			t.Errorf("expected the record to still be stored under the legacy user ID, got %d such records", count)
+		}
+
+		setUserIDs(t, p, mustNewUserIDHasher(t, false))
+		assertStates(t, mustLoad(t, p, "user"), []*PlayerState{})
+	})
+
+	t.Run("RekeyingMovesLegacyRecords", func(t *testing.T) {
+		p := newPersistor(t)
+
+		mustSave(t, p, "user", []*PlayerState{fullPlayerState("book 1"), fullPlayerState("book 2")})
+		mustRemove(t, p, "user", 0)
+		mustSave(t, p, "other_user", []*PlayerState{fullPlayerState("book 3")})
+
+		setUserIDs(t, p, mustNewUserIDHasher(t, false))
+
+		if rekeyed := mustRekey(t, p); rekeyed != 2 {
+			t.Fatalf("expected 2 records to be rekeyed, got %d", rekeyed)
+		}
+
+		assertStates(t, mustLoad(t, p, "user"), []*PlayerState{fullPlayerState("book 2")})
+		assertTrash(t, mustLoadTrash(t, p, "user"), []*PlayerState{fullPlayerState("book 1")})
+		assertStates(t, mustLoad(t, p, "other_user"), []*PlayerState{fullPlayerState("book 3")})
+
+		if count := mustCountLegacyUserIDs(t, p); count != 0 {
+			t.Errorf("expected no records stored under legacy user IDs, got %d", count)
+		}
+
+		if rekeyed := mustRekey(t, p); rekeyed != 0 {
+			t.Errorf("expected nothing to be rekeyed again, got %d", rekeyed)
+		}
+	})
+
+	t.Run("RekeyingKeepsPepperedRecords", func(t *testing.T) {
+		p := newPersistor(t)
+
+		mustSave(t, p, "user", []*PlayerState{fullPlayerState("book 1")})
+
+		setUserIDs(t, p, mustNewUserIDHasher(t, false))
+		mustSave(t, p, "user", []*PlayerState{fullPlayerState("book 2")})
+
+		if rekeyed := mustRekey(t, p); rekeyed != 0 {
+			t.Fatalf("expected nothing to be rekeyed, got %d", rekeyed)
+		}
+
+		assertStates(t, mustLoad(t, p, "user"), []*PlayerState{fullPlayerState("book 2")})
+
+		if count := mustCountLegacyUserIDs(t, p); count != 1 {
+			t.Errorf("expected the legacy record to be left in place, got %d records stored under legacy user IDs", count)
+		}
+	})
+
+	t.Run("DeletingRemovesLegacyRecordAsWell", func(t *testing.T) {
+		p := newPersistor(t)
+
+		mustSave(t, p, "user", []*PlayerState{fullPlayerState("book 1")})
+
+		// As left behind by rekeying in case deleting the legacy record failed
+		setUserIDs(t, p, mustNewUserIDHasher(t, false))
+		mustSave(t, p, "user", []*PlayerState{fullPlayerState("book 1")})
+
+		setUserIDs(t, p, mustNewUserIDHasher(t, true))
+		if err := p.DeleteUserRecord(t.Context(), "user"); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if count := mustCountLegacyUserIDs(t, p); count != 0 {
+			t.Errorf("expected the legacy record to be deleted as well, got %d records stored under legacy user IDs", count)
+		}
+		assertStates(t, mustLoad(t, p, "user"), []*PlayerState{})
+
+		if err := p.DeleteUserRecord(t.Context(), "user"); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("expected ErrUserNotFound, got %v", err)
+		}
+	})
+
+	t.Run("RekeyingRequiresPepper", func(t *testing.T) {
+		p := newPersistor(t)
+
+		if _, err := p.(UserIDRekeyer).RekeyUserIDs(t.Context()); !errors.Is(err, ErrNoPepper) {
+			t.Fatalf("expected ErrNoPepper, got %v", err)
+		}
+	})
+
+	t.Run("ModificationsCountAsActivity", func(t *testing.T) {
+		p := newPersistor(t)
+
+		mustSave(t, p, "user", []*PlayerState{fullPlayerState("book 1")})
+		mustAppend(t, p, "other_user", fullPlayerState("book 2"))
+
+		if count := mustCountInactiveUsers(t, p, time.Now().Add(-time.Hour)); count != 0 {
+			t.Errorf("expected no inactive users, got %d", count)
+		}
+
+		// Activity is tracked with a resolution of seconds
+		if count := mustCountInactiveUsers(t, p, time.Now().Add(2*time.Second)); count != 2 {
+			t.Errorf("expected 2 inactive users, got %d", count)
+		}
+	})
+
+	t.Run("PurgingDeletesOnlyInactiveUsers", func(t *testing.T) {
+		p := newPersistor(t)
+
+		mustSave(t, p, "user", []*PlayerState{fullPlayerState("book 1"), fullPlayerState("book 2")})
+		mustRemove(t, p, "user", 0)
+
+		purged, err := p.PurgeInactiveUsers(t.Context(), time.Now().Add(-time.Hour))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if purged != 0 {
+			t.Fatalf("expected no user to be purged, got %d", purged)
+		}
+		assertStates(t, mustLoad(t, p, "user"), []*PlayerState{fullPlayerState("book 2")})
+
+		purged, err = p.PurgeInactiveUsers(t.Context(), time.Now().Add(2*time.Second))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if purged != 1 {
+			t.Fatalf("expected 1 user to be purged, got %d", purged)
+		}
+
+		assertStates(t, mustLoad(t, p, "user"), []*PlayerState{})
+		assertTrash(t, mustLoadTrash(t, p, "user"), []*PlayerState{})
+
+		if _, err := p.FetchJSONDump(t.Context(), "user"); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("expected ErrUserNotFound, got %v", err)
+		}
+	})
+
+	t.Run("PinningIsKeptWhenReplacing", func(t *testing.T) {
+		p := newPersistor(t)
+
+		mustSave(t, p, "user", []*PlayerState{fullPlayerState("book 1"), fullPlayerState("book 2")})
+		_, revision := mustLoadWithRevision(t, p, "user")
+
+		if err := p.SetPinned(t.Context(), "user", revision, 1, true); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := p.SetPinned(t.Context(), "user", revision, 0, true); !errors.Is(err, ErrConflict) {
+			t.Errorf("expected ErrConflict when pinning with a stale revision, got %v", err)
+		}
+
+		_, revision = mustLoadWithRevision(t, p, "user")
+		if err := p.ReplaceState(t.Context(), "user", revision, 1, fullPlayerState("book 3")); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		pinned := fullPlayerState("book 2")
+		pinned.Pinned = true
+		replacement := fullPlayerState("book 3")
+		replacement.Pinned = true
+		replacement.History = []*PlayerState{pinned}
+
+		assertStates(t, mustLoad(t, p, "user"), []*PlayerState{fullPlayerState("book 1"), replacement})
+
+		_, revision = mustLoadWithRevision(t, p, "user")
+		if err := p.SetPinned(t.Context(), "user", revision, 1, false); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if loaded := mustLoad(t, p, "user"); loaded[1].Pinned {
+			t.Error("expected slot to be unpinned")
+		}
+	})
+}
+
+// setUserIDs changes how the given persistor hashes user IDs without touching the stored records.
+func setUserIDs(t *testing.T, p PlayerStatesPersistor, userIDs *UserIDHasher) {
+	t.Helper()
+
+	switch p := p.(type) {
+	case *PlayerStatesDAO:
+		p.userIDs = userIDs
+	case *SQLPersistor:
+		p.userIDs = userIDs
+	case *MemoryPersistor:
+		p.userIDs = userIDs
+	default:
+		t.Fatalf("unsupported persistor %T", p)
+	}
+}
+
+func mustNewUserIDHasher(t *testing.T, acceptLegacy bool) *UserIDHasher {
+	t.Helper()
+
+	userIDs, err := NewUserIDHasher("a pepper which is long enough to be accepted", acceptLegacy)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	return userIDs
+}
+
+func mustCountInactiveUsers(t *testing.T, p PlayerStatesPersistor, inactiveSince time.Time) int64 {
+	t.Helper()
+
+	count, err := p.CountInactiveUsers(t.Context(), inactiveSince)
+	if err != nil {
+		t.Fatalf("could not count inactive users: %s", err)
+	}
+
+	return count
+}
+
+func mustFetchDump(t *testing.T, p PlayerStatesPersistor, userID string) []byte {
+	t.Helper()
+
+	dump, err := p.FetchJSONDump(t.Context(), userID)
+	if err != nil {
+		t.Fatalf("could not fetch dump: %s", err)
+	}
+
+	return dump
+}
+
+func mustCountLegacyUserIDs(t *testing.T, p PlayerStatesPersistor) int64 {
+	t.Helper()
+
+	count, err := p.(UserIDRekeyer).LegacyUserIDs(t.Context())
+	if err != nil {
+		t.Fatalf("could not count records stored under legacy user IDs: %s", err)
+	}
+
+	return count
+}
+
+func mustRekey(t *testing.T, p PlayerStatesPersistor) int {
+	t.Helper()
+
+	rekeyed, err := p.(UserIDRekeyer).RekeyUserIDs(t.Context())
+	if err != nil {
+		t.Fatalf("could not rekey user IDs: %s", err)
+	}
+
+	return rekeyed
+}
+
+func mustSave(t *testing.T, p PlayerStatesPersistor, userID string, playerStates []*PlayerState) {
+	t.Helper()
+
+	if err := p.SavePlayerStates(t.Context(), userID, playerStates); err != nil {
+		t.Fatalf("could not save player states: %s", err)
+	}
+}
+
+func mustLoad(t *testing.T, p PlayerStatesPersistor, userID string) []*PlayerState {
+	t.Helper()
+
+	playerStates, _ := mustLoadWithRevision(t, p, userID)
+
+	return playerStates
+}
+
+func mustLoadWithRevision(t *testing.T, p PlayerStatesPersistor, userID string) ([]*PlayerState, int64) {
+	t.Helper()
+
+	playerStates, revision, err := p.LoadPlayerStates(t.Context(), userID)
+	if err != nil {
+		t.Fatalf("could not load player states: %s", err)
+	}
+
+	return playerStates, revision
+}
+
+func mustAppend(t *testing.T, p PlayerStatesPersistor, userID string, playerStates ...*PlayerState) {
+	t.Helper()
+
+	for _, playerState := range playerStates {
+		if err := p.AppendState(t.Context(), userID, playerState); err != nil {
+			t.Fatalf("could not append player state: %s", err)
+		}
+	}
+}
+
+func mustRemove(t *testing.T, p PlayerStatesPersistor, userID string, slot int) {
+	t.Helper()
+
+	_, revision := mustLoadWithRevision(t, p, userID)
+
+	if err := p.RemoveState(t.Context(), userID, revision, slot); err != nil {
+		t.Fatalf("could not remove player state: %s", err)
+	}
+}
+
+func mustLoadTrash(t *testing.T, p PlayerStatesPersistor, userID string) []*PlayerState {
+	t.Helper()
+
+	trash, err := p.LoadTrash(t.Context(), userID)
+	if err != nil {
+		t.Fatalf("could not load trash: %s", err)
+	}
+
+	return trash
+}
+
+// assertTrash compares the slots in the trash ignoring the point in time they got removed at,
+// it only has to be set.
+func assertTrash(t *testing.T, actual, expected []*PlayerState) {
+	t.Helper()
+
+	actual = copyPlayerStates(actual)
+	for i, playerState := range actual {
+		if playerState.RemovedAtTs == 0 {
+			t.Errorf("player state at index %d in trash has no removal timestamp", i)
+		}
+
+		playerState.RemovedAtTs = 0
+	}
+
+	assertStates(t, actual, expected)
+}
+
+func assertStates(t *testing.T, actual, expected []*PlayerState) {
+	t.Helper()
+
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d player states, got %d", len(expected), len(actual))
+	}
+
+	for i := range expected {
+		expectedState := expected[i]
+		actualState := actual[i]
+		if actualState == nil {
+			t.Errorf("player state at index %d is nil", i)
+			continue
+		}
+
+		// Histories are compared on their own, this way the handling of IDs applies to them as well
+		assertStates(t, actualState.History, expectedState.History)
+
+		expectedCopy, actualCopy := *expectedState, *actualState
+		expectedCopy.History, actualCopy.History = nil, nil
+
+		if expectedCopy.ID == "" {
+			// IDs get assigned when storing states without one, we can only check there is one
+			if actualCopy.ID == "" {
+				t.Errorf("player state at index %d has no ID", i)
+			}
+
+			expectedCopy.ID = actualCopy.ID
+		}
+
+		if !reflect.DeepEqual(actualCopy, expectedCopy) {
+			t.Errorf("player state at index %d differs:\nexpected: %#v\nactual:   %#v", i, expectedCopy, actualCopy)
+		}
+	}
+}
+
+func fullPlayerState(albumName string) *PlayerState {
+	return &PlayerState{
+		PlaybackContextURI: "spotify:album:" + albumName,
+		PlaybackItemURI:    "spotify:track:" + albumName,
+		LinkToContext:      "https://open.spotify.com/album/" + albumName,
+		ContextType:        "album",
+		AlbumArtLargeURL:   "https://i.scdn.co/image/large",
+		AlbumArtMediumURL:  "https://i.scdn.co/image/medium",
+		TrackName:          "Chapter 7",
+		AlbumName:          albumName,
+		ArtistName:         "Some Author",
+		NarratorName:       "Some Narrator",
+		TrackIndex:         7,
+		TotalTracks:        42,
+		Progress:           123456,
+		Duration:           234567,
+		ShuffleActivated:   true,
+		SuspendedAtTs:      1600000000,
+	}
+}
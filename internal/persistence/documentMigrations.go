@@ -0,0 +1,211 @@
+package persistence
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// Documents without a 'version' field predate versioning
+	initialVersion = 1
+)
+
+var (
+	ErrDocumentTooNew = errors.New("document has been written by a newer version of Cassette")
+)
+
+// document is the format-agnostic representation of a persistenceItem used for upgrading it.
+// Keys are the field names used in BSON resp. JSON, nested documents are maps as well, arrays are slices.
+type document map[string]interface{}
+
+// documentMigration upgrades a document from version 'from' to version 'from + 1'.
+type documentMigration struct {
+	from        int
+	description string
+	up          func(doc document) error
+}
+
+type documentMigrations []documentMigration
+
+// registeredMigrations contains one step for every version prior to currentVersion, ordered by version.
+// Whenever currentVersion gets bumped a step has to be appended here.
+var registeredMigrations = documentMigrations{
+	// The changes leading to versions 2 and 3 predate this registry. As documents of these versions
+	// have always been decoded blindly into the current PlayerState, they are carried over unchanged.
+	{from: 1, description: "carry over documents of version 1", up: func(doc document) error { return nil }},
+	{from: 2, description: "carry over documents of version 2", up: func(doc document) error { return nil }},
+	{from: 3, description: "assign stable IDs to slots", up: assignSlotIDs},
+	// Sealed player states are opened before upgrading a document, so there is nothing to change. Bumping the
+	// version makes previous versions of Cassette refuse documents they would not be able to read.
+	{from: 4, description: "allow player states to be sealed", up: func(doc document) error { return nil }},
+	{from: 5, description: "track last activity", up: trackLastActivity},
+}
+
+// assignSlotIDs gives every player state an ID. Documents get upgraded lazily and writing them back might
+// fail, so the IDs are derived from the document instead of being random. This way every read of a not
+// yet stored upgrade yields the same IDs.
+func assignSlotIDs(doc document) error {
+	playerStates, ok := doc["playerStates"].([]interface{})
+	if !ok {
+		// No (or a null) array, there are no slots to assign IDs to
+		return nil
+	}
+
+	for i, rawState := range playerStates {
+		state, ok := rawState.(document)
+		if !ok {
+			return fmt.Errorf("player state at index %d is not a document", i)
+		}
+
+		if id, _ := state["id"].(string); id != "" {
+			continue
+		}
+
+		hash := sha256.Sum256([]byte(fmt.Sprintf("%v/%d/%v/%v", doc["_id"], i, state["playbackItemURI"], state["suspendedAtTs"])))
+		state["id"] = hex.EncodeToString(hash[:16])
+	}
+
+	return nil
+}
+
+// trackLastActivity starts tracking the activity of the user as of now. Documents get upgraded when being
+// accessed or by an explicit migration, either way they must not be considered inactive right away.
+func trackLastActivity(doc document) error {
+	if _, ok := doc["lastActivityTs"]; !ok {
+		doc["lastActivityTs"] = time.Now().Unix()
+	}
+
+	return nil
+}
+
+// upgrade brings the given document to targetVersion by applying all required steps in order.
+// It reports the version the document had before and fails with ErrDocumentTooNew in case the
+// document is newer than targetVersion.
+func (m documentMigrations) upgrade(doc document, targetVersion int) (int, error) {
+	originalVersion, err := doc.version()
+	if err != nil {
+		return 0, err
+	}
+
+	if originalVersion > targetVersion {
+		return originalVersion, fmt.Errorf("%w: version %d, supported up to %d", ErrDocumentTooNew, originalVersion, targetVersion)
+	}
+
+	for version := originalVersion; version < targetVersion; version++ {
+		step, ok := m.stepFrom(version)
+		if !ok {
+			return originalVersion, fmt.Errorf("no migration registered for upgrading documents of version %d", version)
+		}
+
+		if err := step.up(doc); err != nil {
+			return originalVersion, fmt.Errorf("failed to upgrade document from version %d (%s): %w", version, step.description, err)
+		}
+
+		doc["version"] = version + 1
+	}
+
+	return originalVersion, nil
+}
+
+func (m documentMigrations) stepFrom(version int) (documentMigration, bool) {
+	for _, step := range m {
+		if step.from == version {
+			return step, true
+		}
+	}
+
+	return documentMigration{}, false
+}
+
+func (d document) version() (int, error) {
+	rawVersion, ok := d["version"]
+	if !ok || rawVersion == nil {
+		return initialVersion, nil
+	}
+
+	version, ok := intValue(rawVersion)
+	if !ok {
+		return 0, fmt.Errorf("document has an invalid version '%v'", rawVersion)
+	}
+
+	return int(version), nil
+}
+
+func intValue(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float64: // that's how numbers get decoded from JSON
+		return int64(v), v == float64(int64(v))
+	default:
+		return 0, false
+	}
+}
+
+// toPersistenceItem converts the (upgraded) document into the struct used throughout the application.
+func (d document) toPersistenceItem() (*persistenceItem, error) {
+	raw, err := bson.Marshal(d)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode document: %w", err)
+	}
+
+	var item persistenceItem
+	if err := bson.Unmarshal(raw, &item); err != nil {
+		return nil, fmt.Errorf("could not decode document: %w", err)
+	}
+
+	return &item, nil
+}
+
+// newDocument converts a document as decoded by the MongoDB driver or encoding/json
+// into the representation expected by the migration steps.
+func newDocument(raw map[string]interface{}) document {
+	return normalizeValue(raw).(document)
+}
+
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		doc := make(document, len(v))
+		for key, nested := range v {
+			doc[key] = normalizeValue(nested)
+		}
+		return doc
+	case primitive.M:
+		return normalizeValue(map[string]interface{}(v))
+	case primitive.D:
+		doc := make(document, len(v))
+		for _, elem := range v {
+			doc[elem.Key] = normalizeValue(elem.Value)
+		}
+		return doc
+	case primitive.A:
+		return normalizeValue([]interface{}(v))
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, nested := range v {
+			values[i] = normalizeValue(nested)
+		}
+		return values
+	default:
+		return v
+	}
+}
+
+// DocumentMigrator is implemented by backends storing versioned documents which can be upgraded in bulk.
+// Backends with a relational schema migrate it when connecting instead.
+type DocumentMigrator interface {
+	DocumentVersions(ctx context.Context) (map[int]int64, error)
+	MigrateDocuments(ctx context.Context) (int, error)
+}
@@ -0,0 +1,168 @@
+package persistence
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestRegisteredMigrationsCoverAllVersions(t *testing.T) {
+	for version := initialVersion; version < currentVersion; version++ {
+		if _, ok := registeredMigrations.stepFrom(version); !ok {
+			t.Errorf("no migration registered for upgrading documents of version %d", version)
+		}
+	}
+}
+
+func TestUpgradeAppliesStepsInOrder(t *testing.T) {
+	migrations := documentMigrations{
+		{from: 1, description: "rename 'name'", up: func(doc document) error {
+			doc["albumName"] = doc["name"]
+			delete(doc, "name")
+			return nil
+		}},
+		{from: 2, description: "suffix 'albumName'", up: func(doc document) error {
+			doc["albumName"] = doc["albumName"].(string) + " (upgraded)"
+			return nil
+		}},
+	}
+
+	// A document without version is treated as being at the initial version
+	doc := document{"name": "book 1"}
+
+	originalVersion, err := migrations.upgrade(doc, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if originalVersion != initialVersion {
+		t.Errorf("expected original version to be %d, got %d", initialVersion, originalVersion)
+	}
+	if doc["albumName"] != "book 1 (upgraded)" {
+		t.Errorf("unexpected result of upgrade: %v", doc)
+	}
+	if doc["version"] != 3 {
+		t.Errorf("expected version to be 3, got %v", doc["version"])
+	}
+
+	// Upgrading twice must not do anything
+	if _, err := migrations.upgrade(doc, 3); err != nil || doc["albumName"] != "book 1 (upgraded)" {
+		t.Errorf("expected upgrade to be a no-op, got %v (err: %v)", doc, err)
+	}
+}
+
+func TestUpgradeRefusesNewerDocuments(t *testing.T) {
+	_, err := registeredMigrations.upgrade(document{"version": float64(currentVersion + 1)}, currentVersion)
+	if !errors.Is(err, ErrDocumentTooNew) {
+		t.Fatalf("expected ErrDocumentTooNew, got %v", err)
+	}
+}
+
+func TestUpgradeFailsOnMissingStep(t *testing.T) {
+	migrations := documentMigrations{{from: 1, up: func(doc document) error { return nil }}}
+
+	if _, err := migrations.upgrade(document{"version": 1}, 3); err == nil {
+		t.Fatal("expected upgrade to fail because of missing step")
+	}
+}
+
+func TestUpgradeStartsTrackingActivity(t *testing.T) {
+	doc := document{"version": 5}
+
+	if _, err := registeredMigrations.upgrade(doc, currentVersion); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	lastActivityTs, ok := intValue(doc["lastActivityTs"])
+	if !ok || time.Since(time.Unix(lastActivityTs, 0)) > time.Minute {
+		t.Errorf("expected the last activity to be now, got %v", doc["lastActivityTs"])
+	}
+}
+
+func TestDocumentsFromJSONAndBSONAreEquivalent(t *testing.T) {
+	expected := persistenceItem{
+		Version:      1,
+		UserID:       legacyHashUserID("user"),
+		PlayerStates: []*PlayerState{fullPlayerState("book 1"), fullPlayerState("book 2")},
+	}
+
+	raw, err := bson.Marshal(expected)
+	if err != nil {
+		t.Fatalf("could not encode item: %s", err)
+	}
+	var fromBSON bson.M
+	if err := bson.Unmarshal(raw, &fromBSON); err != nil {
+		t.Fatalf("could not decode item: %s", err)
+	}
+
+	// The URIs are not contained in the JSON representation
+	for _, state := range expected.PlayerStates {
+		state.PlaybackContextURI = ""
+		state.PlaybackItemURI = ""
+	}
+
+	rawJSON, err := json.Marshal(expected)
+	if err != nil {
+		t.Fatalf("could not encode item: %s", err)
+	}
+	var fromJSON map[string]interface{}
+	if err := json.Unmarshal(rawJSON, &fromJSON); err != nil {
+		t.Fatalf("could not decode item: %s", err)
+	}
+
+	for name, raw := range map[string]map[string]interface{}{"bson": fromBSON, "json": fromJSON} {
+		doc := newDocument(raw)
+
+		if _, err := registeredMigrations.upgrade(doc, currentVersion); err != nil {
+			t.Fatalf("%s: unexpected error: %s", name, err)
+		}
+
+		item, err := doc.toPersistenceItem()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", name, err)
+		}
+
+		if item.Version != currentVersion || item.UserID != expected.UserID {
+			t.Errorf("%s: unexpected version or ID: %d, '%s'", name, item.Version, item.UserID)
+		}
+
+		if name == "json" {
+			assertStates(t, item.PlayerStates, expected.PlayerStates)
+		} else {
+			assertStates(t, item.PlayerStates, []*PlayerState{fullPlayerState("book 1"), fullPlayerState("book 2")})
+		}
+	}
+}
+
+func TestAssigningSlotIDsIsDeterministic(t *testing.T) {
+	newDoc := func() document {
+		return document{"_id": "user", "version": 3, "playerStates": []interface{}{
+			document{"playbackItemURI": "spotify:track:1", "suspendedAtTs": int64(1)},
+			document{"playbackItemURI": "spotify:track:1", "suspendedAtTs": int64(1)},
+			document{"id": "existing", "playbackItemURI": "spotify:track:2"},
+		}}
+	}
+
+	first, second := newDoc(), newDoc()
+	for _, doc := range []document{first, second} {
+		if _, err := registeredMigrations.upgrade(doc, currentVersion); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	firstStates, secondStates := first["playerStates"].([]interface{}), second["playerStates"].([]interface{})
+
+	id0, id1 := firstStates[0].(document)["id"], firstStates[1].(document)["id"]
+	if id0 == "" || id0 == id1 {
+		t.Errorf("expected distinct IDs, got '%v' and '%v'", id0, id1)
+	}
+	if id0 != secondStates[0].(document)["id"] || id1 != secondStates[1].(document)["id"] {
+		t.Errorf("expected upgrading the same document twice to yield the same IDs")
+	}
+	if firstStates[2].(document)["id"] != "existing" {
+		t.Errorf("expected existing ID to be kept, got '%v'", firstStates[2].(document)["id"])
+	}
+}
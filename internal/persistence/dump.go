@@ -0,0 +1,229 @@
+package persistence
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var (
+	ErrInvalidDump = errors.New("invalid dump")
+)
+
+// InvalidEntry describes a player state of a dump which has not been imported.
+type InvalidEntry struct {
+	Index  int    `json:"index"` // index within the 'playerStates' of the dump
+	Reason string `json:"reason"`
+}
+
+// dumpItem is the JSON representation of a record returned by FetchJSONDump. It is the one the record has
+// always been exported in, only extended by the fields of the player states required for importing them again.
+type dumpItem struct {
+	Version        int                `json:"version"`
+	UserID         string             `json:"_id"`
+	PlayerStates   []*dumpPlayerState `json:"playerStates"`
+	Trash          []*dumpPlayerState `json:"trash"`
+	LastActivityTs int64              `json:"lastActivityTs"`
+}
+
+// dumpPlayerState adds the fields hidden from the API's representation of a player state.
+type dumpPlayerState struct {
+	*PlayerState
+	PlaybackContextURI string             `json:"playbackContextURI"`
+	PlaybackItemURI    string             `json:"playbackItemURI"`
+	History            []*dumpPlayerState `json:"history,omitempty"`
+}
+
+// marshalDump converts the given record into the JSON returned by FetchJSONDump, which can be imported
+// again by ParseDump.
+func marshalDump(item *persistenceItem) ([]byte, error) {
+	dump, err := json.Marshal(dumpItem{
+		Version:        item.Version,
+		UserID:         item.UserID,
+		PlayerStates:   dumpPlayerStates(item.PlayerStates),
+		Trash:          dumpPlayerStates(item.Trash),
+		LastActivityTs: item.LastActivityTs,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not convert record to JSON: %w", err)
+	}
+
+	return dump, nil
+}
+
+func dumpPlayerStates(playerStates []*PlayerState) []*dumpPlayerState {
+	if playerStates == nil {
+		return nil
+	}
+
+	dumped := make([]*dumpPlayerState, len(playerStates))
+	for i, playerState := range playerStates {
+		dumped[i] = &dumpPlayerState{
+			PlayerState:        playerState,
+			PlaybackContextURI: playerState.PlaybackContextURI,
+			PlaybackItemURI:    playerState.PlaybackItemURI,
+			History:            dumpPlayerStates(playerState.History),
+		}
+	}
+
+	return dumped
+}
+
+// ParseDump reads the player states contained in a dump as returned by FetchJSONDump. Dumps written by
+// any previous version of Cassette get upgraded first. Player states which cannot be decoded or are not valid
+// are reported instead of failing the whole import.
+func ParseDump(data []byte) ([]*PlayerState, []InvalidEntry, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrInvalidDump, err)
+	}
+
+	doc := newDocument(raw)
+
+	if _, err := registeredMigrations.upgrade(doc, currentVersion); err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrInvalidDump, err)
+	}
+
+	rawStates, ok := doc["playerStates"].([]interface{})
+	if !ok && doc["playerStates"] != nil {
+		return nil, nil, fmt.Errorf("%w: 'playerStates' is not an array", ErrInvalidDump)
+	}
+
+	playerStates := make([]*PlayerState, 0, len(rawStates))
+	invalid := make([]InvalidEntry, 0)
+
+	for i, rawState := range rawStates {
+		playerState, err := decodePlayerState(rawState)
+		if err == nil {
+			err = validatePlayerState(playerState)
+		}
+
+		if err != nil {
+			invalid = append(invalid, InvalidEntry{Index: i, Reason: err.Error()})
+			continue
+		}
+
+		playerStates = append(playerStates, playerState)
+	}
+
+	return playerStates, invalid, nil
+}
+
+func decodePlayerState(rawState interface{}) (*PlayerState, error) {
+	stateDoc, ok := rawState.(document)
+	if !ok {
+		return nil, errors.New("not an object")
+	}
+
+	raw, err := bson.Marshal(stateDoc)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode player state: %w", err)
+	}
+
+	var playerState PlayerState
+	if err := bson.Unmarshal(raw, &playerState); err != nil {
+		return nil, fmt.Errorf("could not decode player state: %w", err)
+	}
+
+	// Only slots in the trash are marked as removed
+	playerState.RemovedAtTs = 0
+
+	return &playerState, nil
+}
+
+// completeLegacySnapshot fills in the URI of the context for player states exported before dumps contained
+// URIs at all, it tells whether the given state is such a state. The context is derived from the link to it,
+// the item is not known. Playback of such a state gets resumed with the item at its index resp. the one with
+// its name.
+func completeLegacySnapshot(playerState *PlayerState) bool {
+	if playerState.PlaybackContextURI != "" || playerState.PlaybackItemURI != "" {
+		return false
+	}
+
+	playerState.PlaybackContextURI = contextURIOfLink(playerState.LinkToContext)
+
+	return true
+}
+
+// contextURIOfLink converts a link like "https://open.spotify.com/album/<ID>?si=..." into the URI of the
+// context, "" if it is no such link.
+func contextURIOfLink(link string) string {
+	u, err := url.Parse(link)
+	if err != nil || u.Host != "open.spotify.com" {
+		return ""
+	}
+
+	// Older links to playlists contain their owner, e.g. "/user/<user ID>/playlist/<ID>"
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(segments) < 2 {
+		return ""
+	}
+
+	typ, id := segments[len(segments)-2], segments[len(segments)-1]
+	switch typ {
+	case "album", "playlist", "show", "audiobook":
+		if id == "" {
+			return ""
+		}
+
+		return "spotify:" + typ + ":" + id
+	default:
+		return ""
+	}
+}
+
+// validatePlayerState ensures the given state (including its history) can be restored. States exported
+// before dumps contained URIs get completed as far as possible.
+func validatePlayerState(playerState *PlayerState) error {
+	// States exported before slots had IDs get one when being stored
+	if playerState.ID != "" && !isSlotID(playerState.ID) {
+		return fmt.Errorf("'id' is not a slot ID as generated by cassette: '%s'", playerState.ID)
+	}
+
+	if err := validateSnapshot(playerState, completeLegacySnapshot(playerState)); err != nil {
+		return err
+	}
+
+	if len(playerState.History) > historyLength {
+		return fmt.Errorf("history contains %d entries, at most %d are allowed", len(playerState.History), historyLength)
+	}
+
+	for i, snapshot := range playerState.History {
+		if err := validateSnapshot(snapshot, completeLegacySnapshot(snapshot)); err != nil {
+			return fmt.Errorf("history entry %d: %w", i, err)
+		}
+
+		snapshot.RemovedAtTs = 0
+		snapshot.History = nil
+	}
+
+	return nil
+}
+
+// validateSnapshot checks a single state, the item of legacy states is not known.
+func validateSnapshot(playerState *PlayerState, legacy bool) error {
+	switch {
+	case legacy && playerState.PlaybackContextURI == "":
+		return fmt.Errorf("neither URIs nor a link to an album, playlist, show or audiobook are given: '%s'", playerState.LinkToContext)
+	case playerState.PlaybackContextURI == "" && !strings.HasPrefix(playerState.PlaybackItemURI, "spotify:episode:"):
+		// Episodes might have been played without any context
+		return errors.New("'playbackContextURI' is missing, only episodes can be resumed without their context")
+	case playerState.PlaybackContextURI != "" && !strings.HasPrefix(playerState.PlaybackContextURI, "spotify:"):
+		return fmt.Errorf("'playbackContextURI' is not a Spotify URI: '%s'", playerState.PlaybackContextURI)
+	case !legacy && !strings.HasPrefix(playerState.PlaybackItemURI, "spotify:"):
+		return fmt.Errorf("'playbackItemURI' is not a Spotify URI: '%s'", playerState.PlaybackItemURI)
+	case playerState.TrackIndex < -1 || playerState.TotalTracks < -1:
+		// -1 means the item could not be located within its context
+		return errors.New("'trackIndex' and 'totalTracks' must not be less than -1")
+	case playerState.Progress < 0 || playerState.Duration < 0:
+		return errors.New("'progress' and 'duration' must not be negative")
+	case playerState.SuspendedAtTs < 0:
+		return errors.New("'suspendedAtTs' must not be negative")
+	}
+
+	return nil
+}
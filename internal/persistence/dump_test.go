@@ -0,0 +1,160 @@
+package persistence
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseDumpUpgradesLegacyDumps(t *testing.T) {
+	// As exported by version 3, player states did not contain any URIs back then
+	dump := `{"version":3,"_id":"5E8C3B0F","playerStates":[` +
+		`{"linkToContext":"https://open.spotify.com/album/album1","contextType":"album","albumArtLargeURL":"large","albumArtMediumURL":"medium","trackName":"Chapter 3","albumName":"book 1","artistName":"author","trackIndex":3,"totalTracks":20,"progress":1000,"duration":2000,"shuffleActivated":false,"suspendedAtTs":1600000000},` +
+		`{"linkToContext":"https://open.spotify.com/playlist/playlist1","contextType":"playlist","playlistName":"bedtime","albumArtLargeURL":"","albumArtMediumURL":"","trackName":"Chapter 9","albumName":"book 2","artistName":"author","trackIndex":9,"totalTracks":12,"progress":3000,"duration":4000,"shuffleActivated":true,"suspendedAtTs":1600000100}` +
+		`]}`
+
+	parsed, invalid, err := ParseDump([]byte(dump))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(invalid) != 0 {
+		t.Fatalf("expected no invalid entries, got %#v", invalid)
+	}
+
+	assertStates(t, parsed, []*PlayerState{{
+		PlaybackContextURI: "spotify:album:album1",
+		LinkToContext:      "https://open.spotify.com/album/album1",
+		ContextType:        "album",
+		AlbumArtLargeURL:   "large",
+		AlbumArtMediumURL:  "medium",
+		TrackName:          "Chapter 3",
+		AlbumName:          "book 1",
+		ArtistName:         "author",
+		TrackIndex:         3,
+		TotalTracks:        20,
+		Progress:           1000,
+		Duration:           2000,
+		SuspendedAtTs:      1600000000,
+	}, {
+		PlaybackContextURI: "spotify:playlist:playlist1",
+		LinkToContext:      "https://open.spotify.com/playlist/playlist1",
+		ContextType:        "playlist",
+		PlaylistName:       "bedtime",
+		TrackName:          "Chapter 9",
+		AlbumName:          "book 2",
+		ArtistName:         "author",
+		TrackIndex:         9,
+		TotalTracks:        12,
+		Progress:           3000,
+		Duration:           4000,
+		ShuffleActivated:   true,
+		SuspendedAtTs:      1600000100,
+	}})
+}
+
+func TestParseDumpRejectsLegacyStatesWithoutContext(t *testing.T) {
+	dump := `{"version":3,"_id":"5E8C3B0F","playerStates":[` +
+		`{"linkToContext":"https://open.spotify.com/artist/artist1","contextType":"album","trackName":"Chapter 3","trackIndex":3},` +
+		`{"contextType":"album","trackName":"Chapter 3","trackIndex":3}` +
+		`]}`
+
+	parsed, invalid, err := ParseDump([]byte(dump))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(parsed) != 0 || len(invalid) != 2 {
+		t.Fatalf("expected both entries to be invalid, got %#v and %#v", parsed, invalid)
+	}
+	if !strings.Contains(invalid[0].Reason, "link") {
+		t.Errorf("expected reason to tell about the link, got '%s'", invalid[0].Reason)
+	}
+}
+
+func TestContextURIOfLink(t *testing.T) {
+	for link, expected := range map[string]string{
+		"https://open.spotify.com/album/album1":                  "spotify:album:album1",
+		"https://open.spotify.com/album/album1?si=abc":           "spotify:album:album1",
+		"https://open.spotify.com/user/user1/playlist/playlist1": "spotify:playlist:playlist1",
+		"https://open.spotify.com/show/show1":                    "spotify:show:show1",
+		"https://open.spotify.com/artist/artist1":                "",
+		"https://example.com/album/album1":                       "",
+		"":                                                       "",
+	} {
+		if uri := contextURIOfLink(link); uri != expected {
+			t.Errorf("expected '%s' for '%s', got '%s'", expected, link, uri)
+		}
+	}
+}
+
+func TestParseDumpReportsInvalidEntries(t *testing.T) {
+	dump := `{"_id": "ABC", "version": 4, "playerStates": [
+		{"id": "0000000000000000000000000000000a", "playbackContextURI": "spotify:album:1", "playbackItemURI": "spotify:track:1"},
+		{"id": "0000000000000000000000000000000b", "playbackItemURI": "spotify:track:2"},
+		{"id": "0000000000000000000000000000000c", "playbackContextURI": "spotify:album:3", "playbackItemURI": "spotify:track:3", "progress": -1},
+		{"id": "0000000000000000000000000000000d", "playbackContextURI": "spotify:album:4", "playbackItemURI": "spotify:track:4", "progress": "a lot"},
+		"not a player state",
+		{"id": "0000000000000000000000000000000e", "playbackContextURI": "spotify:album:5", "playbackItemURI": "spotify:track:5", "history": [{"playbackContextURI": "spotify:album:5"}]}
+	]}`
+
+	parsed, invalid, err := ParseDump([]byte(dump))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(parsed) != 1 || parsed[0].ID != "0000000000000000000000000000000a" {
+		t.Fatalf("expected only the first entry to be valid, got %#v", parsed)
+	}
+
+	expectedIndices := []int{1, 2, 3, 4, 5}
+	if len(invalid) != len(expectedIndices) {
+		t.Fatalf("expected %d invalid entries, got %#v", len(expectedIndices), invalid)
+	}
+	for i, entry := range invalid {
+		if entry.Index != expectedIndices[i] || entry.Reason == "" {
+			t.Errorf("unexpected invalid entry %#v", entry)
+		}
+	}
+	if !strings.Contains(invalid[4].Reason, "history entry 0") {
+		t.Errorf("expected reason to point to the invalid history entry, got '%s'", invalid[4].Reason)
+	}
+}
+
+func TestParseDumpRejectsInvalidSlotIDs(t *testing.T) {
+	dump := `{"_id": "ABC", "version": 4, "playerStates": [
+		{"id": "3", "playbackContextURI": "spotify:album:1", "playbackItemURI": "spotify:track:1"},
+		{"id": "../../trash", "playbackContextURI": "spotify:album:2", "playbackItemURI": "spotify:track:2"},
+		{"id": "0000000000000000000000000000000A", "playbackContextURI": "spotify:album:3", "playbackItemURI": "spotify:track:3"},
+		{"id": "0123456789abcdef0123456789abcdef", "playbackContextURI": "spotify:album:4", "playbackItemURI": "spotify:track:4"},
+		{"playbackContextURI": "spotify:album:5", "playbackItemURI": "spotify:track:5"}
+	]}`
+
+	parsed, invalid, err := ParseDump([]byte(dump))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(parsed) != 2 || parsed[0].ID != "0123456789abcdef0123456789abcdef" || parsed[1].ID != "" {
+		t.Fatalf("expected only the entries with a valid resp. without an ID to be parsed, got %#v", parsed)
+	}
+	if len(invalid) != 3 {
+		t.Fatalf("expected 3 invalid entries, got %#v", invalid)
+	}
+	for i, entry := range invalid {
+		if entry.Index != i || !strings.Contains(entry.Reason, "'id'") {
+			t.Errorf("unexpected invalid entry %#v", entry)
+		}
+	}
+}
+
+func TestParseDumpRejectsUnsupportedDumps(t *testing.T) {
+	for name, dump := range map[string]string{
+		"no JSON":                `player states`,
+		"newer version":          `{"_id": "ABC", "version": 99, "playerStates": []}`,
+		"player states no array": `{"_id": "ABC", "version": 4, "playerStates": {}}`,
+	} {
+		if _, _, err := ParseDump([]byte(dump)); !errors.Is(err, ErrInvalidDump) {
+			t.Errorf("%s: expected ErrInvalidDump, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package persistence
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// Master keys have to be at least this long, key encryption keys and data keys always are
+	keySize = 32
+	// Prefix of the info used for deriving key encryption keys from the master keys
+	keyDerivationInfo = "cassette player states "
+	// Key ID reported for player states stored in plaintext
+	plaintextKeyID = ""
+)
+
+var (
+	ErrNoMasterKey = errors.New("player states are encrypted but no master key is configured")
+)
+
+// Keyring seals the player states stored by PlayerStatesDAO using envelope encryption: every player state
+// is encrypted with a random data key of its own, which in turn is encrypted with a key derived from one of
+// the master keys. The ID of the master key is stored next to the data key, this way master keys can be rotated:
+// new states always get sealed with the current master key, previous ones are only used for opening.
+// Only the ID of a slot and, for slots in the trash, the point in time it got removed at stay readable.
+// Sealed states are bound to the (hashed) user ID and slot they are stored for, so they cannot be moved unnoticed.
+type Keyring struct {
+	currentKeyID string
+	// key encryption keys by the ID of the master key they are derived from
+	keys map[string][]byte
+}
+
+// NewKeyring parses the given list of master keys formatted as "<id>:<base64 encoded key>,...".
+// The first key is the current one.
+func NewKeyring(masterKeys string) (*Keyring, error) {
+	keyring := &Keyring{keys: make(map[string][]byte)}
+
+	for _, entry := range strings.Split(masterKeys, ",") {
+		id, encodedKey, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("master key '%s' is not formatted as '<id>:<base64 encoded key>'", entry)
+		}
+
+		if _, ok := keyring.keys[id]; ok {
+			return nil, fmt.Errorf("master key ID '%s' is used more than once", id)
+		}
+
+		masterKey, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil {
+			return nil, fmt.Errorf("master key '%s' is not base64 encoded: %w", id, err)
+		}
+
+		if len(masterKey) < keySize {
+			return nil, fmt.Errorf("master key '%s' has to be at least %d bytes long", id, keySize)
+		}
+
+		kek, err := hkdf.Key(sha256.New, masterKey, nil, keyDerivationInfo+id, keySize)
+		if err != nil {
+			return nil, fmt.Errorf("could not derive key from master key '%s': %w", id, err)
+		}
+
+		keyring.keys[id] = kek
+
+		if keyring.currentKeyID == "" {
+			keyring.currentKeyID = id
+		}
+	}
+
+	return keyring, nil
+}
+
+// CurrentKeyID returns the ID of the master key new player states get sealed with.
+func (k *Keyring) CurrentKeyID() string {
+	return k.currentKeyID
+}
+
+// seal returns the representation of the given player states to be stored under the given hashed user ID.
+// Without a keyring the player states are stored in plaintext.
+func (k *Keyring) seal(hashedUserID string, playerStates ...*PlayerState) ([]interface{}, error) {
+	sealed := make([]interface{}, len(playerStates))
+
+	for i, playerState := range playerStates {
+		if k == nil {
+			sealed[i] = playerState
+			continue
+		}
+
+		plaintext, err := bson.Marshal(playerState)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode player state: %w", err)
+		}
+
+		dataKey := make([]byte, keySize)
+		if _, err := rand.Read(dataKey); err != nil {
+			return nil, fmt.Errorf("could not generate data key: %w", err)
+		}
+
+		data, err := encrypt(dataKey, plaintext, additionalDataOf(hashedUserID, playerState.ID))
+		if err == nil {
+			dataKey, err = encrypt(k.keys[k.currentKeyID], dataKey, []byte(k.currentKeyID))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not encrypt player state: %w", err)
+		}
+
+		doc := bson.D{{Key: "id", Value: playerState.ID}}
+		if playerState.RemovedAtTs != 0 {
+			doc = append(doc, bson.E{Key: "removedAtTs", Value: playerState.RemovedAtTs})
+		}
+		doc = append(doc, bson.E{Key: "sealed", Value: bson.D{
+			{Key: "keyID", Value: k.currentKeyID},
+			{Key: "key", Value: dataKey},
+			{Key: "data", Value: data},
+			{Key: "userBound", Value: true},
+		}})
+
+		sealed[i] = doc
+	}
+
+	return sealed, nil
+}
+
+// sealDocument returns a copy of the given document with all its player states sealed.
+func (k *Keyring) sealDocument(doc document) (document, error) {
+	if k == nil {
+		return doc, nil
+	}
+
+	sealedDoc := make(document, len(doc))
+	for key, value := range doc {
+		sealedDoc[key] = value
+	}
+
+	for _, key := range []string{"playerStates", "trash"} {
+		entries, ok := doc[key].([]interface{})
+		if !ok {
+			continue
+		}
+
+		playerStates := make([]*PlayerState, len(entries))
+		for i, entry := range entries {
+			playerState, err := decodePlayerState(entry)
+			if err != nil {
+				return nil, fmt.Errorf("could not seal entry %d of '%s': %w", i, key, err)
+			}
+
+			if removedAtTs, ok := intValue(entry.(document)["removedAtTs"]); ok {
+				playerState.RemovedAtTs = removedAtTs
+			}
+
+			playerStates[i] = playerState
+		}
+
+		sealed, err := k.seal(hashedUserIDOf(doc), playerStates...)
+		if err != nil {
+			return nil, err
+		}
+
+		sealedDoc[key] = sealed
+	}
+
+	return sealedDoc, nil
+}
+
+// openDocument replaces the sealed player states of the given document with their plaintext in place.
+// Player states stored in plaintext are left as they are.
+func (k *Keyring) openDocument(doc document) error {
+	for _, key := range []string{"playerStates", "trash"} {
+		entries, ok := doc[key].([]interface{})
+		if !ok {
+			continue
+		}
+
+		for i, entry := range entries {
+			opened, err := k.open(hashedUserIDOf(doc), entry)
+			if err != nil {
+				return fmt.Errorf("could not open entry %d of '%s': %w", i, key, err)
+			}
+
+			entries[i] = opened
+		}
+	}
+
+	return nil
+}
+
+func (k *Keyring) open(hashedUserID string, entry interface{}) (interface{}, error) {
+	sealed, ok := sealedPart(entry)
+	if !ok {
+		return entry, nil
+	}
+
+	if k == nil {
+		return nil, ErrNoMasterKey
+	}
+
+	keyID, _ := sealed["keyID"].(string)
+	kek, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("sealed with unknown master key '%s'", keyID)
+	}
+
+	id, _ := entry.(document)["id"].(string)
+
+	dataKey, err := decrypt(kek, binaryValue(sealed["key"]), []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt data key: %w", err)
+	}
+
+	additionalData := additionalDataOf(hashedUserID, id)
+	if !isBoundToUser(entry) {
+		// Sealed before binding states to their user as well, ReencryptDocuments takes care of them
+		additionalData = []byte(id)
+	}
+
+	plaintext, err := decrypt(dataKey, binaryValue(sealed["data"]), additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt player state: %w", err)
+	}
+
+	var raw bson.M
+	if err := bson.Unmarshal(plaintext, &raw); err != nil {
+		return nil, fmt.Errorf("could not decode player state: %w", err)
+	}
+
+	return newDocument(raw), nil
+}
+
+// keyIDOf returns the ID of the master key the given stored player state has been sealed with,
+// plaintextKeyID in case it is not sealed.
+func keyIDOf(entry interface{}) string {
+	sealed, ok := sealedPart(entry)
+	if !ok {
+		return plaintextKeyID
+	}
+
+	keyID, _ := sealed["keyID"].(string)
+
+	return keyID
+}
+
+// isBoundToUser reports whether the given stored player state has been sealed along with the ID of its user,
+// states stored in plaintext are not.
+func isBoundToUser(entry interface{}) bool {
+	sealed, ok := sealedPart(entry)
+	if !ok {
+		return false
+	}
+
+	bound, _ := sealed["userBound"].(bool)
+
+	return bound
+}
+
+// additionalDataOf returns what a sealed player state is bound to: the user and the slot it is stored for.
+// Neither of the IDs contains a NUL byte, hence the result is unambiguous.
+func additionalDataOf(hashedUserID, slotID string) []byte {
+	return []byte(hashedUserID + "\x00" + slotID)
+}
+
+func hashedUserIDOf(doc document) string {
+	hashedUserID, _ := doc["_id"].(string)
+
+	return hashedUserID
+}
+
+func sealedPart(entry interface{}) (document, bool) {
+	doc, ok := entry.(document)
+	if !ok {
+		return nil, false
+	}
+
+	sealed, ok := doc["sealed"].(document)
+
+	return sealed, ok
+}
+
+func binaryValue(value interface{}) []byte {
+	switch v := value.(type) {
+	case primitive.Binary:
+		return v.Data
+	case []byte:
+		return v
+	default:
+		return nil
+	}
+}
+
+// encrypt seals the plaintext using AES-GCM, the random nonce is prepended to the ciphertext.
+func encrypt(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func decrypt(key, ciphertext, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+
+	return aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], additionalData)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// Reencryptor is implemented by backends encrypting the stored player states.
+type Reencryptor interface {
+	// KeyUsage counts the stored player states per ID of the master key they are sealed with.
+	// Player states stored in plaintext are counted for an empty ID.
+	KeyUsage(ctx context.Context) (map[string]int64, error)
+	// ReencryptDocuments seals all player states not sealed with the current master key or not bound to their
+	// user yet with the former and reports how many documents have been changed.
+	ReencryptDocuments(ctx context.Context) (int, error)
+}
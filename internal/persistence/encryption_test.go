@@ -0,0 +1,232 @@
+package persistence
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func masterKey(fill byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{fill}, keySize))
+}
+
+func mustNewKeyring(t *testing.T, masterKeys string) *Keyring {
+	t.Helper()
+
+	keyring, err := NewKeyring(masterKeys)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	return keyring
+}
+
+// storedDocument seals the given player states and round trips them through BSON like MongoDB would.
+func storedDocument(t *testing.T, keyring *Keyring, playerStates ...*PlayerState) document {
+	t.Helper()
+
+	sealed, err := keyring.seal("ABC", playerStates...)
+	if err != nil {
+		t.Fatalf("could not seal player states: %s", err)
+	}
+
+	return roundTrippedDocument(t, bson.M{"_id": "ABC", "playerStates": sealed})
+}
+
+func roundTrippedDocument(t *testing.T, doc bson.M) document {
+	t.Helper()
+
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatalf("could not encode document: %s", err)
+	}
+
+	var stored bson.M
+	if err := bson.Unmarshal(raw, &stored); err != nil {
+		t.Fatalf("could not decode document: %s", err)
+	}
+
+	return newDocument(stored)
+}
+
+func openedPlayerStates(t *testing.T, keyring *Keyring, doc document) []*PlayerState {
+	t.Helper()
+
+	if err := keyring.openDocument(doc); err != nil {
+		t.Fatalf("could not open document: %s", err)
+	}
+
+	var playerStates []*PlayerState
+	for _, entry := range doc["playerStates"].([]interface{}) {
+		playerState, err := decodePlayerState(entry)
+		if err != nil {
+			t.Fatalf("could not decode player state: %s", err)
+		}
+		playerStates = append(playerStates, playerState)
+	}
+
+	return playerStates
+}
+
+func TestNewKeyringRejectsInvalidKeys(t *testing.T) {
+	for name, masterKeys := range map[string]string{
+		"missing ID":    ":" + masterKey(1),
+		"missing colon": masterKey(1),
+		"not base64":    "a:not base64!",
+		"too short":     "a:" + base64.StdEncoding.EncodeToString([]byte("short")),
+		"duplicate ID":  "a:" + masterKey(1) + ",a:" + masterKey(2),
+	} {
+		if _, err := NewKeyring(masterKeys); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestSealedPlayerStatesCanBeOpened(t *testing.T) {
+	keyring := mustNewKeyring(t, "a:"+masterKey(1))
+	playerState := &PlayerState{
+		ID:                 "slot",
+		PlaybackContextURI: "spotify:album:1",
+		PlaybackItemURI:    "spotify:track:1",
+		AlbumName:          "book 1",
+		Progress:           1000,
+	}
+
+	doc := storedDocument(t, keyring, playerState)
+
+	entry := doc["playerStates"].([]interface{})[0].(document)
+	if _, ok := entry["albumName"]; ok {
+		t.Fatal("expected the player state to be sealed")
+	}
+	if keyIDOf(entry) != "a" {
+		t.Fatalf("expected the player state to be sealed with key 'a', got '%s'", keyIDOf(entry))
+	}
+
+	assertStates(t, openedPlayerStates(t, keyring, doc), []*PlayerState{playerState})
+}
+
+func TestRotatedKeysCanStillBeUsedForOpening(t *testing.T) {
+	playerState := &PlayerState{ID: "slot", PlaybackContextURI: "spotify:album:1", PlaybackItemURI: "spotify:track:1"}
+	doc := storedDocument(t, mustNewKeyring(t, "a:"+masterKey(1)), playerState)
+
+	rotated := mustNewKeyring(t, "b:"+masterKey(2)+",a:"+masterKey(1))
+	if rotated.CurrentKeyID() != "b" {
+		t.Fatalf("expected 'b' to be the current key, got '%s'", rotated.CurrentKeyID())
+	}
+
+	assertStates(t, openedPlayerStates(t, rotated, doc), []*PlayerState{playerState})
+
+	resealed, err := rotated.sealDocument(doc)
+	if err != nil {
+		t.Fatalf("could not seal document: %s", err)
+	}
+	if keyID := keyIDOf(normalizeValue(resealed["playerStates"].([]interface{})[0])); keyID != "b" {
+		t.Fatalf("expected the player state to be sealed with key 'b', got '%s'", keyID)
+	}
+}
+
+func TestOpeningFailsWithoutMatchingKey(t *testing.T) {
+	playerState := &PlayerState{ID: "slot", PlaybackContextURI: "spotify:album:1", PlaybackItemURI: "spotify:track:1"}
+	keyring := mustNewKeyring(t, "a:"+masterKey(1))
+
+	if err := (*Keyring)(nil).openDocument(storedDocument(t, keyring, playerState)); !errors.Is(err, ErrNoMasterKey) {
+		t.Errorf("expected ErrNoMasterKey, got %v", err)
+	}
+
+	if err := mustNewKeyring(t, "b:"+masterKey(2)).openDocument(storedDocument(t, keyring, playerState)); err == nil {
+		t.Error("expected an error for an unknown master key")
+	}
+
+	if err := mustNewKeyring(t, "a:"+masterKey(2)).openDocument(storedDocument(t, keyring, playerState)); err == nil {
+		t.Error("expected an error for a different master key with the same ID")
+	}
+}
+
+func TestOpeningFailsForTamperedPlayerStates(t *testing.T) {
+	keyring := mustNewKeyring(t, "a:"+masterKey(1))
+	playerState := &PlayerState{ID: "slot", PlaybackContextURI: "spotify:album:1", PlaybackItemURI: "spotify:track:1"}
+
+	doc := storedDocument(t, keyring, playerState)
+	sealed, _ := sealedPart(doc["playerStates"].([]interface{})[0])
+	data := binaryValue(sealed["data"])
+	data[len(data)-1] ^= 1
+	if err := keyring.openDocument(doc); err == nil {
+		t.Error("expected an error for tampered data")
+	}
+
+	// Sealed states are bound to their slot
+	doc = storedDocument(t, keyring, playerState)
+	doc["playerStates"].([]interface{})[0].(document)["id"] = "other slot"
+	if err := keyring.openDocument(doc); err == nil {
+		t.Error("expected an error for a player state moved to another slot")
+	}
+
+	// ... and to their user
+	doc = storedDocument(t, keyring, playerState)
+	doc["_id"] = "DEF"
+	if err := keyring.openDocument(doc); err == nil {
+		t.Error("expected an error for a player state moved to another user")
+	}
+}
+
+func TestPlayerStatesSealedBeforeBindingThemToTheirUserCanBeOpened(t *testing.T) {
+	keyring := mustNewKeyring(t, "a:"+masterKey(1))
+	playerState := &PlayerState{ID: "slot", PlaybackContextURI: "spotify:album:1", PlaybackItemURI: "spotify:track:1"}
+
+	// Sealed the way it has been done before, bound to the slot only
+	plaintext, err := bson.Marshal(playerState)
+	if err != nil {
+		t.Fatalf("could not encode player state: %s", err)
+	}
+	dataKey := bytes.Repeat([]byte{3}, keySize)
+	data, err := encrypt(dataKey, plaintext, []byte(playerState.ID))
+	if err != nil {
+		t.Fatalf("could not encrypt player state: %s", err)
+	}
+	sealedDataKey, err := encrypt(keyring.keys["a"], dataKey, []byte("a"))
+	if err != nil {
+		t.Fatalf("could not encrypt data key: %s", err)
+	}
+
+	doc := roundTrippedDocument(t, bson.M{"_id": "ABC", "playerStates": bson.A{bson.D{
+		{Key: "id", Value: playerState.ID},
+		{Key: "sealed", Value: bson.D{{Key: "keyID", Value: "a"}, {Key: "key", Value: sealedDataKey}, {Key: "data", Value: data}}},
+	}}})
+	if isBoundToUser(doc["playerStates"].([]interface{})[0]) {
+		t.Fatal("expected the player state not to be bound to its user")
+	}
+
+	assertStates(t, openedPlayerStates(t, keyring, doc), []*PlayerState{playerState})
+
+	resealed, err := keyring.sealDocument(doc)
+	if err != nil {
+		t.Fatalf("could not seal document: %s", err)
+	}
+	if !isBoundToUser(normalizeValue(resealed["playerStates"].([]interface{})[0])) {
+		t.Fatal("expected the sealed player state to be bound to its user")
+	}
+}
+
+func TestPlaintextPlayerStatesAreLeftAsTheyAre(t *testing.T) {
+	playerState := &PlayerState{ID: "slot", PlaybackContextURI: "spotify:album:1", PlaybackItemURI: "spotify:track:1"}
+
+	doc := storedDocument(t, nil, playerState)
+	if keyIDOf(doc["playerStates"].([]interface{})[0]) != plaintextKeyID {
+		t.Fatal("expected the player state to be stored in plaintext")
+	}
+
+	assertStates(t, openedPlayerStates(t, mustNewKeyring(t, "a:"+masterKey(1)), doc), []*PlayerState{playerState})
+}
+
+func TestConnectRejectsKeyringForBackendsWithoutEncryption(t *testing.T) {
+	keyring := mustNewKeyring(t, "a:"+masterKey(1))
+
+	for _, uri := range []string{"memory://", "sqlite://" + t.TempDir() + "/cassette.db"} {
+		if _, err := Connect(uri, Options{Keyring: keyring}); err == nil {
+			t.Errorf("expected an error for '%s'", uri)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+package persistence
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// MemoryPersistor implements PlayerStatesPersistor by keeping all records in memory.
+// It is meant for local development and testing, everything is lost once the process exits.
+// Its behaviour mirrors the one of PlayerStatesDAO.
+type MemoryPersistor struct {
+	mutex   sync.Mutex
+	records map[string]*persistenceItem
+	userIDs *UserIDHasher
+}
+
+func NewMemoryPersistor() *MemoryPersistor {
+	return &MemoryPersistor{
+		records: make(map[string]*persistenceItem),
+	}
+}
+
+func (p *MemoryPersistor) LoadPlayerStates(_ context.Context, userID string) ([]*PlayerState, int64, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	item, ok := p.records[p.hashedUserID(userID)]
+	if !ok {
+		return make([]*PlayerState, 0), 0, nil
+	}
+
+	if time.Since(time.Unix(item.LastActivityTs, 0)) > activityResolution {
+		item.LastActivityTs = time.Now().Unix()
+	}
+
+	return copyPlayerStates(item.PlayerStates), item.Revision, nil
+}
+
+func (p *MemoryPersistor) SavePlayerStates(_ context.Context, userID string, playerStates []*PlayerState) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	item := p.recordOf(userID)
+	item.PlayerStates = withSlotIDs(playerStates)
+	markModified(item)
+
+	return nil
+}
+
+func (p *MemoryPersistor) AppendState(_ context.Context, userID string, playerState *PlayerState) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	item := p.recordOf(userID)
+	item.PlayerStates = append(item.PlayerStates, withSlotIDs([]*PlayerState{playerState})...)
+	markModified(item)
+
+	return nil
+}
+
+func (p *MemoryPersistor) ReplaceState(_ context.Context, userID string, revision int64, slot int, playerState *PlayerState) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	item, err := p.checkedRecordOf(userID, revision, slot)
+	if err != nil {
+		return err
+	}
+
+	item.PlayerStates[slot] = replacementOf(item.PlayerStates[slot], playerState)
+	markModified(item)
+
+	return nil
+}
+
+func (p *MemoryPersistor) RemoveState(_ context.Context, userID string, revision int64, slot int) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	item, err := p.checkedRecordOf(userID, revision, slot)
+	if err != nil {
+		return err
+	}
+
+	item.Trash = append([]*PlayerState{trashed(item.PlayerStates[slot])}, item.Trash...)
+	item.PlayerStates = removeSlot(item.PlayerStates, slot)
+	markModified(item)
+
+	return nil
+}
+
+func (p *MemoryPersistor) MoveState(_ context.Context, userID string, revision int64, from, to int) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	item, err := p.checkedRecordOf(userID, revision, from)
+	if err != nil {
+		return err
+	}
+
+	playerStates, err := moveSlot(item.PlayerStates, from, to)
+	if err != nil {
+		return err
+	}
+
+	item.PlayerStates = playerStates
+	markModified(item)
+
+	return nil
+}
+
+func (p *MemoryPersistor) SetPinned(_ context.Context, userID string, revision int64, slot int, pinned bool) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	item, err := p.checkedRecordOf(userID, revision, slot)
+	if err != nil {
+		return err
+	}
+
+	item.PlayerStates[slot] = withPinned(item.PlayerStates[slot], pinned)
+	markModified(item)
+
+	return nil
+}
+
+func (p *MemoryPersistor) LoadTrash(_ context.Context, userID string) ([]*PlayerState, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	item, ok := p.records[p.hashedUserID(userID)]
+	if !ok || item.Trash == nil {
+		return make([]*PlayerState, 0), nil
+	}
+
+	return copyPlayerStates(item.Trash), nil
+}
+
+func (p *MemoryPersistor) RestoreFromTrash(_ context.Context, userID string, slotID string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	item, ok := p.records[p.hashedUserID(userID)]
+	if !ok {
+		return ErrNotInTrash
+	}
+
+	index := trashIndexOf(item.Trash, slotID)
+	if index == -1 {
+		return ErrNotInTrash
+	}
+
+	item.PlayerStates = append(item.PlayerStates, restored(item.Trash[index]))
+	item.Trash = removeSlot(item.Trash, index)
+	markModified(item)
+
+	return nil
+}
+
+func (p *MemoryPersistor) PurgeTrash(_ context.Context, removedBefore time.Time) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, item := range p.records {
+		kept := make([]*PlayerState, 0, len(item.Trash))
+		for _, playerState := range item.Trash {
+			if playerState.RemovedAtTs >= removedBefore.Unix() {
+				kept = append(kept, playerState)
+			}
+		}
+
+		item.Trash = kept
+	}
+
+	return nil
+}
+
+func (p *MemoryPersistor) CountInactiveUsers(_ context.Context, inactiveSince time.Time) (int64, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var count int64
+	for _, item := range p.records {
+		if item.LastActivityTs < inactiveSince.Unix() {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (p *MemoryPersistor) PurgeInactiveUsers(_ context.Context, inactiveSince time.Time) (int64, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var purged int64
+	for hashedUserID, item := range p.records {
+		if item.LastActivityTs < inactiveSince.Unix() {
+			delete(p.records, hashedUserID)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+func (p *MemoryPersistor) FetchJSONDump(_ context.Context, userID string) ([]byte, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	item, ok := p.records[p.hashedUserID(userID)]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+
+	return marshalDump(item)
+}
+
+func (p *MemoryPersistor) DeleteUserRecord(_ context.Context, userID string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// A record might still be stored under the legacy hash as well
+	found := false
+	for _, hashedUserID := range p.userIDs.candidates(userID) {
+		if _, ok := p.records[hashedUserID]; ok {
+			found = true
+			delete(p.records, hashedUserID)
+		}
+	}
+
+	if !found {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (p *MemoryPersistor) LegacyUserIDs(_ context.Context) (int64, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var count int64
+	for hashedUserID := range p.records {
+		if isLegacyUserID(hashedUserID) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (p *MemoryPersistor) RekeyUserIDs(_ context.Context) (int, error) {
+	if p.userIDs == nil {
+		return 0, ErrNoPepper
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	rekeyed := 0
+
+	for hashedUserID, item := range p.records {
+		if !isLegacyUserID(hashedUserID) {
+			continue
+		}
+
+		pepperedID := p.userIDs.rekeyed(hashedUserID)
+		if _, ok := p.records[pepperedID]; ok {
+			log.Warn().Str("legacyID", hashedUserID).Msg("There already is a record stored under the peppered user ID, leaving the legacy one in place.")
+			continue
+		}
+
+		item.UserID = pepperedID
+		p.records[pepperedID] = item
+		delete(p.records, hashedUserID)
+		rekeyed++
+	}
+
+	return rekeyed, nil
+}
+
+// recordOf returns the record of the given user, creating it if necessary. The caller has to hold the lock.
+func (p *MemoryPersistor) recordOf(userID string) *persistenceItem {
+	hashedUserID := p.hashedUserID(userID)
+
+	item, ok := p.records[hashedUserID]
+	if !ok {
+		item = &persistenceItem{UserID: hashedUserID}
+		p.records[hashedUserID] = item
+	}
+
+	item.Version = currentVersion
+
+	return item
+}
+
+// hashedUserID returns the ID the record of the given user is stored under. The caller has to hold the lock.
+func (p *MemoryPersistor) hashedUserID(userID string) string {
+	// Looking up records in memory does not fail
+	hashedUserID, _ := p.userIDs.resolve(context.Background(), userID, func(_ context.Context, hashedUserID string) (bool, error) {
+		_, ok := p.records[hashedUserID]
+		return ok, nil
+	})
+
+	return hashedUserID
+}
+
+// checkedRecordOf returns the record of the given user after ensuring it is at the expected revision and
+// contains the given slot. The caller has to hold the lock.
+func (p *MemoryPersistor) checkedRecordOf(userID string, revision int64, slot int) (*persistenceItem, error) {
+	item, ok := p.records[p.hashedUserID(userID)]
+	if !ok {
+		item = &persistenceItem{}
+	}
+
+	if err := checkSlot(item, revision, slot); err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
+
+// markModified increments the revision of the given record and records the activity of its user.
+func markModified(item *persistenceItem) {
+	item.Revision++
+	item.LastActivityTs = time.Now().Unix()
+}
+
+// copyPlayerStates makes sure callers never share memory with the stored records,
+// just like they would not when reading from/writing to a database.
+// A nil slice stays nil, as it would when round-tripping through MongoDB.
+func copyPlayerStates(playerStates []*PlayerState) []*PlayerState {
+	if playerStates == nil {
+		return nil
+	}
+
+	copied := make([]*PlayerState, len(playerStates))
+	for i, playerState := range playerStates {
+		stateCopy := *playerState
+		stateCopy.History = copyPlayerStates(playerState.History)
+		copied[i] = &stateCopy
+	}
+
+	return copied
+}
@@ -0,0 +1,9 @@
+package persistence
+
+import "testing"
+
+func TestMemoryPersistorConformance(t *testing.T) {
+	runConformanceSuite(t, func(t *testing.T) PlayerStatesPersistor {
+		return NewMemoryPersistor()
+	})
+}
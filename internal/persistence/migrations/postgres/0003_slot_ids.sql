@@ -0,0 +1,4 @@
+-- Stable identifier of a slot, existing slots get a random one
+ALTER TABLE player_states ADD COLUMN id TEXT NOT NULL DEFAULT '';
+
+UPDATE player_states SET id = replace(gen_random_uuid()::text, '-', '');
@@ -0,0 +1,27 @@
+-- Removed slots, their history stays in slot_history until they get purged
+CREATE TABLE trash (
+    user_id              TEXT    NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    position             INTEGER NOT NULL, -- order of removal, the most recently removed slot has the highest one
+    id                   TEXT    NOT NULL, -- ID of the slot
+    playback_context_uri TEXT    NOT NULL,
+    playback_item_uri    TEXT    NOT NULL,
+    link_to_context      TEXT    NOT NULL,
+    context_type         TEXT    NOT NULL,
+    playlist_name        TEXT    NOT NULL,
+    album_art_large_url  TEXT    NOT NULL,
+    album_art_medium_url TEXT    NOT NULL,
+    track_name           TEXT    NOT NULL,
+    album_name           TEXT    NOT NULL,
+    artist_name          TEXT    NOT NULL,
+    track_index          INTEGER NOT NULL,
+    total_tracks         INTEGER NOT NULL,
+    progress             INTEGER NOT NULL,
+    duration             INTEGER NOT NULL,
+    shuffle_activated    BOOLEAN NOT NULL,
+    suspended_at_ts      BIGINT  NOT NULL,
+    removed_at_ts        BIGINT  NOT NULL,
+    PRIMARY KEY (user_id, id)
+);
+
+-- Purging looks up expired slots of all users
+CREATE INDEX trash_removed_at_ts ON trash (removed_at_ts);
@@ -0,0 +1,7 @@
+-- Unix timestamp of the last activity of the user, existing users are considered active as of now
+ALTER TABLE users ADD COLUMN last_activity_ts BIGINT NOT NULL DEFAULT 0;
+
+UPDATE users SET last_activity_ts = EXTRACT(EPOCH FROM now())::BIGINT;
+
+-- Purging looks up inactive users
+CREATE INDEX users_last_activity_ts ON users (last_activity_ts);
@@ -0,0 +1,4 @@
+-- Audiobooks are read by narrators, other player states do not have any
+ALTER TABLE player_states ADD COLUMN narrator_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE slot_history ADD COLUMN narrator_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE trash ADD COLUMN narrator_name TEXT NOT NULL DEFAULT '';
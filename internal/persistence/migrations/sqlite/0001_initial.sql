@@ -0,0 +1,26 @@
+CREATE TABLE users (
+    id      TEXT    NOT NULL PRIMARY KEY, -- hashed Spotify user ID
+    version INTEGER NOT NULL
+);
+
+CREATE TABLE player_states (
+    user_id              TEXT    NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    position             INTEGER NOT NULL, -- index of the slot, starting at 0
+    playback_context_uri TEXT    NOT NULL,
+    playback_item_uri    TEXT    NOT NULL,
+    link_to_context      TEXT    NOT NULL,
+    context_type         TEXT    NOT NULL,
+    playlist_name        TEXT    NOT NULL,
+    album_art_large_url  TEXT    NOT NULL,
+    album_art_medium_url TEXT    NOT NULL,
+    track_name           TEXT    NOT NULL,
+    album_name           TEXT    NOT NULL,
+    artist_name          TEXT    NOT NULL,
+    track_index          INTEGER NOT NULL,
+    total_tracks         INTEGER NOT NULL,
+    progress             INTEGER NOT NULL,
+    duration             INTEGER NOT NULL,
+    shuffle_activated    BOOLEAN NOT NULL,
+    suspended_at_ts      INTEGER NOT NULL,
+    PRIMARY KEY (user_id, position)
+);
@@ -0,0 +1,2 @@
+-- Every modification of a user's player states increments the revision, used for optimistic locking
+ALTER TABLE users ADD COLUMN revision INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,4 @@
+-- Pinned slots never get evicted in order to make room for new ones
+ALTER TABLE player_states ADD COLUMN pinned BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE slot_history ADD COLUMN pinned BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE trash ADD COLUMN pinned BOOLEAN NOT NULL DEFAULT false;
@@ -1,17 +1,19 @@
 package persistence
 
 import (
-	"crypto/sha256"
-	"encoding/json"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/url"
 	"strings"
+	"time"
 
 	"context"
 
 	"github.com/rs/zerolog/log"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
@@ -19,25 +21,107 @@ import (
 
 const (
 	collectionName = "player_states"
-	currentVersion = 3
+	currentVersion = 6
+	// Number of previous states kept per slot
+	historyLength = 10
+	// Reading player states only updates the last activity of a user in case it is older than this
+	activityResolution = 24 * time.Hour
 )
 
 var (
 	ErrUserNotFound = errors.New("user not found in db")
+	ErrSlotNotFound = errors.New("slot not found")
+	ErrConflict     = errors.New("player states have been modified concurrently")
+	ErrNotInTrash   = errors.New("player state not found in trash")
 )
 
+// PlayerStatesPersistor stores the player states of users. Every modification of a user's record
+// increments its revision. The per-slot operations only succeed in case the given revision is still
+// the current one, otherwise they fail with ErrConflict instead of overwriting concurrent changes.
+// Slots are addressed by their index, operating on a not existing slot results in ErrSlotNotFound.
+// Every slot has a stable ID which gets assigned when storing a player state without one. Replacing
+// a slot keeps its ID and adds the replaced state to the slot's history.
+// Removed slots are moved to the user's trash, from where they can be restored until they get purged.
+// Every modification and, at most once per activityResolution, loading the player states counts as activity of
+// the user. The records of users being inactive for too long can be purged.
 type PlayerStatesPersistor interface {
-	LoadPlayerStates(userID string) ([]*PlayerState, error)
-	SavePlayerStates(userID string, playerStates []*PlayerState) error
-	FetchJSONDump(userID string) ([]byte, error)
-	DeleteUserRecord(userID string) error
+	// LoadPlayerStates returns the player states of a user along with the revision of the record.
+	// For unknown users there are no states and the revision is 0.
+	LoadPlayerStates(ctx context.Context, userID string) ([]*PlayerState, int64, error)
+	SavePlayerStates(ctx context.Context, userID string, playerStates []*PlayerState) error
+	FetchJSONDump(ctx context.Context, userID string) ([]byte, error)
+	DeleteUserRecord(ctx context.Context, userID string) error
+	// AppendState adds a new slot. As appending does not conflict with other changes no revision is required.
+	AppendState(ctx context.Context, userID string, playerState *PlayerState) error
+	ReplaceState(ctx context.Context, userID string, revision int64, slot int, playerState *PlayerState) error
+	// RemoveState moves the slot to the trash, the following slots shift by one.
+	RemoveState(ctx context.Context, userID string, revision int64, slot int) error
+	// MoveState moves the slot 'from' to index 'to', the slots in between shift by one.
+	MoveState(ctx context.Context, userID string, revision int64, from, to int) error
+	// SetPinned pins or unpins the slot, pinned slots never get evicted in order to make room for new ones.
+	SetPinned(ctx context.Context, userID string, revision int64, slot int, pinned bool) error
+	// LoadTrash returns the removed slots of a user, the most recently removed one first.
+	LoadTrash(ctx context.Context, userID string) ([]*PlayerState, error)
+	// RestoreFromTrash appends the removed slot with the given ID as new slot, keeping its ID and history.
+	// In case there is no such slot in the trash it fails with ErrNotInTrash.
+	RestoreFromTrash(ctx context.Context, userID string, slotID string) error
+	// PurgeTrash permanently deletes the slots of all users which have been removed before the given point in time.
+	PurgeTrash(ctx context.Context, removedBefore time.Time) error
+	// CountInactiveUsers counts the records of users without any activity since the given point in time.
+	CountInactiveUsers(ctx context.Context, inactiveSince time.Time) (int64, error)
+	// PurgeInactiveUsers deletes the records of all users without any activity since the given point in time,
+	// including their trash, and reports how many got deleted.
+	PurgeInactiveUsers(ctx context.Context, inactiveSince time.Time) (int64, error)
 }
 
 type PlayerStatesDAO struct {
 	collection *mongo.Collection
+	// seals the player states before storing them, nil in case they are stored in plaintext
+	keyring *Keyring
+	userIDs *UserIDHasher
 }
 
-func Connect(connectionString string) (*PlayerStatesDAO, error) {
+// Options configures how the records get stored, the zero value stores them under the legacy hash of the
+// user ID and in plaintext.
+type Options struct {
+	// Encrypts the player states at rest, only supported by MongoDB
+	Keyring *Keyring
+	UserIDs *UserIDHasher
+}
+
+// Connect returns the PlayerStatesPersistor matching the scheme of the given connection string.
+// "mongodb://" and "mongodb+srv://" connect to MongoDB, "postgres://" and "postgresql://" to PostgreSQL,
+// "sqlite://path/to/file.db" opens (or creates) an SQLite database and "memory://" keeps everything in memory.
+func Connect(connectionString string, opts Options) (PlayerStatesPersistor, error) {
+	u, err := url.Parse(connectionString)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse given connection string '%s': %w", connectionString, err)
+	}
+
+	if opts.Keyring != nil && u.Scheme != "mongodb" && u.Scheme != "mongodb+srv" {
+		return nil, fmt.Errorf("encryption at rest is not supported by persistence backend '%s'", u.Scheme)
+	}
+
+	switch u.Scheme {
+	case "mongodb", "mongodb+srv":
+		return connectMongo(connectionString, opts)
+	case "postgres", "postgresql":
+		return connectPostgres(connectionString, opts.UserIDs)
+	case "sqlite":
+		return connectSQLite(connectionString, opts.UserIDs)
+	case "memory":
+		log.Warn().Msg("Using in-memory backend! All data will be lost once the process exits.")
+
+		p := NewMemoryPersistor()
+		p.userIDs = opts.UserIDs
+
+		return p, nil
+	default:
+		return nil, fmt.Errorf("unsupported persistence backend '%s'", u.Scheme)
+	}
+}
+
+func connectMongo(connectionString string, opts Options) (*PlayerStatesDAO, error) {
 	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(connectionString))
 	if err == nil {
 		err = client.Ping(context.Background(), readpref.Primary())
@@ -59,33 +143,62 @@ func Connect(connectionString string) (*PlayerStatesDAO, error) {
 
 	log.Info().Msgf("Connected to mongo db backend! Will use '%s' as db.", dbName)
 
-	collection := client.Database(dbName).Collection(collectionName)
+	dao := &PlayerStatesDAO{client.Database(dbName).Collection(collectionName), opts.Keyring, opts.UserIDs}
 
-	return &PlayerStatesDAO{collection}, nil
+	// Refuse to work on documents we do not understand, otherwise we would silently drop their new fields
+	versions, err := dao.DocumentVersions(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	for version, count := range versions {
+		if version > currentVersion {
+			return nil, fmt.Errorf("%w: %d document(s) have version %d, supported up to %d", ErrDocumentTooNew, count, version, currentVersion)
+		}
+	}
+
+	return dao, nil
 }
 
-func (p *PlayerStatesDAO) LoadPlayerStates(userID string) ([]*PlayerState, error) {
-	hashedUserID := hashUserID(userID)
+func (p *PlayerStatesDAO) LoadPlayerStates(ctx context.Context, userID string) ([]*PlayerState, int64, error) {
+	hashedUserID, err := p.hashedUserID(ctx, userID)
+	if err != nil {
+		return nil, 0, err
+	}
 
-	var item persistenceItem
-	err := p.collection.FindOne(context.TODO(), bson.D{{Key: "_id", Value: hashedUserID}}).Decode(&item)
+	item, err := p.findItem(ctx, hashedUserID)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return make([]*PlayerState, 0), nil
+			return make([]*PlayerState, 0), 0, nil
 		}
 
-		return nil, err
+		return nil, 0, err
+	}
+
+	if time.Since(time.Unix(item.LastActivityTs, 0)) > activityResolution {
+		// Failing to record the activity is no reason to fail loading, it will be recorded next time
+		_, err := p.collection.UpdateOne(ctx, bson.D{{Key: "_id", Value: hashedUserID}}, bson.D{{Key: "$set", Value: bson.D{{Key: "lastActivityTs", Value: time.Now().Unix()}}}})
+		if err != nil {
+			log.Warn().Err(err).Msg("Could not record activity of user.")
+		}
 	}
 
-	return item.PlayerStates, nil
+	return item.PlayerStates, item.Revision, nil
 }
 
-func (p *PlayerStatesDAO) SavePlayerStates(userID string, playerStates []*PlayerState) error {
-	hashedUserID := hashUserID(userID)
+func (p *PlayerStatesDAO) SavePlayerStates(ctx context.Context, userID string, playerStates []*PlayerState) error {
+	hashedUserID, err := p.hashedUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	sealed, err := p.keyring.seal(hashedUserID, withSlotIDs(playerStates)...)
+	if err != nil {
+		return err
+	}
 
 	opts := options.Update().SetUpsert(true)
 
-	_, err := p.collection.UpdateOne(context.TODO(), bson.D{{Key: "_id", Value: hashedUserID}}, bson.D{{Key: "$set", Value: bson.D{{Key: "playerStates", Value: playerStates}, {Key: "version", Value: currentVersion}}}}, opts)
+	_, err = p.collection.UpdateOne(ctx, bson.D{{Key: "_id", Value: hashedUserID}}, bson.D{{Key: "$set", Value: bson.D{{Key: "playerStates", Value: sealed}, {Key: "version", Value: currentVersion}, {Key: "lastActivityTs", Value: time.Now().Unix()}}}, {Key: "$inc", Value: bson.D{{Key: "revision", Value: 1}}}}, opts)
 
 	if err != nil {
 		return err
@@ -94,31 +207,258 @@ func (p *PlayerStatesDAO) SavePlayerStates(userID string, playerStates []*Player
 	return nil
 }
 
-func (p *PlayerStatesDAO) FetchJSONDump(userID string) ([]byte, error) {
-	hashedUserID := hashUserID(userID)
+func (p *PlayerStatesDAO) AppendState(ctx context.Context, userID string, playerState *PlayerState) error {
+	hashedUserID, err := p.hashedUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	// Makes sure an outdated document gets upgraded before adding a state in the current format to it
+	if _, err := p.findItem(ctx, hashedUserID); err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+
+	sealed, err := p.keyring.seal(hashedUserID, withSlotIDs([]*PlayerState{playerState})...)
+	if err != nil {
+		return err
+	}
+
+	opts := options.Update().SetUpsert(true)
+
+	_, err = p.collection.UpdateOne(ctx, bson.D{{Key: "_id", Value: hashedUserID}}, bson.D{{Key: "$push", Value: bson.D{{Key: "playerStates", Value: sealed[0]}}}, {Key: "$set", Value: bson.D{{Key: "version", Value: currentVersion}, {Key: "lastActivityTs", Value: time.Now().Unix()}}}, {Key: "$inc", Value: bson.D{{Key: "revision", Value: 1}}}}, opts)
+	if err != nil {
+		return fmt.Errorf("could not append player state: %w", err)
+	}
+
+	return nil
+}
+
+func (p *PlayerStatesDAO) ReplaceState(ctx context.Context, userID string, revision int64, slot int, playerState *PlayerState) error {
+	hashedUserID, err := p.hashedUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	item, err := p.findCheckedItem(ctx, hashedUserID, revision, slot)
+	if err != nil {
+		return err
+	}
+
+	sealed, err := p.keyring.seal(hashedUserID, replacementOf(item.PlayerStates[slot], playerState))
+	if err != nil {
+		return err
+	}
+
+	slotKey := fmt.Sprintf("playerStates.%d", slot)
+
+	return p.updateRevision(ctx, hashedUserID, revision, bson.D{{Key: "$set", Value: bson.D{{Key: slotKey, Value: sealed[0]}}}})
+}
+
+func (p *PlayerStatesDAO) RemoveState(ctx context.Context, userID string, revision int64, slot int) error {
+	hashedUserID, err := p.hashedUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	item, err := p.findCheckedItem(ctx, hashedUserID, revision, slot)
+	if err != nil {
+		return err
+	}
+
+	// MongoDB does not support removing an array element by its index, therefore we replace the whole array.
+	// As the update is guarded by the revision this is atomic nevertheless.
+	playerStates, err := p.keyring.seal(hashedUserID, removeSlot(item.PlayerStates, slot)...)
+	if err != nil {
+		return err
+	}
+
+	removed, err := p.keyring.seal(hashedUserID, trashed(item.PlayerStates[slot]))
+	if err != nil {
+		return err
+	}
+
+	return p.updateRevision(ctx, hashedUserID, revision, bson.D{
+		{Key: "$set", Value: bson.D{{Key: "playerStates", Value: playerStates}}},
+		{Key: "$push", Value: bson.D{{Key: "trash", Value: bson.D{{Key: "$each", Value: removed}, {Key: "$position", Value: 0}}}}},
+	})
+}
+
+func (p *PlayerStatesDAO) MoveState(ctx context.Context, userID string, revision int64, from, to int) error {
+	hashedUserID, err := p.hashedUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	item, err := p.findCheckedItem(ctx, hashedUserID, revision, from)
+	if err != nil {
+		return err
+	}
+
+	moved, err := moveSlot(item.PlayerStates, from, to)
+	if err != nil {
+		return err
+	}
+
+	playerStates, err := p.keyring.seal(hashedUserID, moved...)
+	if err != nil {
+		return err
+	}
+
+	return p.updateRevision(ctx, hashedUserID, revision, bson.D{{Key: "$set", Value: bson.D{{Key: "playerStates", Value: playerStates}}}})
+}
+
+func (p *PlayerStatesDAO) SetPinned(ctx context.Context, userID string, revision int64, slot int, pinned bool) error {
+	hashedUserID, err := p.hashedUserID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	item, err := p.findCheckedItem(ctx, hashedUserID, revision, slot)
+	if err != nil {
+		return err
+	}
+
+	// The flag might be sealed along with the rest of the state, so the whole slot gets replaced
+	sealed, err := p.keyring.seal(hashedUserID, withPinned(item.PlayerStates[slot], pinned))
+	if err != nil {
+		return err
+	}
+
+	slotKey := fmt.Sprintf("playerStates.%d", slot)
+
+	return p.updateRevision(ctx, hashedUserID, revision, bson.D{{Key: "$set", Value: bson.D{{Key: slotKey, Value: sealed[0]}}}})
+}
+
+func (p *PlayerStatesDAO) LoadTrash(ctx context.Context, userID string) ([]*PlayerState, error) {
+	hashedUserID, err := p.hashedUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
 
-	var item persistenceItem
-	err := p.collection.FindOne(context.TODO(), bson.D{{Key: "_id", Value: hashedUserID}}).Decode(&item)
+	item, err := p.findItem(ctx, hashedUserID)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return nil, ErrUserNotFound
+			return make([]*PlayerState, 0), nil
 		}
 
-		return nil, fmt.Errorf("could not load previous player states from db: %w", err)
+		return nil, err
+	}
+
+	if item.Trash == nil {
+		return make([]*PlayerState, 0), nil
+	}
+
+	return item.Trash, nil
+}
+
+func (p *PlayerStatesDAO) RestoreFromTrash(ctx context.Context, userID string, slotID string) error {
+	hashedUserID, err := p.hashedUserID(ctx, userID)
+	if err != nil {
+		return err
 	}
 
-	json, err := json.Marshal(item)
+	item, err := p.findItem(ctx, hashedUserID)
 	if err != nil {
-		return nil, fmt.Errorf("could not convert record to JSON: %w", err)
+		if err == mongo.ErrNoDocuments {
+			return ErrNotInTrash
+		}
+
+		return err
+	}
+
+	index := trashIndexOf(item.Trash, slotID)
+	if index == -1 {
+		return ErrNotInTrash
+	}
+
+	sealed, err := p.keyring.seal(hashedUserID, restored(item.Trash[index]))
+	if err != nil {
+		return err
 	}
 
-	return json, nil
+	// Filtering on the trashed slot makes sure it does not get restored twice by concurrent requests
+	res, err := p.collection.UpdateOne(ctx, bson.D{{Key: "_id", Value: hashedUserID}, {Key: "trash.id", Value: slotID}}, bson.D{
+		{Key: "$pull", Value: bson.D{{Key: "trash", Value: bson.D{{Key: "id", Value: slotID}}}}},
+		{Key: "$push", Value: bson.D{{Key: "playerStates", Value: sealed[0]}}},
+		{Key: "$set", Value: bson.D{{Key: "lastActivityTs", Value: time.Now().Unix()}}},
+		{Key: "$inc", Value: bson.D{{Key: "revision", Value: 1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("could not restore player state from trash: %w", err)
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrNotInTrash
+	}
+
+	return nil
 }
 
-func (p *PlayerStatesDAO) DeleteUserRecord(userID string) error {
-	hashedUserID := hashUserID(userID)
+func (p *PlayerStatesDAO) PurgeTrash(ctx context.Context, removedBefore time.Time) error {
+	expired := bson.D{{Key: "$lt", Value: removedBefore.Unix()}}
+
+	_, err := p.collection.UpdateMany(ctx, bson.D{{Key: "trash.removedAtTs", Value: expired}}, bson.D{
+		{Key: "$pull", Value: bson.D{{Key: "trash", Value: bson.D{{Key: "removedAtTs", Value: expired}}}}},
+	})
+	if err != nil {
+		return fmt.Errorf("could not purge trash: %w", err)
+	}
+
+	return nil
+}
+
+func (p *PlayerStatesDAO) CountInactiveUsers(ctx context.Context, inactiveSince time.Time) (int64, error) {
+	count, err := p.collection.CountDocuments(ctx, inactiveFilter(inactiveSince))
+	if err != nil {
+		return 0, fmt.Errorf("could not count inactive users: %w", err)
+	}
+
+	return count, nil
+}
 
-	res, err := p.collection.DeleteOne(context.TODO(), bson.D{{Key: "_id", Value: hashedUserID}})
+// PurgeInactiveUsers deletes the documents of all inactive users. Documents written before the activity got
+// tracked and not upgraded yet are considered active as of now.
+func (p *PlayerStatesDAO) PurgeInactiveUsers(ctx context.Context, inactiveSince time.Time) (int64, error) {
+	_, err := p.collection.UpdateMany(ctx, bson.D{{Key: "lastActivityTs", Value: bson.D{{Key: "$exists", Value: false}}}}, bson.D{
+		{Key: "$set", Value: bson.D{{Key: "lastActivityTs", Value: time.Now().Unix()}}},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not start tracking activity of users: %w", err)
+	}
+
+	res, err := p.collection.DeleteMany(ctx, inactiveFilter(inactiveSince))
+	if err != nil {
+		return 0, fmt.Errorf("could not purge inactive users: %w", err)
+	}
+
+	return res.DeletedCount, nil
+}
+
+func inactiveFilter(inactiveSince time.Time) bson.D {
+	return bson.D{{Key: "lastActivityTs", Value: bson.D{{Key: "$lt", Value: inactiveSince.Unix()}}}}
+}
+
+func (p *PlayerStatesDAO) FetchJSONDump(ctx context.Context, userID string) ([]byte, error) {
+	hashedUserID, err := p.hashedUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := p.findItem(ctx, hashedUserID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrUserNotFound
+		}
+
+		return nil, fmt.Errorf("could not load previous player states from db: %w", err)
+	}
+
+	return marshalDump(item)
+}
+
+// DeleteUserRecord deletes every record of the user, including one still stored under the legacy hash.
+func (p *PlayerStatesDAO) DeleteUserRecord(ctx context.Context, userID string) error {
+	res, err := p.collection.DeleteMany(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: p.userIDs.candidates(userID)}}}})
 	if err != nil {
 		return fmt.Errorf("could not delete user record: %w", err)
 	}
@@ -130,32 +470,618 @@ func (p *PlayerStatesDAO) DeleteUserRecord(userID string) error {
 	return nil
 }
 
-func hashUserID(userID string) string {
-	hash := sha256.Sum256([]byte(userID))
-	return fmt.Sprintf("%X", hash)
+// DocumentVersions counts the stored documents per version.
+func (p *PlayerStatesDAO) DocumentVersions(ctx context.Context) (map[int]int64, error) {
+	cursor, err := p.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$version"}, {Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}}}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not count documents per version: %w", err)
+	}
+
+	var groups []bson.M
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, fmt.Errorf("could not count documents per version: %w", err)
+	}
+
+	versions := make(map[int]int64)
+	for _, group := range groups {
+		version, err := document{"version": group["_id"]}.version()
+		if err != nil {
+			return nil, err
+		}
+
+		count, _ := intValue(group["count"])
+		versions[version] += count
+	}
+
+	return versions, nil
+}
+
+// MigrateDocuments upgrades all documents not being at currentVersion and reports how many got upgraded.
+func (p *PlayerStatesDAO) MigrateDocuments(ctx context.Context) (int, error) {
+	filter := bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "version", Value: bson.D{{Key: "$lt", Value: currentVersion}}}},
+		bson.D{{Key: "version", Value: bson.D{{Key: "$exists", Value: false}}}},
+	}}}
+
+	cursor, err := p.collection.Find(ctx, filter)
+	if err != nil {
+		return 0, fmt.Errorf("could not query outdated documents: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	migrated := 0
+
+	for cursor.Next(ctx) {
+		var raw bson.M
+		if err := cursor.Decode(&raw); err != nil {
+			return migrated, fmt.Errorf("could not decode document: %w", err)
+		}
+
+		doc, err := p.openedDocument(raw)
+		if err != nil {
+			return migrated, err
+		}
+
+		originalVersion, err := registeredMigrations.upgrade(doc, currentVersion)
+		if err != nil {
+			return migrated, fmt.Errorf("could not upgrade document '%v': %w", doc["_id"], err)
+		}
+
+		if err := p.replaceUpgradedDocument(ctx, doc, originalVersion); err != nil {
+			return migrated, err
+		}
+
+		migrated++
+	}
+
+	return migrated, cursor.Err()
+}
+
+// KeyUsage counts the stored player states, including the ones in the trash, per master key they are sealed with.
+func (p *PlayerStatesDAO) KeyUsage(ctx context.Context) (map[string]int64, error) {
+	usage := make(map[string]int64)
+
+	err := p.forEachDocument(ctx, func(raw bson.M) error {
+		doc := newDocument(raw)
+
+		for _, key := range []string{"playerStates", "trash"} {
+			entries, _ := doc[key].([]interface{})
+			for _, entry := range entries {
+				usage[keyIDOf(entry)]++
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not count player states per master key: %w", err)
+	}
+
+	return usage, nil
+}
+
+// ReencryptDocuments seals all player states not sealed with the current master key or not bound to their user yet
+// with the former.
+// Documents which get modified concurrently are read again, the revision is not changed as the player states are.
+func (p *PlayerStatesDAO) ReencryptDocuments(ctx context.Context) (int, error) {
+	if p.keyring == nil {
+		return 0, ErrNoMasterKey
+	}
+
+	reencrypted := 0
+
+	err := p.forEachDocument(ctx, func(raw bson.M) error {
+		for {
+			changed, err := p.reencryptDocument(ctx, raw)
+			if err == nil {
+				if changed {
+					reencrypted++
+				}
+
+				return nil
+			}
+
+			if !errors.Is(err, ErrConflict) {
+				return err
+			}
+
+			id := raw["_id"]
+			raw = bson.M{}
+			if err := p.collection.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&raw); err != nil {
+				if err == mongo.ErrNoDocuments {
+					// Deleted in the meantime, nothing to re-encrypt
+					return nil
+				}
+
+				return fmt.Errorf("could not reload document: %w", err)
+			}
+		}
+	})
+
+	return reencrypted, err
+}
+
+// reencryptDocument stores the given document with all its player states sealed with the current master key
+// and bound to their user unless they are already. It fails with ErrConflict in case the document has been
+// modified in the meantime.
+func (p *PlayerStatesDAO) reencryptDocument(ctx context.Context, raw bson.M) (bool, error) {
+	doc := newDocument(raw)
+
+	upToDate := true
+	for _, key := range []string{"playerStates", "trash"} {
+		entries, _ := doc[key].([]interface{})
+		for _, entry := range entries {
+			if keyIDOf(entry) != p.keyring.CurrentKeyID() || !isBoundToUser(entry) {
+				upToDate = false
+			}
+		}
+	}
+
+	if upToDate {
+		return false, nil
+	}
+
+	if err := p.keyring.openDocument(doc); err != nil {
+		return false, fmt.Errorf("could not open document '%v': %w", doc["_id"], err)
+	}
+
+	sealed, err := p.keyring.sealDocument(doc)
+	if err != nil {
+		return false, fmt.Errorf("could not seal document '%v': %w", doc["_id"], err)
+	}
+
+	res, err := p.collection.ReplaceOne(ctx, unchangedFilter(raw), sealed)
+	if err != nil {
+		return false, fmt.Errorf("could not store re-encrypted document '%v': %w", doc["_id"], err)
+	}
+
+	if res.MatchedCount == 0 {
+		return false, ErrConflict
+	}
+
+	return true, nil
+}
+
+// LegacyUserIDs counts the documents still stored under the legacy hash of a user ID.
+func (p *PlayerStatesDAO) LegacyUserIDs(ctx context.Context) (int64, error) {
+	count, err := p.collection.CountDocuments(ctx, legacyUserIDFilter())
+	if err != nil {
+		return 0, fmt.Errorf("could not count documents stored under legacy user IDs: %w", err)
+	}
+
+	return count, nil
+}
+
+// RekeyUserIDs moves all documents stored under the legacy hash of a user ID to the peppered one.
+// As the ID of a document cannot be changed, it gets inserted under the new ID before the old one gets deleted.
+// In case the latter has been modified in the meantime the copy is deleted again and the document is read again.
+func (p *PlayerStatesDAO) RekeyUserIDs(ctx context.Context) (int, error) {
+	if p.userIDs == nil {
+		return 0, ErrNoPepper
+	}
+
+	cursor, err := p.collection.Find(ctx, legacyUserIDFilter())
+	if err != nil {
+		return 0, fmt.Errorf("could not query documents stored under legacy user IDs: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	rekeyed := 0
+
+	for cursor.Next(ctx) {
+		var raw bson.M
+		if err := cursor.Decode(&raw); err != nil {
+			return rekeyed, fmt.Errorf("could not decode document: %w", err)
+		}
+
+		for {
+			moved, err := p.rekeyDocument(ctx, raw)
+			if err == nil {
+				if moved {
+					rekeyed++
+				}
+
+				break
+			}
+
+			if !errors.Is(err, ErrConflict) {
+				return rekeyed, err
+			}
+
+			legacyID := raw["_id"]
+			raw = bson.M{}
+			if err := p.collection.FindOne(ctx, bson.D{{Key: "_id", Value: legacyID}}).Decode(&raw); err != nil {
+				if err == mongo.ErrNoDocuments {
+					// Deleted in the meantime, nothing to rekey
+					break
+				}
+
+				return rekeyed, fmt.Errorf("could not reload document: %w", err)
+			}
+		}
+	}
+
+	return rekeyed, cursor.Err()
+}
+
+// rekeyDocument moves the given document to the peppered ID. Its sealed player states are bound to the legacy ID,
+// so they get sealed again. It fails with ErrConflict in case the document has been modified in the meantime.
+func (p *PlayerStatesDAO) rekeyDocument(ctx context.Context, raw bson.M) (bool, error) {
+	legacyID, _ := raw["_id"].(string)
+	pepperedID := p.userIDs.rekeyed(legacyID)
+
+	doc, err := p.openedDocument(raw)
+	if err != nil {
+		return false, err
+	}
+	doc["_id"] = pepperedID
+
+	sealed, err := p.keyring.sealDocument(doc)
+	if err != nil {
+		return false, fmt.Errorf("could not seal document '%s': %w", legacyID, err)
+	}
+	rekeyedDoc := bson.M(sealed)
+
+	if _, err := p.collection.InsertOne(ctx, rekeyedDoc); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			log.Warn().Str("legacyID", legacyID).Msg("There already is a document stored under the peppered user ID, leaving the legacy one in place.")
+			return false, nil
+		}
+
+		return false, fmt.Errorf("could not insert document '%s' under peppered user ID: %w", legacyID, err)
+	}
+
+	res, err := p.collection.DeleteOne(ctx, unchangedFilter(raw))
+	if err == nil && res.DeletedCount == 1 {
+		return true, nil
+	}
+
+	// The copy has to go no matter why deleting the legacy document failed, unless it has been modified already
+	if _, undoErr := p.collection.DeleteOne(ctx, unchangedFilter(rekeyedDoc)); undoErr != nil {
+		log.Warn().Err(undoErr).Str("legacyID", legacyID).Msg("Could not delete copy of document stored under peppered user ID.")
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("could not delete document '%s' stored under legacy user ID: %w", legacyID, err)
+	}
+
+	return false, ErrConflict
+}
+
+func legacyUserIDFilter() bson.D {
+	return bson.D{{Key: "_id", Value: bson.D{{Key: "$not", Value: primitive.Regex{Pattern: "^" + pepperedUserIDPrefix}}}}}
+}
+
+// unchangedFilter matches the given document as long as it has not been modified.
+func unchangedFilter(raw bson.M) bson.D {
+	// Purging the trash does not change the revision but the number of slots in the trash
+	var trashFilter interface{} = bson.D{{Key: "$exists", Value: false}}
+	if trash, ok := newDocument(raw)["trash"].([]interface{}); ok {
+		trashFilter = bson.D{{Key: "$size", Value: len(trash)}}
+	}
+
+	return bson.D{{Key: "_id", Value: raw["_id"]}, {Key: "revision", Value: raw["revision"]}, {Key: "trash", Value: trashFilter}}
+}
+
+// forEachDocument calls fn for every stored document, stopping at the first error.
+func (p *PlayerStatesDAO) forEachDocument(ctx context.Context, fn func(raw bson.M) error) error {
+	cursor, err := p.collection.Find(ctx, bson.D{})
+	if err != nil {
+		return fmt.Errorf("could not query documents: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var raw bson.M
+		if err := cursor.Decode(&raw); err != nil {
+			return fmt.Errorf("could not decode document: %w", err)
+		}
+
+		if err := fn(raw); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+// findCheckedItem loads the document of the given user and ensures it is at the expected revision
+// and contains the given slot.
+func (p *PlayerStatesDAO) findCheckedItem(ctx context.Context, hashedUserID string, revision int64, slot int) (*persistenceItem, error) {
+	item, err := p.findItem(ctx, hashedUserID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			item = &persistenceItem{}
+		} else {
+			return nil, err
+		}
+	}
+
+	if err := checkSlot(item, revision, slot); err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
+
+// updateRevision applies the given update in case the document is still at the expected revision
+// and increments the latter.
+func (p *PlayerStatesDAO) updateRevision(ctx context.Context, hashedUserID string, revision int64, update bson.D) error {
+	update = withLastActivity(update)
+	update = append(update, bson.E{Key: "$inc", Value: bson.D{{Key: "revision", Value: 1}}})
+
+	var revisionFilter interface{} = revision
+	if revision == 0 {
+		// Matches documents written before revisions were introduced as well
+		revisionFilter = bson.D{{Key: "$in", Value: bson.A{0, nil}}}
+	}
+
+	res, err := p.collection.UpdateOne(ctx, bson.D{{Key: "_id", Value: hashedUserID}, {Key: "revision", Value: revisionFilter}}, update)
+	if err != nil {
+		return fmt.Errorf("could not update player states: %w", err)
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrConflict
+	}
+
+	return nil
+}
+
+// hashedUserID returns the ID the document of the given user is stored under.
+func (p *PlayerStatesDAO) hashedUserID(ctx context.Context, userID string) (string, error) {
+	hashedUserID, err := p.userIDs.resolve(ctx, userID, func(ctx context.Context, hashedUserID string) (bool, error) {
+		count, err := p.collection.CountDocuments(ctx, bson.D{{Key: "_id", Value: hashedUserID}}, options.Count().SetLimit(1))
+		return count > 0, err
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not look up document of user: %w", err)
+	}
+
+	return hashedUserID, nil
+}
+
+// withLastActivity adds setting the last activity to now to the given update, merging it into an existing '$set'.
+func withLastActivity(update bson.D) bson.D {
+	lastActivity := bson.E{Key: "lastActivityTs", Value: time.Now().Unix()}
+
+	for i, operation := range update {
+		if operation.Key == "$set" {
+			fields, _ := operation.Value.(bson.D)
+			update[i].Value = append(fields[:len(fields):len(fields)], lastActivity)
+
+			return update
+		}
+	}
+
+	return append(update, bson.E{Key: "$set", Value: bson.D{lastActivity}})
+}
+
+// findItem loads the document of the given user and upgrades it lazily in case it is outdated.
+func (p *PlayerStatesDAO) findItem(ctx context.Context, hashedUserID string) (*persistenceItem, error) {
+	var raw bson.M
+	err := p.collection.FindOne(ctx, bson.D{{Key: "_id", Value: hashedUserID}}).Decode(&raw)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := p.openedDocument(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	originalVersion, err := registeredMigrations.upgrade(doc, currentVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	if originalVersion != currentVersion {
+		// Not being able to store the upgraded document is no reason to fail, we will simply try again next time
+		if err := p.replaceUpgradedDocument(ctx, doc, originalVersion); err != nil {
+			log.Warn().Err(err).Int("version", originalVersion).Msg("Could not store upgraded document.")
+		}
+	}
+
+	return doc.toPersistenceItem()
+}
+
+// openedDocument converts the given raw document, replacing its sealed player states with their plaintext.
+func (p *PlayerStatesDAO) openedDocument(raw bson.M) (document, error) {
+	doc := newDocument(raw)
+
+	if err := p.keyring.openDocument(doc); err != nil {
+		return nil, fmt.Errorf("could not open document '%v': %w", doc["_id"], err)
+	}
+
+	return doc, nil
+}
+
+// replaceUpgradedDocument stores the upgraded document unless it has been changed in the meantime.
+func (p *PlayerStatesDAO) replaceUpgradedDocument(ctx context.Context, doc document, originalVersion int) error {
+	var versionFilter interface{} = originalVersion
+	if originalVersion == initialVersion {
+		// Matches documents without version as well
+		versionFilter = bson.D{{Key: "$in", Value: bson.A{initialVersion, nil}}}
+	}
+	filter := bson.D{{Key: "_id", Value: doc["_id"]}, {Key: "version", Value: versionFilter}}
+
+	sealed, err := p.keyring.sealDocument(doc)
+	if err != nil {
+		return fmt.Errorf("could not seal upgraded document '%v': %w", doc["_id"], err)
+	}
+
+	_, err = p.collection.ReplaceOne(ctx, filter, sealed)
+	if err != nil {
+		return fmt.Errorf("could not store upgraded document '%v': %w", doc["_id"], err)
+	}
+
+	return nil
 }
 
 type PlayerState struct {
+	ID                 string `json:"id" bson:"id"` // stable identifier of the slot the state is stored in
 	PlaybackContextURI string `json:"-" bson:"playbackContextURI"`
 	PlaybackItemURI    string `json:"-" bson:"playbackItemURI"`
 	LinkToContext      string `json:"linkToContext" bson:"linkToContext"`                   // link to open context in Spotify
-	ContextType        string `json:"contextType" bson:"contextType"`                       // either "album" or "playlist"
-	PlaylistName       string `json:"playlistName,omitempty" bson:"playlistName,omitempty"` // only populated when ContextType is "playlist"
+	ContextType        string `json:"contextType" bson:"contextType"`                       // either "album", "playlist", "collection", "artist", "show" or "audiobook"
+	PlaylistName       string `json:"playlistName,omitempty" bson:"playlistName,omitempty"` // only populated when ContextType is "playlist" or "collection"
 	AlbumArtLargeURL   string `json:"albumArtLargeURL" bson:"albumArtLargeURL"`             // should be 640px
 	AlbumArtMediumURL  string `json:"albumArtMediumURL" bson:"albumArtMediumURL"`           // should be 300px
 	TrackName          string `json:"trackName" bson:"trackName"`
 	AlbumName          string `json:"albumName" bson:"albumName"`
 	ArtistName         string `json:"artistName" bson:"artistName"`
-	TrackIndex         int    `json:"trackIndex" bson:"trackIndex"` // differing from Spotify's 'TrackNumber' this is an absolute number, not relative to the disk the track is contained on
+	NarratorName       string `json:"narratorName,omitempty" bson:"narratorName,omitempty"` // only populated when ContextType is "audiobook"
+	TrackIndex         int    `json:"trackIndex" bson:"trackIndex"`                         // differing from Spotify's 'TrackNumber' this is an absolute number, not relative to the disk the track is contained on
 	TotalTracks        int    `json:"totalTracks" bson:"totalTracks"`
 	Progress           int    `json:"progress" bson:"progress"`
 	Duration           int    `json:"duration" bson:"duration"`
 	ShuffleActivated   bool   `json:"shuffleActivated" bson:"shuffleActivated"`
 	SuspendedAtTs      int64  `json:"suspendedAtTs" bson:"suspendedAtTs"`
+	// previous states of the slot, the most recent one first; provided via its own route to keep listing slots cheap
+	History []*PlayerState `json:"-" bson:"history,omitempty"`
+	// only populated for slots in the trash
+	RemovedAtTs int64 `json:"removedAtTs,omitempty" bson:"removedAtTs,omitempty"`
+	// pinned slots are never evicted, being pinned is a property of the slot and kept when replacing its state
+	Pinned bool `json:"pinned" bson:"pinned,omitempty"`
 }
 
 type persistenceItem struct {
 	Version      int            `bson:"version" json:"version"`
+	Revision     int64          `bson:"revision" json:"-"`
 	UserID       string         `bson:"_id" json:"_id"`
 	PlayerStates []*PlayerState `bson:"playerStates" json:"playerStates"`
+	Trash        []*PlayerState `bson:"trash,omitempty" json:"trash"` // removed slots, the most recently removed one first
+	// Unix timestamp of the user's last modification or, with a resolution of activityResolution, read
+	LastActivityTs int64 `bson:"lastActivityTs" json:"lastActivityTs"`
+}
+
+// checkSlot ensures the item is at the expected revision and contains the given slot.
+func checkSlot(item *persistenceItem, revision int64, slot int) error {
+	if item.Revision != revision {
+		return ErrConflict
+	}
+
+	if slot < 0 || slot >= len(item.PlayerStates) {
+		return ErrSlotNotFound
+	}
+
+	return nil
+}
+
+func removeSlot(playerStates []*PlayerState, slot int) []*PlayerState {
+	remaining := make([]*PlayerState, 0, len(playerStates)-1)
+	remaining = append(remaining, playerStates[:slot]...)
+
+	return append(remaining, playerStates[slot+1:]...)
+}
+
+// trashed returns a copy of the given slot marked as removed right now.
+func trashed(playerState *PlayerState) *PlayerState {
+	stateCopy := *playerState
+	stateCopy.RemovedAtTs = time.Now().Unix()
+
+	return &stateCopy
+}
+
+// restored returns a copy of the given trashed slot no longer marked as removed.
+func restored(playerState *PlayerState) *PlayerState {
+	stateCopy := *playerState
+	stateCopy.RemovedAtTs = 0
+
+	return &stateCopy
+}
+
+// withPinned returns a copy of the given slot being pinned resp. not.
+func withPinned(playerState *PlayerState, pinned bool) *PlayerState {
+	stateCopy := *playerState
+	stateCopy.Pinned = pinned
+
+	return &stateCopy
+}
+
+// trashIndexOf returns the index of the slot with the given ID within the trash, -1 if there is none.
+func trashIndexOf(trash []*PlayerState, slotID string) int {
+	for i, playerState := range trash {
+		if playerState.ID == slotID {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// withSlotIDs returns copies of the given player states, states without an ID get a new one.
+// The entries of a slot's history always carry the ID of the slot.
+func withSlotIDs(playerStates []*PlayerState) []*PlayerState {
+	playerStates = copyPlayerStates(playerStates)
+
+	for _, playerState := range playerStates {
+		if playerState.ID == "" {
+			playerState.ID = newSlotID()
+		}
+
+		for _, snapshot := range playerState.History {
+			snapshot.ID = playerState.ID
+		}
+	}
+
+	return playerStates
+}
+
+// replacementOf returns a copy of replacement carrying the ID of the slot it replaces.
+// The replaced state becomes the most recent entry of the slot's history, the oldest entries get dropped
+// in case the history exceeds historyLength.
+func replacementOf(replaced, replacement *PlayerState) *PlayerState {
+	stateCopy := *replacement
+	stateCopy.ID = replaced.ID
+	stateCopy.Pinned = replaced.Pinned
+
+	snapshot := *replaced
+	snapshot.History = nil
+
+	stateCopy.History = append([]*PlayerState{&snapshot}, copyPlayerStates(replaced.History)...)
+	if len(stateCopy.History) > historyLength {
+		stateCopy.History = stateCopy.History[:historyLength]
+	}
+
+	return &stateCopy
+}
+
+func newSlotID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		// crypto/rand only fails in case the OS does not provide randomness, there is no sensible way to continue
+		panic(fmt.Sprintf("could not generate slot ID: %s", err))
+	}
+
+	return hex.EncodeToString(id)
+}
+
+// isSlotID reports whether the given ID has been generated by newSlotID. Other IDs might be mistaken
+// for the index of a slot, resp. do not belong into URLs.
+func isSlotID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+
+	for _, c := range id {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func moveSlot(playerStates []*PlayerState, from, to int) ([]*PlayerState, error) {
+	if to < 0 || to >= len(playerStates) {
+		return nil, ErrSlotNotFound
+	}
+
+	moved := removeSlot(playerStates, from)
+	moved = append(moved[:to], append([]*PlayerState{playerStates[from]}, moved[to:]...)...)
+
+	return moved, nil
 }
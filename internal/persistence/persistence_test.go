@@ -0,0 +1,70 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+// Set this to the URI of a MongoDB instance in order to run the conformance suite against PlayerStatesDAO,
+// e.g. "mongodb://localhost:27017". Every test uses its own, temporary database.
+const envTestMongoURI = "CASSETTE_TEST_MONGODB_URI"
+
+func TestPlayerStatesDAOConformance(t *testing.T) {
+	mongoURI := os.Getenv(envTestMongoURI)
+	if mongoURI == "" {
+		t.Skipf("'%s' is not set, skipping tests against MongoDB.", envTestMongoURI)
+	}
+
+	newPersistor := func(opts Options) persistorFactory {
+		return func(t *testing.T) PlayerStatesPersistor {
+			u, err := url.Parse(mongoURI)
+			if err != nil {
+				t.Fatalf("invalid MongoDB URI: %s", err)
+			}
+			u.Path = fmt.Sprintf("/cassette_test_%d", time.Now().UnixNano())
+
+			dao, err := connectMongo(u.String(), opts)
+			if err != nil {
+				t.Fatalf("could not connect to MongoDB: %s", err)
+			}
+
+			t.Cleanup(func() {
+				if err := dao.collection.Database().Drop(context.Background()); err != nil {
+					t.Errorf("could not drop test database: %s", err)
+				}
+			})
+
+			return dao
+		}
+	}
+
+	t.Run("Plaintext", func(t *testing.T) {
+		runConformanceSuite(t, newPersistor(Options{}))
+	})
+
+	// Sealed states are bound to the user ID, so e.g. rekeying has to seal them again
+	t.Run("Sealed", func(t *testing.T) {
+		runConformanceSuite(t, newPersistor(Options{Keyring: mustNewKeyring(t, "a:"+masterKey(1))}))
+	})
+}
+
+func TestConnectRejectsUnknownScheme(t *testing.T) {
+	if _, err := Connect("redis://localhost:6379", Options{}); err == nil {
+		t.Fatal("expected an error for an unsupported scheme")
+	}
+}
+
+func TestConnectToMemory(t *testing.T) {
+	p, err := Connect("memory://", Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, ok := p.(*MemoryPersistor); !ok {
+		t.Fatalf("expected a *MemoryPersistor, got %T", p)
+	}
+}
@@ -0,0 +1,242 @@
+package persistence
+
+// DO NOT EDIT!
+// This code is generated with http://github.com/hexdigest/gowrap tool
+// using metricsWrapper.tmpl template
+
+//go:generate gowrap gen -p github.com/florianloch/cassette/internal/persistence -i PlayerStatesPersistor -t metricsWrapper.tmpl -o persistorWithMetrics.go
+
+// To be used with https://github.com/hexdigest/gowrap
+// Based on https://github.com/hexdigest/gowrap/blob/a00b5e810bdf0db43652c86216d4dfd2fc8c9afc/templates/prometheus
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	persistenceDurationHistogram = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "cassette",
+		Subsystem: "persistence",
+		Name:      "call_duration_seconds",
+		Help:      "Duration of calls to the persistence backend by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+	persistenceErrorsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cassette",
+		Subsystem: "persistence",
+		Name:      "errors_total",
+		Help:      "Number of failed calls to the persistence backend by method and kind of error.",
+	}, []string{"method", "kind"})
+	persistenceSlotsHistogram = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "cassette",
+		Subsystem: "persistence",
+		Name:      "slots",
+		Help:      "Number of slots loaded from resp. saved to the persistence backend by method.",
+		Buckets:   []float64{0, 1, 2, 5, 10, 20, 50, 100, 200},
+	}, []string{"method"})
+)
+
+// PlayerStatesPersistorWithMetrics implements PlayerStatesPersistor interface instrumented with Prometheus metrics
+type PlayerStatesPersistorWithMetrics struct {
+	PlayerStatesPersistor
+}
+
+// NewPlayerStatesPersistorWithMetrics returns PlayerStatesPersistorWithMetrics
+func NewPlayerStatesPersistorWithMetrics(base PlayerStatesPersistor) PlayerStatesPersistorWithMetrics {
+	return PlayerStatesPersistorWithMetrics{
+		PlayerStatesPersistor: base,
+	}
+}
+
+// AppendState implements PlayerStatesPersistor
+func (_d PlayerStatesPersistorWithMetrics) AppendState(ctx context.Context, userID string, playerState *PlayerState) (err error) {
+	_since := time.Now()
+	defer func() {
+		persistenceDurationHistogram.WithLabelValues("AppendState").Observe(time.Since(_since).Seconds())
+		if err != nil {
+			persistenceErrorsCounter.WithLabelValues("AppendState", errorKind(err)).Inc()
+		}
+	}()
+	return _d.PlayerStatesPersistor.AppendState(ctx, userID, playerState)
+}
+
+// CountInactiveUsers implements PlayerStatesPersistor
+func (_d PlayerStatesPersistorWithMetrics) CountInactiveUsers(ctx context.Context, inactiveSince time.Time) (i1 int64, err error) {
+	_since := time.Now()
+	defer func() {
+		persistenceDurationHistogram.WithLabelValues("CountInactiveUsers").Observe(time.Since(_since).Seconds())
+		if err != nil {
+			persistenceErrorsCounter.WithLabelValues("CountInactiveUsers", errorKind(err)).Inc()
+		}
+	}()
+	return _d.PlayerStatesPersistor.CountInactiveUsers(ctx, inactiveSince)
+}
+
+// DeleteUserRecord implements PlayerStatesPersistor
+func (_d PlayerStatesPersistorWithMetrics) DeleteUserRecord(ctx context.Context, userID string) (err error) {
+	_since := time.Now()
+	defer func() {
+		persistenceDurationHistogram.WithLabelValues("DeleteUserRecord").Observe(time.Since(_since).Seconds())
+		if err != nil {
+			persistenceErrorsCounter.WithLabelValues("DeleteUserRecord", errorKind(err)).Inc()
+		}
+	}()
+	return _d.PlayerStatesPersistor.DeleteUserRecord(ctx, userID)
+}
+
+// FetchJSONDump implements PlayerStatesPersistor
+func (_d PlayerStatesPersistorWithMetrics) FetchJSONDump(ctx context.Context, userID string) (ba1 []byte, err error) {
+	_since := time.Now()
+	defer func() {
+		persistenceDurationHistogram.WithLabelValues("FetchJSONDump").Observe(time.Since(_since).Seconds())
+		if err != nil {
+			persistenceErrorsCounter.WithLabelValues("FetchJSONDump", errorKind(err)).Inc()
+		}
+	}()
+	return _d.PlayerStatesPersistor.FetchJSONDump(ctx, userID)
+}
+
+// LoadPlayerStates implements PlayerStatesPersistor
+func (_d PlayerStatesPersistorWithMetrics) LoadPlayerStates(ctx context.Context, userID string) (ppa1 []*PlayerState, i1 int64, err error) {
+	_since := time.Now()
+	defer func() {
+		persistenceDurationHistogram.WithLabelValues("LoadPlayerStates").Observe(time.Since(_since).Seconds())
+		if err != nil {
+			persistenceErrorsCounter.WithLabelValues("LoadPlayerStates", errorKind(err)).Inc()
+		} else {
+			persistenceSlotsHistogram.WithLabelValues("LoadPlayerStates").Observe(float64(len(ppa1)))
+		}
+	}()
+	return _d.PlayerStatesPersistor.LoadPlayerStates(ctx, userID)
+}
+
+// LoadTrash implements PlayerStatesPersistor
+func (_d PlayerStatesPersistorWithMetrics) LoadTrash(ctx context.Context, userID string) (ppa1 []*PlayerState, err error) {
+	_since := time.Now()
+	defer func() {
+		persistenceDurationHistogram.WithLabelValues("LoadTrash").Observe(time.Since(_since).Seconds())
+		if err != nil {
+			persistenceErrorsCounter.WithLabelValues("LoadTrash", errorKind(err)).Inc()
+		} else {
+			persistenceSlotsHistogram.WithLabelValues("LoadTrash").Observe(float64(len(ppa1)))
+		}
+	}()
+	return _d.PlayerStatesPersistor.LoadTrash(ctx, userID)
+}
+
+// MoveState implements PlayerStatesPersistor
+func (_d PlayerStatesPersistorWithMetrics) MoveState(ctx context.Context, userID string, revision int64, from int, to int) (err error) {
+	_since := time.Now()
+	defer func() {
+		persistenceDurationHistogram.WithLabelValues("MoveState").Observe(time.Since(_since).Seconds())
+		if err != nil {
+			persistenceErrorsCounter.WithLabelValues("MoveState", errorKind(err)).Inc()
+		}
+	}()
+	return _d.PlayerStatesPersistor.MoveState(ctx, userID, revision, from, to)
+}
+
+// PurgeInactiveUsers implements PlayerStatesPersistor
+func (_d PlayerStatesPersistorWithMetrics) PurgeInactiveUsers(ctx context.Context, inactiveSince time.Time) (i1 int64, err error) {
+	_since := time.Now()
+	defer func() {
+		persistenceDurationHistogram.WithLabelValues("PurgeInactiveUsers").Observe(time.Since(_since).Seconds())
+		if err != nil {
+			persistenceErrorsCounter.WithLabelValues("PurgeInactiveUsers", errorKind(err)).Inc()
+		}
+	}()
+	return _d.PlayerStatesPersistor.PurgeInactiveUsers(ctx, inactiveSince)
+}
+
+// PurgeTrash implements PlayerStatesPersistor
+func (_d PlayerStatesPersistorWithMetrics) PurgeTrash(ctx context.Context, removedBefore time.Time) (err error) {
+	_since := time.Now()
+	defer func() {
+		persistenceDurationHistogram.WithLabelValues("PurgeTrash").Observe(time.Since(_since).Seconds())
+		if err != nil {
+			persistenceErrorsCounter.WithLabelValues("PurgeTrash", errorKind(err)).Inc()
+		}
+	}()
+	return _d.PlayerStatesPersistor.PurgeTrash(ctx, removedBefore)
+}
+
+// RemoveState implements PlayerStatesPersistor
+func (_d PlayerStatesPersistorWithMetrics) RemoveState(ctx context.Context, userID string, revision int64, slot int) (err error) {
+	_since := time.Now()
+	defer func() {
+		persistenceDurationHistogram.WithLabelValues("RemoveState").Observe(time.Since(_since).Seconds())
+		if err != nil {
+			persistenceErrorsCounter.WithLabelValues("RemoveState", errorKind(err)).Inc()
+		}
+	}()
+	return _d.PlayerStatesPersistor.RemoveState(ctx, userID, revision, slot)
+}
+
+// ReplaceState implements PlayerStatesPersistor
+func (_d PlayerStatesPersistorWithMetrics) ReplaceState(ctx context.Context, userID string, revision int64, slot int, playerState *PlayerState) (err error) {
+	_since := time.Now()
+	defer func() {
+		persistenceDurationHistogram.WithLabelValues("ReplaceState").Observe(time.Since(_since).Seconds())
+		if err != nil {
+			persistenceErrorsCounter.WithLabelValues("ReplaceState", errorKind(err)).Inc()
+		}
+	}()
+	return _d.PlayerStatesPersistor.ReplaceState(ctx, userID, revision, slot, playerState)
+}
+
+// RestoreFromTrash implements PlayerStatesPersistor
+func (_d PlayerStatesPersistorWithMetrics) RestoreFromTrash(ctx context.Context, userID string, slotID string) (err error) {
+	_since := time.Now()
+	defer func() {
+		persistenceDurationHistogram.WithLabelValues("RestoreFromTrash").Observe(time.Since(_since).Seconds())
+		if err != nil {
+			persistenceErrorsCounter.WithLabelValues("RestoreFromTrash", errorKind(err)).Inc()
+		}
+	}()
+	return _d.PlayerStatesPersistor.RestoreFromTrash(ctx, userID, slotID)
+}
+
+// SavePlayerStates implements PlayerStatesPersistor
+func (_d PlayerStatesPersistorWithMetrics) SavePlayerStates(ctx context.Context, userID string, playerStates []*PlayerState) (err error) {
+	_since := time.Now()
+	persistenceSlotsHistogram.WithLabelValues("SavePlayerStates").Observe(float64(len(playerStates)))
+	defer func() {
+		persistenceDurationHistogram.WithLabelValues("SavePlayerStates").Observe(time.Since(_since).Seconds())
+		if err != nil {
+			persistenceErrorsCounter.WithLabelValues("SavePlayerStates", errorKind(err)).Inc()
+		}
+	}()
+	return _d.PlayerStatesPersistor.SavePlayerStates(ctx, userID, playerStates)
+}
+
+// SetPinned implements PlayerStatesPersistor
+func (_d PlayerStatesPersistorWithMetrics) SetPinned(ctx context.Context, userID string, revision int64, slot int, pinned bool) (err error) {
+	_since := time.Now()
+	defer func() {
+		persistenceDurationHistogram.WithLabelValues("SetPinned").Observe(time.Since(_since).Seconds())
+		if err != nil {
+			persistenceErrorsCounter.WithLabelValues("SetPinned", errorKind(err)).Inc()
+		}
+	}()
+	return _d.PlayerStatesPersistor.SetPinned(ctx, userID, revision, slot, pinned)
+}
+
+// errorKind tells expected errors, which are caused by the client, apart from failures of the backend
+func errorKind(err error) string {
+	switch {
+	case errors.Is(err, ErrConflict):
+		return "conflict"
+	case errors.Is(err, ErrUserNotFound), errors.Is(err, ErrSlotNotFound), errors.Is(err, ErrNotInTrash):
+		return "not_found"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	default:
+		return "other"
+	}
+}
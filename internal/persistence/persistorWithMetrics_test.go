@@ -0,0 +1,53 @@
+package persistence
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestMetricsCountErrorsByKind(t *testing.T) {
+	p := NewPlayerStatesPersistorWithMetrics(NewMemoryPersistor())
+
+	notFound := persistenceErrorsCounter.WithLabelValues("RemoveState", "not_found")
+	before := testutil.ToFloat64(notFound)
+
+	if err := p.RemoveState(t.Context(), "unknown_user", 0, 0); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if after := testutil.ToFloat64(notFound); after != before+1 {
+		t.Errorf("expected the counter to be incremented, got %v after %v", after, before)
+	}
+}
+
+func TestMetricsObserveNumberOfSlots(t *testing.T) {
+	p := NewPlayerStatesPersistorWithMetrics(NewMemoryPersistor())
+
+	savedBefore, loadedBefore := observedSlots(t, "SavePlayerStates"), observedSlots(t, "LoadPlayerStates")
+
+	mustSave(t, p, "user", []*PlayerState{fullPlayerState("book 1"), fullPlayerState("book 2")})
+	mustAppend(t, p, "user", fullPlayerState("book 3"))
+	mustLoad(t, p, "user")
+
+	if saved := observedSlots(t, "SavePlayerStates") - savedBefore; saved != 2 {
+		t.Errorf("expected 2 saved slots to be observed, got %v", saved)
+	}
+	if loaded := observedSlots(t, "LoadPlayerStates") - loadedBefore; loaded != 3 {
+		t.Errorf("expected 3 loaded slots to be observed, got %v", loaded)
+	}
+}
+
+// observedSlots returns the sum of all slots observed for the given method.
+func observedSlots(t *testing.T, method string) float64 {
+	t.Helper()
+
+	var metric dto.Metric
+	if err := persistenceSlotsHistogram.WithLabelValues(method).(prometheus.Histogram).Write(&metric); err != nil {
+		t.Fatalf("could not read histogram: %s", err)
+	}
+
+	return metric.GetHistogram().GetSampleSum()
+}
@@ -0,0 +1,196 @@
+package persistence
+
+// DO NOT EDIT!
+// This code is generated with http://github.com/hexdigest/gowrap tool
+// using timeout template
+
+//go:generate gowrap gen -p github.com/florianloch/cassette/internal/persistence -i PlayerStatesPersistor -t timeout -o persistorWithTimeout.go
+
+import (
+	"context"
+	"time"
+)
+
+// PlayerStatesPersistorWithTimeout implements PlayerStatesPersistor interface instrumented with timeouts
+type PlayerStatesPersistorWithTimeout struct {
+	PlayerStatesPersistor
+	config PlayerStatesPersistorWithTimeoutConfig
+}
+
+type PlayerStatesPersistorWithTimeoutConfig struct {
+	AppendStateTimeout time.Duration
+
+	CountInactiveUsersTimeout time.Duration
+
+	DeleteUserRecordTimeout time.Duration
+
+	FetchJSONDumpTimeout time.Duration
+
+	LoadPlayerStatesTimeout time.Duration
+
+	LoadTrashTimeout time.Duration
+
+	MoveStateTimeout time.Duration
+
+	PurgeInactiveUsersTimeout time.Duration
+
+	PurgeTrashTimeout time.Duration
+
+	RemoveStateTimeout time.Duration
+
+	ReplaceStateTimeout time.Duration
+
+	RestoreFromTrashTimeout time.Duration
+
+	SavePlayerStatesTimeout time.Duration
+
+	SetPinnedTimeout time.Duration
+}
+
+// NewPlayerStatesPersistorWithTimeout returns PlayerStatesPersistorWithTimeout
+func NewPlayerStatesPersistorWithTimeout(base PlayerStatesPersistor, config PlayerStatesPersistorWithTimeoutConfig) PlayerStatesPersistorWithTimeout {
+	return PlayerStatesPersistorWithTimeout{
+		PlayerStatesPersistor: base,
+		config:                config,
+	}
+}
+
+// AppendState implements PlayerStatesPersistor
+func (_d PlayerStatesPersistorWithTimeout) AppendState(ctx context.Context, userID string, playerState *PlayerState) (err error) {
+	var cancelFunc func()
+	if _d.config.AppendStateTimeout > 0 {
+		ctx, cancelFunc = context.WithTimeout(ctx, _d.config.AppendStateTimeout)
+		defer cancelFunc()
+	}
+	return _d.PlayerStatesPersistor.AppendState(ctx, userID, playerState)
+}
+
+// CountInactiveUsers implements PlayerStatesPersistor
+func (_d PlayerStatesPersistorWithTimeout) CountInactiveUsers(ctx context.Context, inactiveSince time.Time) (i1 int64, err error) {
+	var cancelFunc func()
+	if _d.config.CountInactiveUsersTimeout > 0 {
+		ctx, cancelFunc = context.WithTimeout(ctx, _d.config.CountInactiveUsersTimeout)
+		defer cancelFunc()
+	}
+	return _d.PlayerStatesPersistor.CountInactiveUsers(ctx, inactiveSince)
+}
+
+// DeleteUserRecord implements PlayerStatesPersistor
+func (_d PlayerStatesPersistorWithTimeout) DeleteUserRecord(ctx context.Context, userID string) (err error) {
+	var cancelFunc func()
+	if _d.config.DeleteUserRecordTimeout > 0 {
+		ctx, cancelFunc = context.WithTimeout(ctx, _d.config.DeleteUserRecordTimeout)
+		defer cancelFunc()
+	}
+	return _d.PlayerStatesPersistor.DeleteUserRecord(ctx, userID)
+}
+
+// FetchJSONDump implements PlayerStatesPersistor
+func (_d PlayerStatesPersistorWithTimeout) FetchJSONDump(ctx context.Context, userID string) (ba1 []byte, err error) {
+	var cancelFunc func()
+	if _d.config.FetchJSONDumpTimeout > 0 {
+		ctx, cancelFunc = context.WithTimeout(ctx, _d.config.FetchJSONDumpTimeout)
+		defer cancelFunc()
+	}
+	return _d.PlayerStatesPersistor.FetchJSONDump(ctx, userID)
+}
+
+// LoadPlayerStates implements PlayerStatesPersistor
+func (_d PlayerStatesPersistorWithTimeout) LoadPlayerStates(ctx context.Context, userID string) (ppa1 []*PlayerState, i1 int64, err error) {
+	var cancelFunc func()
+	if _d.config.LoadPlayerStatesTimeout > 0 {
+		ctx, cancelFunc = context.WithTimeout(ctx, _d.config.LoadPlayerStatesTimeout)
+		defer cancelFunc()
+	}
+	return _d.PlayerStatesPersistor.LoadPlayerStates(ctx, userID)
+}
+
+// LoadTrash implements PlayerStatesPersistor
+func (_d PlayerStatesPersistorWithTimeout) LoadTrash(ctx context.Context, userID string) (ppa1 []*PlayerState, err error) {
+	var cancelFunc func()
+	if _d.config.LoadTrashTimeout > 0 {
+		ctx, cancelFunc = context.WithTimeout(ctx, _d.config.LoadTrashTimeout)
+		defer cancelFunc()
+	}
+	return _d.PlayerStatesPersistor.LoadTrash(ctx, userID)
+}
+
+// MoveState implements PlayerStatesPersistor
+func (_d PlayerStatesPersistorWithTimeout) MoveState(ctx context.Context, userID string, revision int64, from int, to int) (err error) {
+	var cancelFunc func()
+	if _d.config.MoveStateTimeout > 0 {
+		ctx, cancelFunc = context.WithTimeout(ctx, _d.config.MoveStateTimeout)
+		defer cancelFunc()
+	}
+	return _d.PlayerStatesPersistor.MoveState(ctx, userID, revision, from, to)
+}
+
+// PurgeInactiveUsers implements PlayerStatesPersistor
+func (_d PlayerStatesPersistorWithTimeout) PurgeInactiveUsers(ctx context.Context, inactiveSince time.Time) (i1 int64, err error) {
+	var cancelFunc func()
+	if _d.config.PurgeInactiveUsersTimeout > 0 {
+		ctx, cancelFunc = context.WithTimeout(ctx, _d.config.PurgeInactiveUsersTimeout)
+		defer cancelFunc()
+	}
+	return _d.PlayerStatesPersistor.PurgeInactiveUsers(ctx, inactiveSince)
+}
+
+// PurgeTrash implements PlayerStatesPersistor
+func (_d PlayerStatesPersistorWithTimeout) PurgeTrash(ctx context.Context, removedBefore time.Time) (err error) {
+	var cancelFunc func()
+	if _d.config.PurgeTrashTimeout > 0 {
+		ctx, cancelFunc = context.WithTimeout(ctx, _d.config.PurgeTrashTimeout)
+		defer cancelFunc()
+	}
+	return _d.PlayerStatesPersistor.PurgeTrash(ctx, removedBefore)
+}
+
+// RemoveState implements PlayerStatesPersistor
+func (_d PlayerStatesPersistorWithTimeout) RemoveState(ctx context.Context, userID string, revision int64, slot int) (err error) {
+	var cancelFunc func()
+	if _d.config.RemoveStateTimeout > 0 {
+		ctx, cancelFunc = context.WithTimeout(ctx, _d.config.RemoveStateTimeout)
+		defer cancelFunc()
+	}
+	return _d.PlayerStatesPersistor.RemoveState(ctx, userID, revision, slot)
+}
+
+// ReplaceState implements PlayerStatesPersistor
+func (_d PlayerStatesPersistorWithTimeout) ReplaceState(ctx context.Context, userID string, revision int64, slot int, playerState *PlayerState) (err error) {
+	var cancelFunc func()
+	if _d.config.ReplaceStateTimeout > 0 {
+		ctx, cancelFunc = context.WithTimeout(ctx, _d.config.ReplaceStateTimeout)
+		defer cancelFunc()
+	}
+	return _d.PlayerStatesPersistor.ReplaceState(ctx, userID, revision, slot, playerState)
+}
+
+// RestoreFromTrash implements PlayerStatesPersistor
+func (_d PlayerStatesPersistorWithTimeout) RestoreFromTrash(ctx context.Context, userID string, slotID string) (err error) {
+	var cancelFunc func()
+	if _d.config.RestoreFromTrashTimeout > 0 {
+		ctx, cancelFunc = context.WithTimeout(ctx, _d.config.RestoreFromTrashTimeout)
+		defer cancelFunc()
+	}
+	return _d.PlayerStatesPersistor.RestoreFromTrash(ctx, userID, slotID)
+}
+
+// SavePlayerStates implements PlayerStatesPersistor
+func (_d PlayerStatesPersistorWithTimeout) SavePlayerStates(ctx context.Context, userID string, playerStates []*PlayerState) (err error) {
+	var cancelFunc func()
+	if _d.config.SavePlayerStatesTimeout > 0 {
+		ctx, cancelFunc = context.WithTimeout(ctx, _d.config.SavePlayerStatesTimeout)
+		defer cancelFunc()
+	}
+	return _d.PlayerStatesPersistor.SavePlayerStates(ctx, userID, playerStates)
+}
+
+// SetPinned implements PlayerStatesPersistor
+func (_d PlayerStatesPersistorWithTimeout) SetPinned(ctx context.Context, userID string, revision int64, slot int, pinned bool) (err error) {
+	var cancelFunc func()
+	if _d.config.SetPinnedTimeout > 0 {
+		ctx, cancelFunc = context.WithTimeout(ctx, _d.config.SetPinnedTimeout)
+		defer cancelFunc()
+	}
+	return _d.PlayerStatesPersistor.SetPinned(ctx, userID, revision, slot, pinned)
+}
@@ -0,0 +1,136 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+const (
+	// QuotaPolicyReject rejects adding slots to users having reached the quota with ErrQuotaExceeded.
+	QuotaPolicyReject QuotaPolicy = "reject"
+	// QuotaPolicyEvict moves the least recently suspended slot which is not pinned to the trash in order to
+	// make room for a new one. In case all slots are pinned adding slots fails with ErrQuotaExceeded.
+	QuotaPolicyEvict QuotaPolicy = "evict"
+
+	// Number of times making room for a new slot gets retried in case of concurrent modifications
+	maxEvictionConflicts = 3
+)
+
+var (
+	ErrQuotaExceeded = errors.New("maximum number of slots reached")
+)
+
+type QuotaPolicy string
+
+// ParseQuotaPolicy returns the policy with the given name.
+func ParseQuotaPolicy(name string) (QuotaPolicy, error) {
+	switch policy := QuotaPolicy(name); policy {
+	case QuotaPolicyReject, QuotaPolicyEvict:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown quota policy '%s', expected either '%s' or '%s'", name, QuotaPolicyReject, QuotaPolicyEvict)
+	}
+}
+
+// Quota limits the number of slots per user.
+type Quota struct {
+	MaxSlots int         `json:"maxSlots"` // 0 means there is no limit
+	Policy   QuotaPolicy `json:"policy"`
+}
+
+// PlayerStatesPersistorWithQuota enforces the quota when adding slots by appending, restoring them from the
+// trash or saving them. The number of slots is checked before adding a slot, concurrent requests of the same
+// user might therefore exceed the quota by a few slots.
+type PlayerStatesPersistorWithQuota struct {
+	PlayerStatesPersistor
+	quota Quota
+}
+
+func NewPlayerStatesPersistorWithQuota(base PlayerStatesPersistor, quota Quota) PlayerStatesPersistorWithQuota {
+	return PlayerStatesPersistorWithQuota{base, quota}
+}
+
+// Quota returns the enforced quota.
+func (p PlayerStatesPersistorWithQuota) Quota() Quota {
+	return p.quota
+}
+
+func (p PlayerStatesPersistorWithQuota) AppendState(ctx context.Context, userID string, playerState *PlayerState) error {
+	if err := p.makeRoom(ctx, userID); err != nil {
+		return err
+	}
+
+	return p.PlayerStatesPersistor.AppendState(ctx, userID, playerState)
+}
+
+func (p PlayerStatesPersistorWithQuota) RestoreFromTrash(ctx context.Context, userID string, slotID string) error {
+	if err := p.makeRoom(ctx, userID); err != nil {
+		return err
+	}
+
+	return p.PlayerStatesPersistor.RestoreFromTrash(ctx, userID, slotID)
+}
+
+// SavePlayerStates rejects saving more slots than allowed regardless of the policy, as it is not obvious which
+// of the given slots should be evicted.
+func (p PlayerStatesPersistorWithQuota) SavePlayerStates(ctx context.Context, userID string, playerStates []*PlayerState) error {
+	if p.quota.MaxSlots > 0 && len(playerStates) > p.quota.MaxSlots {
+		return fmt.Errorf("%w: %d slots given, %d allowed", ErrQuotaExceeded, len(playerStates), p.quota.MaxSlots)
+	}
+
+	return p.PlayerStatesPersistor.SavePlayerStates(ctx, userID, playerStates)
+}
+
+// makeRoom ensures the user has less slots than allowed, evicting slots depending on the policy.
+func (p PlayerStatesPersistorWithQuota) makeRoom(ctx context.Context, userID string) error {
+	if p.quota.MaxSlots <= 0 {
+		return nil
+	}
+
+	for conflicts := 0; ; {
+		playerStates, revision, err := p.LoadPlayerStates(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		if len(playerStates) < p.quota.MaxSlots {
+			return nil
+		}
+
+		if p.quota.Policy != QuotaPolicyEvict {
+			return ErrQuotaExceeded
+		}
+
+		slot := evictionCandidate(playerStates)
+		if slot == -1 {
+			return fmt.Errorf("%w: all slots are pinned", ErrQuotaExceeded)
+		}
+
+		err = p.RemoveState(ctx, userID, revision, slot)
+		if errors.Is(err, ErrConflict) && conflicts < maxEvictionConflicts {
+			conflicts++
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("could not evict slot: %w", err)
+		}
+	}
+}
+
+// evictionCandidate returns the index of the least recently suspended slot which is not pinned, -1 if all are.
+func evictionCandidate(playerStates []*PlayerState) int {
+	candidate := -1
+
+	for i, playerState := range playerStates {
+		if playerState.Pinned {
+			continue
+		}
+
+		if candidate == -1 || playerState.SuspendedAtTs < playerStates[candidate].SuspendedAtTs {
+			candidate = i
+		}
+	}
+
+	return candidate
+}
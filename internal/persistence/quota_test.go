@@ -0,0 +1,110 @@
+package persistence
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseQuotaPolicy(t *testing.T) {
+	for _, name := range []string{"reject", "evict"} {
+		if policy, err := ParseQuotaPolicy(name); err != nil || string(policy) != name {
+			t.Errorf("expected policy '%s', got '%s' (err: %v)", name, policy, err)
+		}
+	}
+
+	if _, err := ParseQuotaPolicy("drop"); err == nil {
+		t.Error("expected unknown policy to be rejected")
+	}
+}
+
+func TestQuotaRejectsAddingSlots(t *testing.T) {
+	base := NewMemoryPersistor()
+	p := NewPlayerStatesPersistorWithQuota(base, Quota{MaxSlots: 2, Policy: QuotaPolicyReject})
+
+	mustAppend(t, p, "user", fullPlayerState("book 1"), fullPlayerState("book 2"))
+
+	if err := p.AppendState(t.Context(), "user", fullPlayerState("book 3")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+
+	assertStates(t, mustLoad(t, base, "user"), []*PlayerState{fullPlayerState("book 1"), fullPlayerState("book 2")})
+
+	// Other users are not affected
+	mustAppend(t, p, "other_user", fullPlayerState("book 3"))
+}
+
+func TestQuotaEvictsLeastRecentlySuspendedSlot(t *testing.T) {
+	base := NewMemoryPersistor()
+	p := NewPlayerStatesPersistorWithQuota(base, Quota{MaxSlots: 3, Policy: QuotaPolicyEvict})
+
+	oldest, older, newest := fullPlayerState("book 1"), fullPlayerState("book 2"), fullPlayerState("book 3")
+	oldest.SuspendedAtTs, older.SuspendedAtTs, newest.SuspendedAtTs = 1, 2, 3
+	mustSave(t, base, "user", []*PlayerState{newest, oldest, older})
+
+	// Pinned slots are never evicted
+	_, revision := mustLoadWithRevision(t, base, "user")
+	if err := base.SetPinned(t.Context(), "user", revision, 1, true); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	mustAppend(t, p, "user", fullPlayerState("book 4"))
+
+	pinned := *oldest
+	pinned.Pinned = true
+	assertStates(t, mustLoad(t, base, "user"), []*PlayerState{newest, &pinned, fullPlayerState("book 4")})
+	assertTrash(t, mustLoadTrash(t, base, "user"), []*PlayerState{older})
+}
+
+func TestQuotaRejectsAddingSlotsIfAllArePinned(t *testing.T) {
+	base := NewMemoryPersistor()
+	p := NewPlayerStatesPersistorWithQuota(base, Quota{MaxSlots: 1, Policy: QuotaPolicyEvict})
+
+	mustAppend(t, p, "user", fullPlayerState("book 1"))
+	_, revision := mustLoadWithRevision(t, base, "user")
+	if err := base.SetPinned(t.Context(), "user", revision, 0, true); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := p.AppendState(t.Context(), "user", fullPlayerState("book 2")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+}
+
+func TestQuotaMakesRoomForRestoredSlots(t *testing.T) {
+	base := NewMemoryPersistor()
+	p := NewPlayerStatesPersistorWithQuota(base, Quota{MaxSlots: 1, Policy: QuotaPolicyEvict})
+
+	mustAppend(t, p, "user", fullPlayerState("book 1"))
+	mustRemove(t, base, "user", 0)
+	mustAppend(t, p, "user", fullPlayerState("book 2"))
+
+	trashed := mustLoadTrash(t, base, "user")
+	if err := p.RestoreFromTrash(t.Context(), "user", trashed[0].ID); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if loaded := mustLoad(t, base, "user"); len(loaded) != 1 || loaded[0].AlbumName != "book 1" {
+		t.Fatalf("expected only the restored slot, got %#v", loaded)
+	}
+}
+
+func TestQuotaRejectsSavingTooManySlots(t *testing.T) {
+	p := NewPlayerStatesPersistorWithQuota(NewMemoryPersistor(), Quota{MaxSlots: 1, Policy: QuotaPolicyEvict})
+
+	err := p.SavePlayerStates(t.Context(), "user", []*PlayerState{fullPlayerState("book 1"), fullPlayerState("book 2")})
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded, got %v", err)
+	}
+}
+
+func TestQuotaWithoutLimit(t *testing.T) {
+	p := NewPlayerStatesPersistorWithQuota(NewMemoryPersistor(), Quota{Policy: QuotaPolicyReject})
+
+	for range 5 {
+		mustAppend(t, p, "user", fullPlayerState("book"))
+	}
+
+	if loaded := mustLoad(t, p, "user"); len(loaded) != 5 {
+		t.Fatalf("expected 5 slots, got %d", len(loaded))
+	}
+}
@@ -0,0 +1,895 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib" // registers the "pgx" driver
+	"github.com/rs/zerolog/log"
+	_ "modernc.org/sqlite" // registers the "sqlite" driver
+)
+
+const (
+	dialectSQLite   = "sqlite"
+	dialectPostgres = "postgres"
+
+	playerStateColumns = `id, playback_context_uri, playback_item_uri, link_to_context, context_type, playlist_name,
+	album_art_large_url, album_art_medium_url, track_name, album_name, artist_name, narrator_name,
+	track_index, total_tracks, progress, duration, shuffle_activated, suspended_at_ts, pinned`
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// SQLPersistor implements PlayerStatesPersistor on top of a relational database.
+// Every player state is stored in its own row, all statements are written using '?' as placeholder
+// and get rebound for the dialect in use.
+type SQLPersistor struct {
+	db      *sql.DB
+	dialect string
+	userIDs *UserIDHasher
+}
+
+// connectSQLite opens an embedded SQLite database.
+// It is meant for self-hosting Cassette on a single node without the need to run a database server.
+func connectSQLite(connectionString string, userIDs *UserIDHasher) (*SQLPersistor, error) {
+	// url.Parse would treat the first path segment of a relative path as host, so we simply strip the scheme
+	dbPath := strings.TrimPrefix(connectionString, "sqlite://")
+	if dbPath == "" {
+		return nil, fmt.Errorf("given path of database file is empty '%s'", connectionString)
+	}
+
+	db, err := sql.Open("sqlite", "file:"+dbPath+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err == nil {
+		err = db.Ping()
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database at '%s': %w", dbPath, err)
+	}
+
+	// SQLite only allows one writer at a time anyway, serializing access within this process
+	// saves us from having to handle SQLITE_BUSY.
+	db.SetMaxOpenConns(1)
+
+	p := &SQLPersistor{db, dialectSQLite, userIDs}
+
+	if err := p.migrateSchema(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	log.Info().Msgf("Opened SQLite backend! Will use '%s' as db.", dbPath)
+
+	return p, nil
+}
+
+func connectPostgres(connectionString string, userIDs *UserIDHasher) (*SQLPersistor, error) {
+	db, err := sql.Open("pgx", connectionString)
+	if err == nil {
+		err = db.Ping()
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to PostgreSQL at '%s': %w", connectionString, err)
+	}
+
+	p := &SQLPersistor{db, dialectPostgres, userIDs}
+
+	if err := p.migrateSchema(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	log.Info().Msg("Connected to PostgreSQL backend!")
+
+	return p, nil
+}
+
+func (p *SQLPersistor) LoadPlayerStates(ctx context.Context, userID string) ([]*PlayerState, int64, error) {
+	var item *persistenceItem
+
+	err := p.inTx(ctx, func(tx *sql.Tx) error {
+		hashedUserID, err := p.hashedUserID(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+
+		item, err = p.loadItem(ctx, tx, hashedUserID)
+		if err != nil {
+			return err
+		}
+
+		if time.Since(time.Unix(item.LastActivityTs, 0)) > activityResolution {
+			// Unknown users have no record, so nothing gets updated for them
+			_, err = tx.ExecContext(ctx, p.rebind(`UPDATE users SET last_activity_ts = ? WHERE id = ?`), time.Now().Unix(), hashedUserID)
+		}
+
+		return err
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not load player states from db: %w", err)
+	}
+
+	return item.PlayerStates, item.Revision, nil
+}
+
+func (p *SQLPersistor) SavePlayerStates(ctx context.Context, userID string, playerStates []*PlayerState) error {
+	return p.inTx(ctx, func(tx *sql.Tx) error {
+		hashedUserID, err := p.hashedUserID(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+
+		if err := p.upsertUser(ctx, tx, hashedUserID); err != nil {
+			return err
+		}
+
+		if err := p.deletePlayerStates(ctx, tx, hashedUserID); err != nil {
+			return err
+		}
+
+		for i, s := range withSlotIDs(playerStates) {
+			if err := p.insertPlayerState(ctx, tx, hashedUserID, i, s); err != nil {
+				return fmt.Errorf("could not insert player state: %w", err)
+			}
+		}
+
+		return nil
+	})
+}
+
+func (p *SQLPersistor) AppendState(ctx context.Context, userID string, playerState *PlayerState) error {
+	return p.inTx(ctx, func(tx *sql.Tx) error {
+		hashedUserID, err := p.hashedUserID(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+
+		// Upserting the user first locks its row, so concurrent appends cannot pick the same position
+		if err := p.upsertUser(ctx, tx, hashedUserID); err != nil {
+			return err
+		}
+
+		var position int
+		err = tx.QueryRowContext(ctx, p.rebind(`SELECT COALESCE(MAX(position) + 1, 0) FROM player_states WHERE user_id = ?`), hashedUserID).Scan(&position)
+		if err != nil {
+			return fmt.Errorf("could not determine position of new player state: %w", err)
+		}
+
+		if err := p.insertPlayerState(ctx, tx, hashedUserID, position, withSlotIDs([]*PlayerState{playerState})[0]); err != nil {
+			return fmt.Errorf("could not insert player state: %w", err)
+		}
+
+		return nil
+	})
+}
+
+func (p *SQLPersistor) ReplaceState(ctx context.Context, userID string, revision int64, slot int, playerState *PlayerState) error {
+	return p.inTx(ctx, func(tx *sql.Tx) error {
+		hashedUserID, err := p.hashedUserID(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+
+		item, err := p.loadCheckedItem(ctx, tx, hashedUserID, revision, slot)
+		if err != nil {
+			return err
+		}
+
+		if err := p.deleteSlot(ctx, tx, hashedUserID, slot, item.PlayerStates[slot].ID); err != nil {
+			return err
+		}
+
+		if err := p.insertPlayerState(ctx, tx, hashedUserID, slot, replacementOf(item.PlayerStates[slot], playerState)); err != nil {
+			return fmt.Errorf("could not insert player state: %w", err)
+		}
+
+		return nil
+	})
+}
+
+func (p *SQLPersistor) RemoveState(ctx context.Context, userID string, revision int64, slot int) error {
+	return p.inTx(ctx, func(tx *sql.Tx) error {
+		hashedUserID, err := p.hashedUserID(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+
+		item, err := p.loadCheckedItem(ctx, tx, hashedUserID, revision, slot)
+		if err != nil {
+			return err
+		}
+
+		// The history of the slot stays in place, it gets restored along with the slot
+		if _, err := tx.ExecContext(ctx, p.rebind(`DELETE FROM player_states WHERE user_id = ? AND position = ?`), hashedUserID, slot); err != nil {
+			return fmt.Errorf("could not delete player state: %w", err)
+		}
+
+		if err := p.insertTrashed(ctx, tx, hashedUserID, trashed(item.PlayerStates[slot])); err != nil {
+			return fmt.Errorf("could not move player state to trash: %w", err)
+		}
+
+		// Uniqueness of (user_id, position) gets checked for every row, shifting the following slots directly
+		// could therefore collide depending on the order rows get updated in. Negating the positions first avoids that.
+		_, err = tx.ExecContext(ctx, p.rebind(`UPDATE player_states SET position = -position WHERE user_id = ? AND position > ?`), hashedUserID, slot)
+		if err == nil {
+			_, err = tx.ExecContext(ctx, p.rebind(`UPDATE player_states SET position = -position - 1 WHERE user_id = ? AND position < 0`), hashedUserID)
+		}
+		if err != nil {
+			return fmt.Errorf("could not shift following player states: %w", err)
+		}
+
+		return nil
+	})
+}
+
+func (p *SQLPersistor) MoveState(ctx context.Context, userID string, revision int64, from, to int) error {
+	return p.inTx(ctx, func(tx *sql.Tx) error {
+		hashedUserID, err := p.hashedUserID(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+
+		item, err := p.loadCheckedItem(ctx, tx, hashedUserID, revision, from)
+		if err != nil {
+			return err
+		}
+
+		playerStates, err := moveSlot(item.PlayerStates, from, to)
+		if err != nil {
+			return err
+		}
+
+		if err := p.deletePlayerStates(ctx, tx, hashedUserID); err != nil {
+			return err
+		}
+
+		for i, s := range playerStates {
+			if err := p.insertPlayerState(ctx, tx, hashedUserID, i, s); err != nil {
+				return fmt.Errorf("could not insert player state: %w", err)
+			}
+		}
+
+		return nil
+	})
+}
+
+func (p *SQLPersistor) SetPinned(ctx context.Context, userID string, revision int64, slot int, pinned bool) error {
+	return p.inTx(ctx, func(tx *sql.Tx) error {
+		hashedUserID, err := p.hashedUserID(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+
+		if _, err := p.loadCheckedItem(ctx, tx, hashedUserID, revision, slot); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, p.rebind(`UPDATE player_states SET pinned = ? WHERE user_id = ? AND position = ?`), pinned, hashedUserID, slot); err != nil {
+			return fmt.Errorf("could not update player state: %w", err)
+		}
+
+		return nil
+	})
+}
+
+func (p *SQLPersistor) LoadTrash(ctx context.Context, userID string) ([]*PlayerState, error) {
+	var trash []*PlayerState
+
+	err := p.inTx(ctx, func(tx *sql.Tx) error {
+		hashedUserID, err := p.hashedUserID(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+
+		trash, err = p.loadTrash(ctx, tx, hashedUserID)
+
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not load trash from db: %w", err)
+	}
+
+	return trash, nil
+}
+
+func (p *SQLPersistor) RestoreFromTrash(ctx context.Context, userID string, slotID string) error {
+	return p.inTx(ctx, func(tx *sql.Tx) error {
+		hashedUserID, err := p.hashedUserID(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+
+		trash, err := p.loadTrash(ctx, tx, hashedUserID)
+		if err != nil {
+			return fmt.Errorf("could not load trash from db: %w", err)
+		}
+
+		index := trashIndexOf(trash, slotID)
+		if index == -1 {
+			return ErrNotInTrash
+		}
+
+		res, err := tx.ExecContext(ctx, p.rebind(`DELETE FROM trash WHERE user_id = ? AND id = ?`), hashedUserID, slotID)
+		if err != nil {
+			return fmt.Errorf("could not delete player state from trash: %w", err)
+		}
+
+		// A concurrent transaction might have restored the slot in the meantime
+		if deleted, err := res.RowsAffected(); err != nil || deleted == 0 {
+			return ErrNotInTrash
+		}
+
+		if err := p.upsertUser(ctx, tx, hashedUserID); err != nil {
+			return err
+		}
+
+		var position int
+		err = tx.QueryRowContext(ctx, p.rebind(`SELECT COALESCE(MAX(position) + 1, 0) FROM player_states WHERE user_id = ?`), hashedUserID).Scan(&position)
+		if err != nil {
+			return fmt.Errorf("could not determine position of restored player state: %w", err)
+		}
+
+		// The history of the slot has been kept in place, so only the slot itself gets inserted
+		if err := p.insertRow(ctx, tx, "player_states", hashedUserID, position, restored(trash[index])); err != nil {
+			return fmt.Errorf("could not insert player state: %w", err)
+		}
+
+		return nil
+	})
+}
+
+func (p *SQLPersistor) PurgeTrash(ctx context.Context, removedBefore time.Time) error {
+	return p.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, p.rebind(`DELETE FROM slot_history WHERE (user_id, id) IN (SELECT user_id, id FROM trash WHERE removed_at_ts < ?)`), removedBefore.Unix())
+		if err == nil {
+			_, err = tx.ExecContext(ctx, p.rebind(`DELETE FROM trash WHERE removed_at_ts < ?`), removedBefore.Unix())
+		}
+		if err != nil {
+			return fmt.Errorf("could not purge trash: %w", err)
+		}
+
+		return nil
+	})
+}
+
+func (p *SQLPersistor) CountInactiveUsers(ctx context.Context, inactiveSince time.Time) (int64, error) {
+	var count int64
+
+	err := p.db.QueryRowContext(ctx, p.rebind(`SELECT COUNT(*) FROM users WHERE last_activity_ts < ?`), inactiveSince.Unix()).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("could not count inactive users: %w", err)
+	}
+
+	return count, nil
+}
+
+func (p *SQLPersistor) PurgeInactiveUsers(ctx context.Context, inactiveSince time.Time) (int64, error) {
+	var purged int64
+
+	err := p.inTx(ctx, func(tx *sql.Tx) error {
+		for _, table := range []string{"trash", "slot_history", "player_states"} {
+			_, err := tx.ExecContext(ctx, p.rebind(`DELETE FROM `+table+` WHERE user_id IN (SELECT id FROM users WHERE last_activity_ts < ?)`), inactiveSince.Unix())
+			if err != nil {
+				return err
+			}
+		}
+
+		res, err := tx.ExecContext(ctx, p.rebind(`DELETE FROM users WHERE last_activity_ts < ?`), inactiveSince.Unix())
+		if err != nil {
+			return err
+		}
+
+		purged, err = res.RowsAffected()
+
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not purge inactive users: %w", err)
+	}
+
+	return purged, nil
+}
+
+func (p *SQLPersistor) FetchJSONDump(ctx context.Context, userID string) ([]byte, error) {
+	var item persistenceItem
+
+	err := p.inTx(ctx, func(tx *sql.Tx) error {
+		hashedUserID, err := p.hashedUserID(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+
+		item.UserID = hashedUserID
+
+		err = tx.QueryRowContext(ctx, p.rebind(`SELECT version, last_activity_ts FROM users WHERE id = ?`), hashedUserID).Scan(&item.Version, &item.LastActivityTs)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrUserNotFound
+			}
+
+			return fmt.Errorf("could not load user record from db: %w", err)
+		}
+
+		item.PlayerStates, err = p.loadPlayerStates(ctx, tx, hashedUserID)
+		if err != nil {
+			return fmt.Errorf("could not load previous player states from db: %w", err)
+		}
+
+		item.Trash, err = p.loadTrash(ctx, tx, hashedUserID)
+		if err != nil {
+			return fmt.Errorf("could not load trash from db: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return marshalDump(&item)
+}
+
+func (p *SQLPersistor) DeleteUserRecord(ctx context.Context, userID string) error {
+	return p.inTx(ctx, func(tx *sql.Tx) error {
+		// A record might still be stored under the legacy hash as well
+		var deleted int64
+		for _, hashedUserID := range p.userIDs.candidates(userID) {
+			_, err := tx.ExecContext(ctx, p.rebind(`DELETE FROM trash WHERE user_id = ?`), hashedUserID)
+			if err == nil {
+				err = p.deletePlayerStates(ctx, tx, hashedUserID)
+			}
+			if err != nil {
+				return fmt.Errorf("could not delete user record: %w", err)
+			}
+
+			res, err := tx.ExecContext(ctx, p.rebind(`DELETE FROM users WHERE id = ?`), hashedUserID)
+			if err != nil {
+				return fmt.Errorf("could not delete user record: %w", err)
+			}
+
+			affected, err := res.RowsAffected()
+			if err != nil {
+				return fmt.Errorf("could not delete user record: %w", err)
+			}
+			deleted += affected
+		}
+
+		if deleted == 0 {
+			return ErrUserNotFound
+		}
+
+		return nil
+	})
+}
+
+func (p *SQLPersistor) LegacyUserIDs(ctx context.Context) (int64, error) {
+	var count int64
+
+	err := p.db.QueryRowContext(ctx, p.rebind(`SELECT COUNT(*) FROM users WHERE id NOT LIKE ?`), pepperedUserIDPrefix+"%").Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("could not count records stored under legacy user IDs: %w", err)
+	}
+
+	return count, nil
+}
+
+func (p *SQLPersistor) RekeyUserIDs(ctx context.Context) (int, error) {
+	if p.userIDs == nil {
+		return 0, ErrNoPepper
+	}
+
+	rows, err := p.db.QueryContext(ctx, p.rebind(`SELECT id FROM users WHERE id NOT LIKE ?`), pepperedUserIDPrefix+"%")
+	if err != nil {
+		return 0, fmt.Errorf("could not query records stored under legacy user IDs: %w", err)
+	}
+
+	var legacyIDs []string
+	for rows.Next() {
+		var legacyID string
+		if err := rows.Scan(&legacyID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("could not query records stored under legacy user IDs: %w", err)
+		}
+
+		legacyIDs = append(legacyIDs, legacyID)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("could not query records stored under legacy user IDs: %w", err)
+	}
+
+	rekeyed := 0
+
+	for _, legacyID := range legacyIDs {
+		moved := false
+
+		err := p.inTx(ctx, func(tx *sql.Tx) error {
+			var err error
+			moved, err = p.rekeyUser(ctx, tx, legacyID)
+
+			return err
+		})
+		if err != nil {
+			return rekeyed, err
+		}
+
+		if moved {
+			rekeyed++
+		}
+	}
+
+	return rekeyed, nil
+}
+
+// rekeyUser moves the record stored under the given legacy ID to the peppered one, unless there already is one.
+func (p *SQLPersistor) rekeyUser(ctx context.Context, tx *sql.Tx, legacyID string) (bool, error) {
+	pepperedID := p.userIDs.rekeyed(legacyID)
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, p.rebind(`SELECT EXISTS (SELECT 1 FROM users WHERE id = ?)`), pepperedID).Scan(&exists); err != nil {
+		return false, fmt.Errorf("could not look up record of user: %w", err)
+	}
+
+	if exists {
+		log.Warn().Str("legacyID", legacyID).Msg("There already is a record stored under the peppered user ID, leaving the legacy one in place.")
+		return false, nil
+	}
+
+	// Incrementing the revision locks the record, concurrent modifications wait for the record to be moved
+	res, err := tx.ExecContext(ctx, p.rebind(`UPDATE users SET revision = revision + 1 WHERE id = ?`), legacyID)
+	if err != nil {
+		return false, fmt.Errorf("could not update revision: %w", err)
+	}
+
+	// Deleted in the meantime, nothing to rekey
+	if updated, err := res.RowsAffected(); err != nil || updated == 0 {
+		return false, err
+	}
+
+	// The rows referencing the user have to be moved before the user itself can be deleted
+	if _, err := tx.ExecContext(ctx, p.rebind(`INSERT INTO users (id, version, revision, last_activity_ts) SELECT ?, version, revision, last_activity_ts FROM users WHERE id = ?`), pepperedID, legacyID); err != nil {
+		return false, fmt.Errorf("could not insert record under peppered user ID: %w", err)
+	}
+
+	for _, table := range []string{"player_states", "slot_history", "trash"} {
+		if _, err := tx.ExecContext(ctx, p.rebind(`UPDATE `+table+` SET user_id = ? WHERE user_id = ?`), pepperedID, legacyID); err != nil {
+			return false, fmt.Errorf("could not move rows of '%s' to peppered user ID: %w", table, err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, p.rebind(`DELETE FROM users WHERE id = ?`), legacyID); err != nil {
+		return false, fmt.Errorf("could not delete record stored under legacy user ID: %w", err)
+	}
+
+	return true, nil
+}
+
+// hashedUserID returns the ID the record of the given user is stored under.
+func (p *SQLPersistor) hashedUserID(ctx context.Context, tx *sql.Tx, userID string) (string, error) {
+	hashedUserID, err := p.userIDs.resolve(ctx, userID, func(ctx context.Context, hashedUserID string) (bool, error) {
+		var found bool
+		err := tx.QueryRowContext(ctx, p.rebind(`SELECT EXISTS (SELECT 1 FROM users WHERE id = ?)`), hashedUserID).Scan(&found)
+
+		return found, err
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not look up record of user: %w", err)
+	}
+
+	return hashedUserID, nil
+}
+
+// loadItem loads the revision and the player states of the given user. Unknown users have no states and revision 0.
+func (p *SQLPersistor) loadItem(ctx context.Context, tx *sql.Tx, hashedUserID string) (*persistenceItem, error) {
+	item := &persistenceItem{UserID: hashedUserID, Version: currentVersion}
+
+	err := tx.QueryRowContext(ctx, p.rebind(`SELECT revision, last_activity_ts FROM users WHERE id = ?`), hashedUserID).Scan(&item.Revision, &item.LastActivityTs)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	item.PlayerStates, err = p.loadPlayerStates(ctx, tx, hashedUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
+
+// loadCheckedItem loads the player states of the given user and ensures they are at the expected revision
+// and contain the given slot. The revision gets incremented right away, in case another transaction did so
+// concurrently this fails with ErrConflict.
+func (p *SQLPersistor) loadCheckedItem(ctx context.Context, tx *sql.Tx, hashedUserID string, revision int64, slot int) (*persistenceItem, error) {
+	item, err := p.loadItem(ctx, tx, hashedUserID)
+	if err != nil {
+		return nil, fmt.Errorf("could not load player states from db: %w", err)
+	}
+
+	if err := checkSlot(item, revision, slot); err != nil {
+		return nil, err
+	}
+
+	res, err := tx.ExecContext(ctx, p.rebind(`UPDATE users SET revision = revision + 1, last_activity_ts = ? WHERE id = ? AND revision = ?`), time.Now().Unix(), hashedUserID, revision)
+	if err != nil {
+		return nil, fmt.Errorf("could not update revision: %w", err)
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("could not update revision: %w", err)
+	}
+
+	if updated == 0 {
+		return nil, ErrConflict
+	}
+
+	return item, nil
+}
+
+// upsertUser creates the record of the given user or increments its revision, recording the activity of the user.
+func (p *SQLPersistor) upsertUser(ctx context.Context, tx *sql.Tx, hashedUserID string) error {
+	_, err := tx.ExecContext(ctx, p.rebind(`INSERT INTO users (id, version, revision, last_activity_ts) VALUES (?, ?, 1, ?)
+		ON CONFLICT (id) DO UPDATE SET version = excluded.version, revision = users.revision + 1, last_activity_ts = excluded.last_activity_ts`), hashedUserID, currentVersion, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("could not upsert user record: %w", err)
+	}
+
+	return nil
+}
+
+// loadPlayerStates loads the player states of the given user including their history.
+func (p *SQLPersistor) loadPlayerStates(ctx context.Context, tx *sql.Tx, hashedUserID string) ([]*PlayerState, error) {
+	playerStates, err := p.queryPlayerStates(ctx, tx, false, `SELECT `+playerStateColumns+` FROM player_states WHERE user_id = ? ORDER BY position`, hashedUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return playerStates, p.attachHistory(ctx, tx, hashedUserID, playerStates)
+}
+
+// loadTrash loads the removed slots of the given user including their history.
+func (p *SQLPersistor) loadTrash(ctx context.Context, tx *sql.Tx, hashedUserID string) ([]*PlayerState, error) {
+	trash, err := p.queryPlayerStates(ctx, tx, true, `SELECT `+playerStateColumns+`, removed_at_ts FROM trash WHERE user_id = ? ORDER BY position DESC`, hashedUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return trash, p.attachHistory(ctx, tx, hashedUserID, trash)
+}
+
+// attachHistory loads the history of the given slots of the user.
+func (p *SQLPersistor) attachHistory(ctx context.Context, tx *sql.Tx, hashedUserID string, playerStates []*PlayerState) error {
+	snapshots, err := p.queryPlayerStates(ctx, tx, false, `SELECT `+playerStateColumns+` FROM slot_history WHERE user_id = ? ORDER BY id, position`, hashedUserID)
+	if err != nil {
+		return err
+	}
+
+	slotsByID := make(map[string]*PlayerState, len(playerStates))
+	for _, s := range playerStates {
+		slotsByID[s.ID] = s
+	}
+
+	for _, snapshot := range snapshots {
+		if slot, ok := slotsByID[snapshot.ID]; ok {
+			slot.History = append(slot.History, snapshot)
+		}
+	}
+
+	return nil
+}
+
+// queryPlayerStates scans the rows selected by the given query, which has to select playerStateColumns
+// followed by 'removed_at_ts' in case withRemovedAt is set.
+func (p *SQLPersistor) queryPlayerStates(ctx context.Context, tx *sql.Tx, withRemovedAt bool, query string, args ...interface{}) ([]*PlayerState, error) {
+	rows, err := tx.QueryContext(ctx, p.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	playerStates := make([]*PlayerState, 0)
+
+	for rows.Next() {
+		s := &PlayerState{}
+
+		dest := []interface{}{
+			&s.ID, &s.PlaybackContextURI, &s.PlaybackItemURI, &s.LinkToContext, &s.ContextType, &s.PlaylistName,
+			&s.AlbumArtLargeURL, &s.AlbumArtMediumURL, &s.TrackName, &s.AlbumName, &s.ArtistName, &s.NarratorName,
+			&s.TrackIndex, &s.TotalTracks, &s.Progress, &s.Duration, &s.ShuffleActivated, &s.SuspendedAtTs, &s.Pinned,
+		}
+		if withRemovedAt {
+			dest = append(dest, &s.RemovedAtTs)
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		playerStates = append(playerStates, s)
+	}
+
+	return playerStates, rows.Err()
+}
+
+// insertPlayerState inserts the given state at the given position along with its history.
+func (p *SQLPersistor) insertPlayerState(ctx context.Context, tx *sql.Tx, hashedUserID string, position int, s *PlayerState) error {
+	if err := p.insertRow(ctx, tx, "player_states", hashedUserID, position, s); err != nil {
+		return err
+	}
+
+	for i, snapshot := range s.History {
+		// Snapshots are associated with their slot by the slot's ID
+		snapshotCopy := *snapshot
+		snapshotCopy.ID = s.ID
+
+		if err := p.insertRow(ctx, tx, "slot_history", hashedUserID, i, &snapshotCopy); err != nil {
+			return fmt.Errorf("could not insert history of player state: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (p *SQLPersistor) insertRow(ctx context.Context, tx *sql.Tx, table, hashedUserID string, position int, s *PlayerState) error {
+	_, err := tx.ExecContext(ctx, p.rebind(`INSERT INTO `+table+` (user_id, position, `+playerStateColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`), hashedUserID, position,
+		s.ID, s.PlaybackContextURI, s.PlaybackItemURI, s.LinkToContext, s.ContextType, s.PlaylistName,
+		s.AlbumArtLargeURL, s.AlbumArtMediumURL, s.TrackName, s.AlbumName, s.ArtistName, s.NarratorName,
+		s.TrackIndex, s.TotalTracks, s.Progress, s.Duration, s.ShuffleActivated, s.SuspendedAtTs, s.Pinned)
+
+	return err
+}
+
+// insertTrashed inserts the given removed slot into the trash. Its history is expected to be in place already.
+// Slots might get removed within the same second, so their order is kept by a position of its own.
+func (p *SQLPersistor) insertTrashed(ctx context.Context, tx *sql.Tx, hashedUserID string, s *PlayerState) error {
+	_, err := tx.ExecContext(ctx, p.rebind(`INSERT INTO trash (user_id, position, `+playerStateColumns+`, removed_at_ts)
+		VALUES (?, (SELECT COALESCE(MAX(position) + 1, 0) FROM trash WHERE user_id = ?), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`), hashedUserID, hashedUserID,
+		s.ID, s.PlaybackContextURI, s.PlaybackItemURI, s.LinkToContext, s.ContextType, s.PlaylistName,
+		s.AlbumArtLargeURL, s.AlbumArtMediumURL, s.TrackName, s.AlbumName, s.ArtistName, s.NarratorName,
+		s.TrackIndex, s.TotalTracks, s.Progress, s.Duration, s.ShuffleActivated, s.SuspendedAtTs, s.Pinned, s.RemovedAtTs)
+
+	return err
+}
+
+// deleteSlot deletes the slot at the given position along with its history. Following slots are not shifted.
+func (p *SQLPersistor) deleteSlot(ctx context.Context, tx *sql.Tx, hashedUserID string, position int, slotID string) error {
+	_, err := tx.ExecContext(ctx, p.rebind(`DELETE FROM player_states WHERE user_id = ? AND position = ?`), hashedUserID, position)
+	if err == nil {
+		_, err = tx.ExecContext(ctx, p.rebind(`DELETE FROM slot_history WHERE user_id = ? AND id = ?`), hashedUserID, slotID)
+	}
+	if err != nil {
+		return fmt.Errorf("could not delete player state: %w", err)
+	}
+
+	return nil
+}
+
+// deletePlayerStates deletes all player states of the given user along with their history.
+// The history of slots in the trash is kept.
+func (p *SQLPersistor) deletePlayerStates(ctx context.Context, tx *sql.Tx, hashedUserID string) error {
+	_, err := tx.ExecContext(ctx, p.rebind(`DELETE FROM player_states WHERE user_id = ?`), hashedUserID)
+	if err == nil {
+		_, err = tx.ExecContext(ctx, p.rebind(`DELETE FROM slot_history WHERE user_id = ? AND id NOT IN (SELECT id FROM trash WHERE user_id = ?)`), hashedUserID, hashedUserID)
+	}
+	if err != nil {
+		return fmt.Errorf("could not delete previous player states: %w", err)
+	}
+
+	return nil
+}
+
+// inTx runs fn in a transaction which gets committed in case fn does not return an error.
+func (p *SQLPersistor) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// rebind replaces the '?' placeholders of the given statement with the ones used by the dialect.
+func (p *SQLPersistor) rebind(stmt string) string {
+	if p.dialect != dialectPostgres {
+		return stmt
+	}
+
+	var builder strings.Builder
+	n := 0
+
+	for _, r := range stmt {
+		if r == '?' {
+			n++
+			builder.WriteString("$" + strconv.Itoa(n))
+
+			continue
+		}
+
+		builder.WriteRune(r)
+	}
+
+	return builder.String()
+}
+
+// migrateSchema applies all migrations found in 'migrations/<dialect>' not applied yet.
+// Files have to be named '<version>_<description>.sql', every file gets applied in its own transaction.
+func (p *SQLPersistor) migrateSchema(ctx context.Context) error {
+	_, err := p.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)`)
+	if err != nil {
+		return fmt.Errorf("could not create schema version table: %w", err)
+	}
+
+	var schemaVersion int
+	err = p.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&schemaVersion)
+	if err != nil {
+		return fmt.Errorf("could not determine schema version: %w", err)
+	}
+
+	dir := path.Join("migrations", p.dialect)
+
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return fmt.Errorf("could not read migrations: %w", err)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	latestVersion := 0
+
+	for _, entry := range entries {
+		version, err := strconv.Atoi(strings.SplitN(entry.Name(), "_", 2)[0])
+		if err != nil {
+			return fmt.Errorf("migration '%s' is not prefixed with a version: %w", entry.Name(), err)
+		}
+
+		latestVersion = version
+
+		if version <= schemaVersion {
+			continue
+		}
+
+		script, err := migrationFiles.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("could not read migration '%s': %w", entry.Name(), err)
+		}
+
+		err = p.inTx(ctx, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, string(script)); err != nil {
+				return err
+			}
+
+			_, err := tx.ExecContext(ctx, p.rebind(`INSERT INTO schema_version (version) VALUES (?)`), version)
+
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("could not apply migration '%s': %w", entry.Name(), err)
+		}
+
+		log.Info().Int("version", version).Msgf("Applied schema migration '%s'.", entry.Name())
+	}
+
+	if schemaVersion > latestVersion {
+		return fmt.Errorf("database schema is at version %d but this binary only knows up to version %d", schemaVersion, latestVersion)
+	}
+
+	return nil
+}
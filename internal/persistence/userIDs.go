@@ -0,0 +1,116 @@
+package persistence
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// Peppered IDs are distinguishable from legacy ones by this prefix
+	pepperedUserIDPrefix = "hmac:"
+	// Peppers have to be at least this long
+	minPepperLength = 32
+)
+
+var (
+	ErrNoPepper = errors.New("no pepper for hashing user IDs is configured")
+)
+
+// UserIDHasher pseudonymises the Spotify user IDs the records get stored under using an HMAC keyed with a secret
+// pepper. Spotify user IDs are public and often guessable, a plain hash of them could be reverted by simply hashing
+// a list of user names.
+// The HMAC is computed over the legacy hash instead of the user ID itself, this way existing records can be rekeyed
+// without knowing the user IDs they belong to.
+// While accepting legacy IDs records stored under the legacy hash of a user ID are still found, which costs
+// another lookup for users whose record has not been rekeyed yet.
+// A nil *UserIDHasher stores records under the legacy hash.
+type UserIDHasher struct {
+	pepper       []byte
+	acceptLegacy bool
+}
+
+func NewUserIDHasher(pepper string, acceptLegacy bool) (*UserIDHasher, error) {
+	if len(pepper) < minPepperLength {
+		return nil, fmt.Errorf("pepper has to be at least %d characters long", minPepperLength)
+	}
+
+	return &UserIDHasher{[]byte(pepper), acceptLegacy}, nil
+}
+
+// hash returns the ID new records of the given user get stored under.
+func (h *UserIDHasher) hash(userID string) string {
+	if h == nil {
+		return legacyHashUserID(userID)
+	}
+
+	return h.rekeyed(legacyHashUserID(userID))
+}
+
+// rekeyed returns the peppered ID for the given legacy one.
+func (h *UserIDHasher) rekeyed(legacyID string) string {
+	mac := hmac.New(sha256.New, h.pepper)
+	mac.Write([]byte(legacyID))
+
+	return pepperedUserIDPrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// resolve returns the ID the record of the given user is stored under. While accepting legacy IDs this is the
+// legacy hash in case there is only a record stored under the latter, otherwise it is the same as hash.
+func (h *UserIDHasher) resolve(ctx context.Context, userID string, exists func(ctx context.Context, hashedUserID string) (bool, error)) (string, error) {
+	hashedUserID := h.hash(userID)
+	if h == nil || !h.acceptLegacy {
+		return hashedUserID, nil
+	}
+
+	found, err := exists(ctx, hashedUserID)
+	if err != nil || found {
+		return hashedUserID, err
+	}
+
+	legacyID := legacyHashUserID(userID)
+
+	found, err = exists(ctx, legacyID)
+	if err != nil {
+		return "", err
+	}
+
+	if found {
+		return legacyID, nil
+	}
+
+	return hashedUserID, nil
+}
+
+// candidates returns all IDs records of the given user might be stored under, the one of new records first.
+// Both records might exist while accepting legacy IDs, e.g. in case rekeying failed to delete the legacy one.
+func (h *UserIDHasher) candidates(userID string) []string {
+	if h == nil || !h.acceptLegacy {
+		return []string{h.hash(userID)}
+	}
+
+	return []string{h.hash(userID), legacyHashUserID(userID)}
+}
+
+func isLegacyUserID(hashedUserID string) bool {
+	return !strings.HasPrefix(hashedUserID, pepperedUserIDPrefix)
+}
+
+func legacyHashUserID(userID string) string {
+	hash := sha256.Sum256([]byte(userID))
+	return fmt.Sprintf("%X", hash)
+}
+
+// UserIDRekeyer is implemented by backends able to move records stored under the legacy hash of a user ID
+// to the peppered one.
+type UserIDRekeyer interface {
+	// LegacyUserIDs counts the records still stored under the legacy hash of a user ID.
+	LegacyUserIDs(ctx context.Context) (int64, error)
+	// RekeyUserIDs moves all records stored under the legacy hash of a user ID to the peppered one and reports
+	// how many got moved. Records of users also having a record under the peppered ID are left in place.
+	RekeyUserIDs(ctx context.Context) (int, error)
+}
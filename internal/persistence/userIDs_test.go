@@ -0,0 +1,32 @@
+package persistence
+
+import "testing"
+
+func TestNewUserIDHasherRejectsShortPepper(t *testing.T) {
+	if _, err := NewUserIDHasher("too short", false); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestPepperedUserIDsDependOnPepper(t *testing.T) {
+	userIDs := mustNewUserIDHasher(t, false)
+	otherUserIDs, err := NewUserIDHasher("another pepper which is long enough to be accepted", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	hashed := userIDs.hash("user")
+	if hashed != userIDs.hash("user") {
+		t.Fatal("expected hashing to be deterministic")
+	}
+	if hashed == otherUserIDs.hash("user") || hashed == userIDs.hash("other_user") {
+		t.Fatal("expected different peppers and users to result in different IDs")
+	}
+	if hashed != userIDs.rekeyed(legacyHashUserID("user")) {
+		t.Fatal("expected rekeying the legacy ID to result in the peppered ID")
+	}
+
+	if (*UserIDHasher)(nil).hash("user") != legacyHashUserID("user") {
+		t.Fatal("expected the legacy ID without pepper")
+	}
+}
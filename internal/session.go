@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/sessions"
+	"github.com/rs/zerolog/hlog"
+	"golang.org/x/oauth2"
+
+	"github.com/florianloch/cassette/internal/constants"
+)
+
+// tokenSavingWriter stores Spotify tokens refreshed while handling a request in the session. As the session
+// is stored in a cookie it gets saved right before the response gets written, resp. once the handler returns
+// without writing one.
+type tokenSavingWriter struct {
+	http.ResponseWriter
+	request *http.Request
+	session *sessions.Session
+
+	mu sync.Mutex
+	// whether a refreshed token has not been saved yet
+	dirty bool
+	// whether the response has been started, afterwards the session cannot be saved anymore
+	started bool
+}
+
+func newTokenSavingWriter(w http.ResponseWriter, r *http.Request, session *sessions.Session) *tokenSavingWriter {
+	return &tokenSavingWriter{ResponseWriter: w, request: r, session: session}
+}
+
+// tokenRefreshed is passed to the Spotify client, it might get called concurrently.
+func (w *tokenSavingWriter) tokenRefreshed(token *oauth2.Token) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.session.Values[constants.SessionKeySpotifyToken] = token
+
+	if w.started {
+		hlog.FromRequest(w.request).Debug().Msg("Spotify token has been refreshed after the response has been started, it cannot be saved.")
+		return
+	}
+
+	w.dirty = true
+}
+
+func (w *tokenSavingWriter) WriteHeader(statusCode int) {
+	w.save()
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *tokenSavingWriter) Write(b []byte) (int, error) {
+	w.save()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *tokenSavingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// save stores the refreshed token in the session unless the response has been started already.
+func (w *tokenSavingWriter) save() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.started {
+		return
+	}
+	w.started = true
+
+	if !w.dirty {
+		return
+	}
+	w.dirty = false
+
+	if err := w.session.Save(w.request, w.ResponseWriter); err != nil {
+		// The token will be refreshed once again with the next request
+		hlog.FromRequest(w.request).Error().Err(err).Msg("Could not save refreshed Spotify token in session.")
+	}
+}
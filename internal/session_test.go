@@ -0,0 +1,204 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	spotifyAPI "github.com/zmb3/spotify"
+	"golang.org/x/oauth2"
+
+	"github.com/florianloch/cassette/internal/constants"
+	"github.com/florianloch/cassette/internal/spotify"
+)
+
+// refreshingClient refreshes its token against the given token endpoint whenever the current user is requested,
+// just like the client created in production does once the token has expired.
+type refreshingClient struct {
+	spotify.SpotClient
+	source oauth2.TokenSource
+}
+
+func (c *refreshingClient) CurrentUser(_ context.Context) (*spotifyAPI.PrivateUser, error) {
+	if _, err := c.source.Token(); err != nil {
+		return nil, err
+	}
+
+	return &spotifyAPI.PrivateUser{}, nil
+}
+
+// setupTokenRefresh makes attachSpotifyClient create clients refreshing their token against a fake token endpoint
+// handing out a rotated refresh token. It returns a cookie of a session holding an expired token.
+func setupTokenRefresh(t *testing.T) *http.Cookie {
+	t.Helper()
+
+	tokenEndpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "refreshed", "token_type": "Bearer", "refresh_token": "rotated", "expires_in": 3600}`))
+	}))
+	t.Cleanup(tokenEndpoint.Close)
+
+	previousStore, previousCreateSpotClient := store, createSpotClient
+	t.Cleanup(func() {
+		store, createSpotClient = previousStore, previousCreateSpotClient
+	})
+
+	// As done by setupAPI
+	gob.Register(&oauth2.Token{})
+	gob.Register(constants.SessionKeyUser)
+	store = sessions.NewCookieStore(bytes.Repeat([]byte{1}, 32))
+
+	config := &oauth2.Config{Endpoint: oauth2.Endpoint{TokenURL: tokenEndpoint.URL}}
+	createSpotClient = func(token *oauth2.Token, onRefresh func(token *oauth2.Token)) spotify.SpotClient {
+		return &refreshingClient{source: &notifyingTokenSource{base: config.TokenSource(context.Background(), token), onRefresh: onRefresh}}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	session, err := store.New(r, constants.SessionCookieName)
+	if err != nil {
+		t.Fatalf("could not create session: %s", err)
+	}
+	session.Values[constants.SessionKeySpotifyToken] = &oauth2.Token{
+		AccessToken:  "expired",
+		RefreshToken: "initial refresh token",
+		Expiry:       time.Now().Add(-time.Hour),
+	}
+	if err := session.Save(r, w); err != nil {
+		t.Fatalf("could not save session: %s", err)
+	}
+
+	return w.Result().Cookies()[0]
+}
+
+type notifyingTokenSource struct {
+	base      oauth2.TokenSource
+	onRefresh func(token *oauth2.Token)
+}
+
+func (s *notifyingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.base.Token()
+	if err == nil {
+		s.onRefresh(token)
+	}
+
+	return token, err
+}
+
+// requestWithSpotifyClient sends a request with the given session cookie to a server passing it to handler
+// via attachSpotifyClient and returns the refresh token stored in the session cookie set by the response, if any.
+func requestWithSpotifyClient(t *testing.T, cookie *http.Cookie, handler http.HandlerFunc) (string, bool) {
+	t.Helper()
+
+	server := httptest.NewServer(attachSession(attachSpotifyClient(handler)))
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatalf("could not create request: %s", err)
+	}
+	req.AddCookie(cookie)
+
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+
+	for _, c := range resp.Cookies() {
+		if c.Name != constants.SessionCookieName {
+			continue
+		}
+
+		saved := httptest.NewRequest(http.MethodGet, "/", nil)
+		saved.AddCookie(c)
+		session, err := store.Get(saved, constants.SessionCookieName)
+		if err != nil {
+			t.Fatalf("could not decode session cookie: %s", err)
+		}
+
+		token, ok := session.Values[constants.SessionKeySpotifyToken].(*oauth2.Token)
+		if !ok {
+			t.Fatal("expected the session to hold a token")
+		}
+
+		return token.RefreshToken, true
+	}
+
+	return "", false
+}
+
+func currentUser(t *testing.T, r *http.Request) {
+	t.Helper()
+
+	client := r.Context().Value(constants.FieldKeySpotifyClient).(spotify.SpotClient)
+	if _, err := client.CurrentUser(r.Context()); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestRefreshedTokenIsSavedBeforeFirstWrite(t *testing.T) {
+	cookie := setupTokenRefresh(t)
+
+	refreshToken, ok := requestWithSpotifyClient(t, cookie, func(w http.ResponseWriter, r *http.Request) {
+		currentUser(t, r)
+		w.Write([]byte("ok"))
+	})
+
+	if !ok || refreshToken != "rotated" {
+		t.Fatalf("expected the rotated token to be saved, got '%s'", refreshToken)
+	}
+}
+
+func TestRefreshedTokenIsSavedIfHandlerWritesNothing(t *testing.T) {
+	cookie := setupTokenRefresh(t)
+
+	refreshToken, ok := requestWithSpotifyClient(t, cookie, func(w http.ResponseWriter, r *http.Request) {
+		currentUser(t, r)
+	})
+
+	if !ok || refreshToken != "rotated" {
+		t.Fatalf("expected the rotated token to be saved, got '%s'", refreshToken)
+	}
+}
+
+func TestTokenRefreshedAfterResponseStartedIsNotSaved(t *testing.T) {
+	cookie := setupTokenRefresh(t)
+
+	refreshToken, ok := requestWithSpotifyClient(t, cookie, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		currentUser(t, r)
+	})
+
+	// Headers have been sent already, the token gets refreshed again with the next request
+	if ok {
+		t.Fatalf("expected no session cookie to be set, got one holding '%s'", refreshToken)
+	}
+
+	refreshToken, ok = requestWithSpotifyClient(t, cookie, func(w http.ResponseWriter, r *http.Request) {
+		currentUser(t, r)
+	})
+
+	if !ok || refreshToken != "rotated" {
+		t.Fatalf("expected the rotated token to be saved with the next request, got '%s'", refreshToken)
+	}
+}
+
+func TestUnchangedTokenIsNotSaved(t *testing.T) {
+	cookie := setupTokenRefresh(t)
+
+	if refreshToken, ok := requestWithSpotifyClient(t, cookie, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}); ok {
+		t.Fatalf("expected no session cookie to be set, got one holding '%s'", refreshToken)
+	}
+}
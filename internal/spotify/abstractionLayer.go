@@ -1,6 +1,7 @@
 package spotify
 
 import (
+	"context"
 	"net/http"
 
 	spotifyAPI "github.com/zmb3/spotify"
@@ -9,19 +10,23 @@ import (
 
 type SpotAuthenticator interface {
 	AuthURL(state string) string
-	NewClient(token *oauth2.Token) spotifyAPI.Client
+	NewClient(token *oauth2.Token, onRefresh func(token *oauth2.Token)) *http.Client
 	SetAuthInfo(clientID, secretKey string)
 	Token(state string, r *http.Request) (*oauth2.Token, error)
 }
 
 type SpotClient interface {
-	CurrentUser() (*spotifyAPI.PrivateUser, error)
-	GetAlbumTracksOpt(id spotifyAPI.ID, opt *spotifyAPI.Options) (*spotifyAPI.SimpleTrackPage, error)
-	GetPlaylistOpt(playlistID spotifyAPI.ID, fields string) (*spotifyAPI.FullPlaylist, error)
-	GetPlaylistTracksOpt(playlistID spotifyAPI.ID, opt *spotifyAPI.Options, fields string) (*spotifyAPI.PlaylistTrackPage, error)
-	Pause() error
-	PlayerState() (*spotifyAPI.PlayerState, error)
-	PlayerDevices() ([]spotifyAPI.PlayerDevice, error)
-	PlayOpt(opt *spotifyAPI.PlayOptions) error
-	Shuffle(shuffle bool) error
+	CurrentUser(ctx context.Context) (*spotifyAPI.PrivateUser, error)
+	CurrentUsersTracksOpt(ctx context.Context, opt *spotifyAPI.Options) (*spotifyAPI.SavedTrackPage, error)
+	GetAlbumTracksOpt(ctx context.Context, id spotifyAPI.ID, opt *spotifyAPI.Options) (*spotifyAPI.SimpleTrackPage, error)
+	GetAudiobook(ctx context.Context, id spotifyAPI.ID) (*Audiobook, error)
+	GetAudiobookChaptersOpt(ctx context.Context, id spotifyAPI.ID, opt *spotifyAPI.Options) (*ChapterPage, error)
+	GetPlaylistOpt(ctx context.Context, playlistID spotifyAPI.ID, fields string) (*spotifyAPI.FullPlaylist, error)
+	GetPlaylistTracksOpt(ctx context.Context, playlistID spotifyAPI.ID, opt *spotifyAPI.Options, fields string) (*spotifyAPI.PlaylistTrackPage, error)
+	GetShowEpisodesOpt(ctx context.Context, id spotifyAPI.ID, opt *spotifyAPI.Options) (*spotifyAPI.SimpleEpisodePage, error)
+	Pause(ctx context.Context) error
+	PlayerState(ctx context.Context) (*PlayerState, error)
+	PlayerDevices(ctx context.Context) ([]spotifyAPI.PlayerDevice, error)
+	PlayOpt(ctx context.Context, opt *spotifyAPI.PlayOptions) error
+	Shuffle(ctx context.Context, shuffle bool) error
 }
@@ -0,0 +1,54 @@
+package spotify
+
+import (
+	"strings"
+
+	spotifyAPI "github.com/zmb3/spotify"
+)
+
+// Audiobook is a book of Spotify's audiobook catalogue. spotifyAPI does not know about audiobooks at all,
+// so only the fields required by Cassette are declared.
+type Audiobook struct {
+	ID            spotifyAPI.ID      `json:"id"`
+	URI           spotifyAPI.URI     `json:"uri"`
+	Name          string             `json:"name"`
+	Authors       []Person           `json:"authors"`
+	Narrators     []Person           `json:"narrators"`
+	Images        []spotifyAPI.Image `json:"images"`
+	ExternalURLs  map[string]string  `json:"external_urls"`
+	TotalChapters int                `json:"total_chapters"`
+}
+
+// Person is an author resp. a narrator of an audiobook.
+type Person struct {
+	Name string `json:"name"`
+}
+
+// Chapter is a chapter of an audiobook, the latter is only given when retrieved on its own resp. while playing.
+type Chapter struct {
+	ID            spotifyAPI.ID      `json:"id"`
+	URI           spotifyAPI.URI     `json:"uri"`
+	Name          string             `json:"name"`
+	ChapterNumber int                `json:"chapter_number"`
+	Duration      int                `json:"duration_ms"`
+	Images        []spotifyAPI.Image `json:"images"`
+	ExternalURLs  map[string]string  `json:"external_urls"`
+	Audiobook     *Audiobook         `json:"audiobook"`
+}
+
+// ChapterPage contains the chapters of an audiobook in their order.
+type ChapterPage struct {
+	Chapters []Chapter `json:"items"`
+	Limit    int       `json:"limit"`
+	Offset   int       `json:"offset"`
+	Total    int       `json:"total"`
+}
+
+func joinedNames(people []Person) string {
+	names := make([]string, len(people))
+	for i, person := range people {
+		names[i] = person.Name
+	}
+
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,220 @@
+package spotify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog/log"
+)
+
+// States of a circuit breaker, their values are exposed by the state metric
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+// Outcomes of calls as far as the availability of Spotify is concerned
+const (
+	outcomeSuccess callOutcome = iota
+	outcomeFailure
+	// the call has been cancelled by the caller before it could tell anything about the availability
+	outcomeUnknown
+)
+
+// Classes of endpoints of Spotify's API which are assumed to fail independently of each other
+const (
+	endpointClassPlayer  endpointClass = "player"
+	endpointClassCatalog endpointClass = "catalog"
+	endpointClassUser    endpointClass = "user"
+)
+
+var (
+	breakerStateGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "cassette",
+		Subsystem: "spotify",
+		Name:      "circuit_breaker_state",
+		Help:      "State of the circuit breaker per class of endpoints of Spotify's API: 0 closed, 1 half-open, 2 open.",
+	}, []string{"class"})
+)
+
+type breakerState int
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "open"
+	}
+}
+
+type callOutcome int
+
+type endpointClass string
+
+// classOf returns the class of endpoints the given method of SpotClient calls.
+func classOf(method string) endpointClass {
+	switch method {
+	case "Pause", "PlayOpt", "PlayerDevices", "PlayerState", "Shuffle":
+		return endpointClassPlayer
+	case "CurrentUser", "CurrentUsersTracksOpt":
+		return endpointClassUser
+	default:
+		return endpointClassCatalog
+	}
+}
+
+// UnavailableError is returned instead of calling Spotify's API while it is considered to be unavailable.
+type UnavailableError struct {
+	// RetryAfter is the time after which Spotify's API is going to be called again
+	RetryAfter time.Duration
+	class      endpointClass
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("spotify is unavailable (%s endpoints), retry after %s", e.class, e.RetryAfter)
+}
+
+// CircuitBreakerConfig configures when the circuit breakers open and how long they stay open.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures after which a breaker opens
+	FailureThreshold int
+	// OpenFor is the time a breaker stays open before letting a single call through to probe whether
+	// Spotify has recovered
+	OpenFor time.Duration
+}
+
+// CircuitBreakers holds one circuit breaker per class of endpoints. It is meant to be shared by the
+// clients of all users, this way all of them back off once Spotify fails.
+type CircuitBreakers struct {
+	breakers map[endpointClass]*circuitBreaker
+}
+
+func NewCircuitBreakers(config CircuitBreakerConfig) *CircuitBreakers {
+	breakers := make(map[endpointClass]*circuitBreaker)
+	for _, class := range []endpointClass{endpointClassPlayer, endpointClassCatalog, endpointClassUser} {
+		breakers[class] = &circuitBreaker{class: class, config: config}
+		breakerStateGauge.WithLabelValues(string(class)).Set(float64(breakerClosed))
+	}
+
+	return &CircuitBreakers{breakers: breakers}
+}
+
+// do calls the given function unless the breaker of the method's class is open.
+func (c *CircuitBreakers) do(ctx context.Context, method string, call func() error) error {
+	breaker := c.breakers[classOf(method)]
+
+	probe, err := breaker.allow(time.Now())
+	if err != nil {
+		return err
+	}
+
+	err = call()
+	breaker.record(probe, outcomeOf(ctx, err), time.Now())
+
+	return err
+}
+
+// outcomeOf tells whether the error indicates that Spotify is unavailable. Errors caused by the request,
+// e.g. there being no active device, show that it is available.
+func outcomeOf(ctx context.Context, err error) callOutcome {
+	switch {
+	case err == nil:
+		return outcomeSuccess
+	case ctx.Err() != nil || errors.Is(err, context.Canceled):
+		return outcomeUnknown
+	case isRetryable(err):
+		return outcomeFailure
+	default:
+		return outcomeSuccess
+	}
+}
+
+type circuitBreaker struct {
+	class  endpointClass
+	config CircuitBreakerConfig
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	// whether the call probing a half-open breaker is still running
+	probing bool
+}
+
+// allow returns an UnavailableError in case the call must not be made. Otherwise it tells whether the call
+// probes a half-open breaker, this has to be passed on to record.
+func (b *circuitBreaker) allow(now time.Time) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen && now.Sub(b.openedAt) >= b.config.OpenFor {
+		b.transition(breakerHalfOpen)
+	}
+
+	switch {
+	case b.state == breakerClosed:
+		return false, nil
+	case b.state == breakerHalfOpen && !b.probing:
+		b.probing = true
+		return true, nil
+	default:
+		retryAfter := b.openedAt.Add(b.config.OpenFor).Sub(now)
+		if retryAfter < time.Second {
+			// The probe might take a moment, do not let clients try again right away
+			retryAfter = time.Second
+		}
+
+		return false, &UnavailableError{RetryAfter: retryAfter, class: b.class}
+	}
+}
+
+// record takes the outcome of a call into account, probe is what allow returned for the call.
+func (b *circuitBreaker) record(probe bool, outcome callOutcome, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probing = false
+	} else if b.state != breakerClosed {
+		// The call has been started before the breaker opened, it does not tell anything new.
+		// Only the probe decides whether a half-open breaker closes.
+		return
+	}
+
+	switch outcome {
+	case outcomeSuccess:
+		b.failures = 0
+		b.transition(breakerClosed)
+	case outcomeFailure:
+		b.failures++
+		if b.state == breakerHalfOpen || b.failures >= b.config.FailureThreshold {
+			b.openedAt = now
+			b.transition(breakerOpen)
+		}
+	}
+}
+
+// transition must be called with the lock held.
+func (b *circuitBreaker) transition(state breakerState) {
+	if b.state == state {
+		return
+	}
+
+	if state == breakerOpen {
+		log.Warn().Msgf("Spotify's %s endpoints are considered to be unavailable for %s after %d consecutive failures.", b.class, b.config.OpenFor, b.failures)
+	} else {
+		log.Info().Msgf("Circuit breaker for Spotify's %s endpoints is %s.", b.class, state)
+	}
+
+	b.state = state
+	breakerStateGauge.WithLabelValues(string(b.class)).Set(float64(state))
+}
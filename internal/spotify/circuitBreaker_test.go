@@ -0,0 +1,137 @@
+package spotify
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	spotifyAPI "github.com/zmb3/spotify"
+)
+
+func TestCircuitBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	breakers := NewCircuitBreakers(CircuitBreakerConfig{FailureThreshold: 3, OpenFor: time.Hour})
+	base := &flakyClient{errs: repeat(spotifyAPI.Error{Status: http.StatusServiceUnavailable}, 3)}
+	client := NewSpotClientWithCircuitBreaker(base, breakers)
+
+	for range 3 {
+		if err := client.Pause(t.Context()); err == nil {
+			t.Fatal("expected error")
+		}
+	}
+
+	var unavailable *UnavailableError
+	if err := client.Pause(t.Context()); !errors.As(err, &unavailable) {
+		t.Fatalf("expected UnavailableError, got %v", err)
+	}
+	if unavailable.RetryAfter <= 59*time.Minute {
+		t.Errorf("expected to be told to retry after about an hour, got %s", unavailable.RetryAfter)
+	}
+	if base.calls != 3 {
+		t.Errorf("expected Spotify not to be called once the breaker is open, got %d calls", base.calls)
+	}
+
+	// Other classes of endpoints are not affected
+	if _, err := NewSpotClientWithCircuitBreaker(userClient{}, breakers).CurrentUser(t.Context()); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestCircuitBreakerIgnoresClientErrors(t *testing.T) {
+	base := &flakyClient{errs: repeat(spotifyAPI.Error{Status: http.StatusNotFound}, 5)}
+	client := NewSpotClientWithCircuitBreaker(base, NewCircuitBreakers(CircuitBreakerConfig{FailureThreshold: 2, OpenFor: time.Hour}))
+
+	for range 5 {
+		var unavailable *UnavailableError
+		if err := client.Pause(t.Context()); errors.As(err, &unavailable) {
+			t.Fatal("expected breaker to stay closed")
+		}
+	}
+}
+
+func TestCircuitBreakerProbesOnceOpenForElapsed(t *testing.T) {
+	b := &circuitBreaker{class: endpointClassPlayer, config: CircuitBreakerConfig{FailureThreshold: 1, OpenFor: time.Minute}}
+	now := time.Now()
+
+	b.record(false, outcomeFailure, now)
+	if b.state != breakerOpen {
+		t.Fatalf("expected breaker to be open, it is %s", b.state)
+	}
+
+	if _, err := b.allow(now.Add(30 * time.Second)); err == nil {
+		t.Fatal("expected call to be rejected while open")
+	}
+
+	// Only a single call probes whether Spotify has recovered
+	if probe, err := b.allow(now.Add(time.Minute)); err != nil || !probe {
+		t.Fatalf("expected probe to be allowed, got %v", err)
+	}
+	if _, err := b.allow(now.Add(time.Minute)); err == nil {
+		t.Fatal("expected further calls to be rejected while probing")
+	}
+
+	// A failing probe opens the breaker again...
+	b.record(true, outcomeFailure, now.Add(time.Minute))
+	if _, err := b.allow(now.Add(time.Minute + time.Second)); err == nil {
+		t.Fatal("expected call to be rejected after failed probe")
+	}
+
+	// ... while a successful one closes it
+	if probe, err := b.allow(now.Add(2 * time.Minute)); err != nil || !probe {
+		t.Fatalf("expected probe to be allowed, got %v", err)
+	}
+	b.record(true, outcomeSuccess, now.Add(2*time.Minute))
+	if b.state != breakerClosed {
+		t.Fatalf("expected breaker to be closed, it is %s", b.state)
+	}
+}
+
+func TestCallsStartedBeforeBreakerOpenedDoNotEndProbe(t *testing.T) {
+	b := &circuitBreaker{class: endpointClassPlayer, config: CircuitBreakerConfig{FailureThreshold: 1, OpenFor: time.Minute}}
+	now := time.Now()
+
+	// Started while closed, finishing once the breaker is half-open
+	if probe, err := b.allow(now); err != nil || probe {
+		t.Fatalf("expected a regular call to be allowed, got %v", err)
+	}
+	b.record(false, outcomeFailure, now)
+	if probe, err := b.allow(now.Add(time.Minute)); err != nil || !probe {
+		t.Fatalf("expected probe to be allowed, got %v", err)
+	}
+
+	b.record(false, outcomeSuccess, now.Add(time.Minute))
+	if b.state != breakerHalfOpen {
+		t.Fatalf("expected a stale success not to close the breaker, it is %s", b.state)
+	}
+
+	b.record(false, outcomeFailure, now.Add(time.Minute))
+	if b.state != breakerHalfOpen {
+		t.Fatalf("expected a stale failure not to open the breaker, it is %s", b.state)
+	}
+	if _, err := b.allow(now.Add(time.Minute)); err == nil {
+		t.Fatal("expected no second probe while the first one is running")
+	}
+
+	b.record(true, outcomeSuccess, now.Add(time.Minute))
+	if b.state != breakerClosed {
+		t.Fatalf("expected the probe to close the breaker, it is %s", b.state)
+	}
+}
+
+func TestCancelledCallsDoNotCount(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	if outcome := outcomeOf(ctx, context.Canceled); outcome != outcomeUnknown {
+		t.Errorf("expected outcome to be unknown, got %d", outcome)
+	}
+}
+
+type userClient struct {
+	SpotClient
+}
+
+func (userClient) CurrentUser(ctx context.Context) (*spotifyAPI.PrivateUser, error) {
+	return &spotifyAPI.PrivateUser{}, nil
+}
@@ -0,0 +1,345 @@
+package spotify
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	spotifyAPI "github.com/zmb3/spotify"
+	"golang.org/x/oauth2"
+)
+
+// Authenticator implements SpotAuthenticator. Differing from spotifyAPI.Authenticator it hands out
+// plain HTTP clients, which allows us to attach a context to every request sent to Spotify.
+type Authenticator struct {
+	config *oauth2.Config
+	// httpClient is used for talking to Spotify's accounts service, e.g. for refreshing tokens
+	httpClient *http.Client
+}
+
+func NewAuthenticator(redirectURL string, scopes ...string) *Authenticator {
+	return &Authenticator{
+		config: &oauth2.Config{
+			RedirectURL: redirectURL,
+			Scopes:      scopes,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  spotifyAPI.AuthURL,
+				TokenURL: spotifyAPI.TokenURL,
+			},
+		},
+		// Just like spotifyAPI.Authenticator we disable HTTP/2
+		httpClient: &http.Client{Transport: &http.Transport{
+			TLSNextProto: map[string]func(authority string, c *tls.Conn) http.RoundTripper{},
+		}},
+	}
+}
+
+func (a *Authenticator) SetAuthInfo(clientID, secretKey string) {
+	a.config.ClientID = clientID
+	a.config.ClientSecret = secretKey
+}
+
+func (a *Authenticator) AuthURL(state string) string {
+	return a.config.AuthCodeURL(state)
+}
+
+// Token exchanges the code given to the OAuth callback for a token. The exchange gets cancelled
+// in case the callback request gets cancelled.
+func (a *Authenticator) Token(state string, r *http.Request) (*oauth2.Token, error) {
+	values := r.URL.Query()
+	if e := values.Get("error"); e != "" {
+		return nil, errors.New("spotify: auth failed - " + e)
+	}
+
+	code := values.Get("code")
+	if code == "" {
+		return nil, errors.New("spotify: didn't get access code")
+	}
+
+	if values.Get("state") != state {
+		return nil, errors.New("spotify: redirect state parameter doesn't match")
+	}
+
+	return a.config.Exchange(a.contextFor(r.Context()), code)
+}
+
+// NewClient returns an HTTP client authenticating its requests with the given token, refreshing it if required.
+// Every refreshed token gets passed to onRefresh, if given, so that it can be stored for subsequent requests.
+func (a *Authenticator) NewClient(token *oauth2.Token, onRefresh func(token *oauth2.Token)) *http.Client {
+	ctx := a.contextFor(context.Background())
+
+	var source oauth2.TokenSource = a.config.TokenSource(ctx, token)
+	if onRefresh != nil {
+		source = &refreshNotifyingTokenSource{base: source, last: token, onRefresh: onRefresh}
+	}
+
+	return oauth2.NewClient(ctx, source)
+}
+
+func (a *Authenticator) contextFor(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, a.httpClient)
+}
+
+// refreshNotifyingTokenSource passes tokens differing from the last one it has handed out to onRefresh.
+type refreshNotifyingTokenSource struct {
+	base      oauth2.TokenSource
+	onRefresh func(token *oauth2.Token)
+
+	mu   sync.Mutex
+	last *oauth2.Token
+}
+
+func (s *refreshNotifyingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.base.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.last == nil || token.AccessToken != s.last.AccessToken || token.RefreshToken != s.last.RefreshToken {
+		s.last = token
+		s.onRefresh(token)
+	}
+
+	return token, nil
+}
+
+// apiBaseURL is the address of Spotify's Web API, the same as used by spotifyAPI.Client
+const apiBaseURL = "https://api.spotify.com/v1/"
+
+// contextClient implements SpotClient on top of spotifyAPI.Client. The latter does not support contexts,
+// therefore every call gets its own spotifyAPI.Client sending its requests with the given context.
+type contextClient struct {
+	httpClient *http.Client
+}
+
+// NewSpotClient returns a SpotClient sending its requests via the given HTTP client,
+// usually the one returned by SpotAuthenticator.NewClient.
+func NewSpotClient(httpClient *http.Client) SpotClient {
+	return &contextClient{httpClient}
+}
+
+// withContext returns a client sending its requests with the given context along with its transport,
+// which keeps track of the last response the client has received.
+func (c *contextClient) withContext(ctx context.Context) (*spotifyAPI.Client, *contextTransport) {
+	httpClient, t := c.httpClientWithContext(ctx)
+	client := spotifyAPI.NewClient(httpClient)
+
+	return &client, t
+}
+
+func (c *contextClient) httpClientWithContext(ctx context.Context) (*http.Client, *contextTransport) {
+	transport := c.httpClient.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	t := &contextTransport{ctx: ctx, base: transport}
+
+	return &http.Client{
+		Transport: t,
+		Timeout:   c.httpClient.Timeout,
+	}, t
+}
+
+// get fetches the given resource of Spotify's Web API into result, it is used for requests spotifyAPI.Client
+// cannot send. Just like the latter it leaves result untouched in case there is no content.
+func (c *contextClient) get(ctx context.Context, path string, query url.Values, result any) error {
+	httpClient, t := c.httpClientWithContext(ctx)
+
+	spotifyURL := apiBaseURL + path
+	if len(query) > 0 {
+		spotifyURL += "?" + query.Encode()
+	}
+
+	resp, err := httpClient.Get(spotifyURL)
+	if err != nil {
+		return t.annotate(err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return json.NewDecoder(resp.Body).Decode(result)
+	case http.StatusNoContent:
+		return nil
+	default:
+		return t.annotate(decodeError(resp))
+	}
+}
+
+// decodeError turns an error response into a spotifyAPI.Error, just like spotifyAPI.Client does.
+func decodeError(resp *http.Response) error {
+	var body struct {
+		Error spotifyAPI.Error `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Error.Message == "" {
+		return spotifyAPI.Error{
+			Message: fmt.Sprintf("spotify: unexpected HTTP %d: %s", resp.StatusCode, http.StatusText(resp.StatusCode)),
+			Status:  resp.StatusCode,
+		}
+	}
+
+	return body.Error
+}
+
+func (c *contextClient) CurrentUser(ctx context.Context) (*spotifyAPI.PrivateUser, error) {
+	client, t := c.withContext(ctx)
+	result, err := client.CurrentUser()
+
+	return result, t.annotate(err)
+}
+
+func (c *contextClient) CurrentUsersTracksOpt(ctx context.Context, opt *spotifyAPI.Options) (*spotifyAPI.SavedTrackPage, error) {
+	client, t := c.withContext(ctx)
+	result, err := client.CurrentUsersTracksOpt(opt)
+
+	return result, t.annotate(err)
+}
+
+func (c *contextClient) GetAlbumTracksOpt(ctx context.Context, id spotifyAPI.ID, opt *spotifyAPI.Options) (*spotifyAPI.SimpleTrackPage, error) {
+	client, t := c.withContext(ctx)
+	result, err := client.GetAlbumTracksOpt(id, opt)
+
+	return result, t.annotate(err)
+}
+
+func (c *contextClient) GetAudiobook(ctx context.Context, id spotifyAPI.ID) (*Audiobook, error) {
+	var result Audiobook
+	if err := c.get(ctx, "audiobooks/"+string(id), nil, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (c *contextClient) GetAudiobookChaptersOpt(ctx context.Context, id spotifyAPI.ID, opt *spotifyAPI.Options) (*ChapterPage, error) {
+	query := url.Values{}
+	if opt != nil {
+		if opt.Country != nil {
+			query.Set("market", *opt.Country)
+		}
+		if opt.Limit != nil {
+			query.Set("limit", strconv.Itoa(*opt.Limit))
+		}
+		if opt.Offset != nil {
+			query.Set("offset", strconv.Itoa(*opt.Offset))
+		}
+	}
+
+	var result ChapterPage
+	if err := c.get(ctx, "audiobooks/"+string(id)+"/chapters", query, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (c *contextClient) GetPlaylistOpt(ctx context.Context, playlistID spotifyAPI.ID, fields string) (*spotifyAPI.FullPlaylist, error) {
+	client, t := c.withContext(ctx)
+	result, err := client.GetPlaylistOpt(playlistID, fields)
+
+	return result, t.annotate(err)
+}
+
+func (c *contextClient) GetPlaylistTracksOpt(ctx context.Context, playlistID spotifyAPI.ID, opt *spotifyAPI.Options, fields string) (*spotifyAPI.PlaylistTrackPage, error) {
+	client, t := c.withContext(ctx)
+	result, err := client.GetPlaylistTracksOpt(playlistID, opt, fields)
+
+	return result, t.annotate(err)
+}
+
+func (c *contextClient) GetShowEpisodesOpt(ctx context.Context, id spotifyAPI.ID, opt *spotifyAPI.Options) (*spotifyAPI.SimpleEpisodePage, error) {
+	client, t := c.withContext(ctx)
+	result, err := client.GetShowEpisodesOpt(opt, string(id))
+
+	return result, t.annotate(err)
+}
+
+func (c *contextClient) Pause(ctx context.Context) error {
+	client, t := c.withContext(ctx)
+
+	return t.annotate(client.Pause())
+}
+
+// PlayerState asks Spotify for episodes too, spotifyAPI.Client does not support this.
+func (c *contextClient) PlayerState(ctx context.Context) (*PlayerState, error) {
+	var result PlayerState
+	if err := c.get(ctx, "me/player", url.Values{"additional_types": []string{"episode"}}, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func (c *contextClient) PlayerDevices(ctx context.Context) ([]spotifyAPI.PlayerDevice, error) {
+	client, t := c.withContext(ctx)
+	result, err := client.PlayerDevices()
+
+	return result, t.annotate(err)
+}
+
+func (c *contextClient) PlayOpt(ctx context.Context, opt *spotifyAPI.PlayOptions) error {
+	client, t := c.withContext(ctx)
+
+	return t.annotate(client.PlayOpt(opt))
+}
+
+func (c *contextClient) Shuffle(ctx context.Context, shuffle bool) error {
+	client, t := c.withContext(ctx)
+
+	return t.annotate(client.Shuffle(shuffle))
+}
+
+// contextTransport sends all requests with the context it has been created with. As spotifyAPI.Error
+// contains neither the status code of empty responses nor the 'Retry-After' header, it remembers them.
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+	// status and retryAfter of the last response
+	status     int
+	retryAfter time.Duration
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req.WithContext(t.ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	t.status = resp.StatusCode
+	t.retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+
+	return resp, nil
+}
+
+// annotate classifies errors caused by the last response.
+func (t *contextTransport) annotate(err error) error {
+	return classify(err, t.status, t.retryAfter)
+}
+
+// parseRetryAfter returns the delay given by a 'Retry-After' header, which is either given in seconds or as date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0)
+	}
+
+	return 0
+}
@@ -0,0 +1,284 @@
+package spotify
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	spotifyAPI "github.com/zmb3/spotify"
+	"golang.org/x/oauth2"
+)
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// flakyClient fails the calls to Pause with the given errors in turn, afterwards they succeed.
+type flakyClient struct {
+	SpotClient
+	errs  []error
+	calls int
+	// afterCall gets called after every call if set
+	afterCall func()
+}
+
+func (c *flakyClient) Pause(ctx context.Context) error {
+	c.calls++
+	if c.afterCall != nil {
+		c.afterCall()
+	}
+
+	if c.calls > len(c.errs) {
+		return nil
+	}
+
+	return c.errs[c.calls-1]
+}
+
+func TestContextClientSendsRequestsWithGivenContext(t *testing.T) {
+	// Blocks until the request gets cancelled, just like a Spotify not responding would
+	client := NewSpotClient(&http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		<-req.Context().Done()
+
+		return nil, req.Context().Err()
+	})})
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error)
+	go func() {
+		_, err := client.PlayerDevices(ctx)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request has not been cancelled")
+	}
+}
+
+func TestTimeoutAppliesToCall(t *testing.T) {
+	client := NewSpotClientWithTimeout(NewSpotClient(&http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		<-req.Context().Done()
+
+		return nil, req.Context().Err()
+	})}), SpotClientWithTimeoutConfig{PauseTimeout: 50 * time.Millisecond})
+
+	if err := client.Pause(t.Context()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestRetryStopsOnCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	base := &flakyClient{errs: repeat(spotifyAPI.Error{Status: http.StatusServiceUnavailable}, 3), afterCall: cancel}
+
+	// Waiting an hour between attempts, the test only finishes in time in case retrying stops
+	client := NewSpotClientWithRetry(base, RetryPolicy{MaxAttempts: 4, BaseDelay: time.Hour})
+
+	if err := client.Pause(ctx); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if base.calls != 1 {
+		t.Fatalf("expected exactly one call, got %d", base.calls)
+	}
+}
+
+func TestRetryReturnsClientErrorsRightAway(t *testing.T) {
+	noActiveDevice := spotifyAPI.Error{Status: http.StatusNotFound, Message: "Player command failed: No active device found"}
+	base := &flakyClient{errs: []error{noActiveDevice}}
+
+	client := NewSpotClientWithRetry(base, RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})
+
+	if err := client.Pause(t.Context()); !errors.Is(err, noActiveDevice) {
+		t.Fatalf("expected the error of the first attempt, got %v", err)
+	}
+
+	if base.calls != 1 {
+		t.Fatalf("expected exactly one call, got %d", base.calls)
+	}
+}
+
+func TestRetryRecoversFromServerErrors(t *testing.T) {
+	base := &flakyClient{errs: repeat(spotifyAPI.Error{Status: http.StatusBadGateway}, 2)}
+
+	client := NewSpotClientWithRetry(base, RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})
+
+	if err := client.Pause(t.Context()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if base.calls != 3 {
+		t.Fatalf("expected 3 calls, got %d", base.calls)
+	}
+}
+
+func TestRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	base := &flakyClient{errs: repeat(spotifyAPI.Error{Status: http.StatusInternalServerError}, 5)}
+
+	client := NewSpotClientWithRetry(base, RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})
+
+	if err := client.Pause(t.Context()); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if base.calls != 3 {
+		t.Fatalf("expected 3 calls, got %d", base.calls)
+	}
+}
+
+func TestRetryHonoursRetryAfter(t *testing.T) {
+	var calls int
+	client := NewSpotClientWithRetry(NewSpotClient(&http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		if calls == 1 {
+			return jsonResponse(http.StatusTooManyRequests, `{"error": {"status": 429, "message": "API rate limit exceeded"}}`, "1"), nil
+		}
+
+		return jsonResponse(http.StatusOK, `{"devices": []}`, ""), nil
+	})}), RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})
+
+	start := time.Now()
+
+	if _, err := client.PlayerDevices(t.Context()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if calls != 2 {
+		t.Fatalf("expected 2 calls, got %d", calls)
+	}
+	if waited := time.Since(start); waited < time.Second {
+		t.Fatalf("expected to wait for at least a second as requested by Spotify, waited %s", waited)
+	}
+}
+
+func TestRetryGivesUpIfRetryAfterExceedsBudget(t *testing.T) {
+	var calls int
+	client := NewSpotClientWithRetry(NewSpotClient(&http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+
+		return jsonResponse(http.StatusTooManyRequests, `{"error": {"status": 429, "message": "API rate limit exceeded"}}`, "3600"), nil
+	})}), RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxTotal: time.Minute})
+
+	_, err := client.PlayerDevices(t.Context())
+
+	var apiErr spotifyAPI.Error
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusTooManyRequests {
+		t.Fatalf("expected the rate limiting error, got %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected exactly one call, got %d", calls)
+	}
+}
+
+func TestBackoffGrowsUpToMaxDelay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	for attempt, expected := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second} {
+		if delay := policy.backoff(attempt + 1); delay < expected/2 || delay > expected {
+			t.Errorf("expected delay after attempt %d to be between %s and %s, got %s", attempt+1, expected/2, expected, delay)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for value, expected := range map[string]time.Duration{
+		"":                              0,
+		"7":                             7 * time.Second,
+		"-1":                            0,
+		"Mon, 01 Jan 2024 12:00:30 GMT": 30 * time.Second,
+		"soon":                          0,
+	} {
+		if actual := parseRetryAfter(value, now); actual != expected {
+			t.Errorf("expected '%s' to result in %s, got %s", value, expected, actual)
+		}
+	}
+}
+
+func jsonResponse(status int, body string, retryAfter string) *http.Response {
+	header := http.Header{"Content-Type": []string{"application/json"}}
+	if retryAfter != "" {
+		header.Set("Retry-After", retryAfter)
+	}
+
+	return &http.Response{
+		StatusCode: status,
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+func repeat(err error, n int) []error {
+	errs := make([]error, n)
+	for i := range errs {
+		errs[i] = err
+	}
+
+	return errs
+}
+
+func TestRefreshedTokensArePassedOn(t *testing.T) {
+	var refreshes int
+	tokenEndpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		refreshes++
+
+		if err := r.ParseForm(); err != nil || r.Form.Get("refresh_token") != "initial refresh token" {
+			t.Errorf("expected the initial refresh token to be used, got '%s'", r.Form.Get("refresh_token"))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "refreshed", "token_type": "Bearer", "refresh_token": "rotated", "expires_in": 3600}`))
+	}))
+	defer tokenEndpoint.Close()
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); auth != "Bearer refreshed" {
+			t.Errorf("expected request to be authenticated with the refreshed token, got '%s'", auth)
+		}
+	}))
+	defer api.Close()
+
+	auth := NewAuthenticator("http://localhost/callback")
+	auth.config.Endpoint.TokenURL = tokenEndpoint.URL
+
+	var refreshed []*oauth2.Token
+	client := auth.NewClient(&oauth2.Token{
+		AccessToken:  "expired",
+		RefreshToken: "initial refresh token",
+		Expiry:       time.Now().Add(-time.Hour),
+	}, func(token *oauth2.Token) {
+		refreshed = append(refreshed, token)
+	})
+
+	for range 2 {
+		resp, err := client.Get(api.URL)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		resp.Body.Close()
+	}
+
+	if refreshes != 1 {
+		t.Fatalf("expected the token to be refreshed once, got %d refreshes", refreshes)
+	}
+	if len(refreshed) != 1 || refreshed[0].AccessToken != "refreshed" || refreshed[0].RefreshToken != "rotated" {
+		t.Fatalf("expected the refreshed token to be passed on once, got %v", refreshed)
+	}
+}
@@ -0,0 +1,71 @@
+package spotify
+
+import (
+	"sync"
+	"time"
+
+	spotifyAPI "github.com/zmb3/spotify"
+)
+
+// ContextCache keeps the items of recently looked into contexts for a short time, so suspending right after
+// resuming resp. suspending again does not require paging through the whole context once more.
+// A nil *ContextCache is valid and caches nothing.
+type ContextCache struct {
+	ttl     time.Duration
+	now     func() time.Time
+	mu      sync.Mutex
+	entries map[spotifyAPI.URI]cachedContext
+}
+
+type cachedContext struct {
+	items     []contextItem
+	expiresAt time.Time
+}
+
+// NewContextCache returns a cache keeping the items of a context for the given time.
+func NewContextCache(ttl time.Duration) *ContextCache {
+	return &ContextCache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: map[spotifyAPI.URI]cachedContext{},
+	}
+}
+
+// get returns the items of the context with the given URI unless they have expired.
+func (c *ContextCache) get(uri spotifyAPI.URI) ([]contextItem, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[uri]
+	if !ok || !c.now().Before(entry.expiresAt) {
+		return nil, false
+	}
+
+	return entry.items, true
+}
+
+// put stores all items of the context with the given URI. Contexts are shared by all users except for the
+// collection, which is identified by the user's ID. Hence, the URI is sufficient as key.
+func (c *ContextCache) put(uri spotifyAPI.URI, items []contextItem) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+
+	// Evict expired entries, this way the cache only grows with the number of contexts looked into within its TTL
+	for key, entry := range c.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(c.entries, key)
+		}
+	}
+
+	c.entries[uri] = cachedContext{items: items, expiresAt: now.Add(c.ttl)}
+}
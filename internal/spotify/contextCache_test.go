@@ -0,0 +1,36 @@
+package spotify
+
+import (
+	"testing"
+	"time"
+)
+
+func TestContextCacheForgetsExpiredItems(t *testing.T) {
+	now := time.Unix(1000, 0)
+	cache := NewContextCache(time.Minute)
+	cache.now = func() time.Time { return now }
+
+	cache.put("spotify:album:album1", []contextItem{{ID: "track1"}})
+	if items, ok := cache.get("spotify:album:album1"); !ok || len(items) != 1 {
+		t.Fatalf("expected cached items, got %v", items)
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := cache.get("spotify:album:album1"); ok {
+		t.Error("expected items to have expired")
+	}
+
+	cache.put("spotify:album:album2", nil)
+	if len(cache.entries) != 1 {
+		t.Errorf("expected expired entries to be evicted, %d are left", len(cache.entries))
+	}
+}
+
+func TestNilContextCacheCachesNothing(t *testing.T) {
+	var cache *ContextCache
+
+	cache.put("spotify:album:album1", []contextItem{{ID: "track1"}})
+	if _, ok := cache.get("spotify:album:album1"); ok {
+		t.Error("expected nil cache not to return anything")
+	}
+}
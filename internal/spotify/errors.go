@@ -0,0 +1,104 @@
+package spotify
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	spotifyAPI "github.com/zmb3/spotify"
+	"golang.org/x/oauth2"
+)
+
+// Kinds of errors Spotify responds with, use errors.Is for telling them apart
+var (
+	ErrNoActiveDevice             = errors.New("no active device")
+	ErrPremiumRequired            = errors.New("spotify premium required")
+	ErrRateLimited                = errors.New("rate limited by spotify")
+	ErrTokenRevoked               = errors.New("spotify token expired or revoked")
+	ErrNotFound                   = errors.New("not found on spotify")
+	ErrContextUnavailableInMarket = errors.New("context not available in the user's market")
+	// ErrScopeMissing means the user has not granted a scope required for the request, e.g. because they logged in
+	// before it has been requested
+	ErrScopeMissing = errors.New("spotify token lacks a required scope")
+	// ErrUpstream covers failures of Spotify as well as responses not fitting any of the other kinds
+	ErrUpstream = errors.New("spotify failed")
+)
+
+// Error is an unsuccessful response from Spotify, classified as one of the kinds above. Besides its kind it
+// wraps the error returned by spotifyAPI.Client, usually a spotifyAPI.Error.
+type Error struct {
+	Kind error
+	// Status is the HTTP status code of the response, 0 in case the request failed before getting one
+	Status int
+	// RetryAfter is the time Spotify asked us to wait before sending another request, 0 if it did not
+	RetryAfter time.Duration
+	err        error
+}
+
+func (e *Error) Error() string {
+	if e.err == nil {
+		return e.Kind.Error()
+	}
+
+	return fmt.Sprintf("%s: %s", e.Kind, e.err)
+}
+
+func (e *Error) Unwrap() []error {
+	return []error{e.Kind, e.err}
+}
+
+// classify turns the error resulting from a response with the given status into an *Error, errors not
+// caused by Spotify are returned as they are.
+func classify(err error, status int, retryAfter time.Duration) error {
+	if err == nil {
+		return nil
+	}
+
+	// Refreshing the token fails before Spotify's API even gets called
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) && retrieveErr.Response != nil && retrieveErr.Response.StatusCode < http.StatusInternalServerError {
+		return &Error{Kind: ErrTokenRevoked, Status: retrieveErr.Response.StatusCode, err: err}
+	}
+
+	if status < http.StatusBadRequest {
+		return err
+	}
+
+	return &Error{Kind: kindOf(status, messageOf(err)), Status: status, RetryAfter: retryAfter, err: err}
+}
+
+// kindOf tells the kind of error by the status and message of the response. Spotify does not give a reason
+// for every error, resp. spotifyAPI.Error does not contain it, hence the message has to be looked at.
+func kindOf(status int, message string) error {
+	message = strings.ToLower(message)
+
+	switch {
+	case status == http.StatusUnauthorized:
+		return ErrTokenRevoked
+	case status == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case strings.Contains(message, "no active device"):
+		return ErrNoActiveDevice
+	case strings.Contains(message, "premium"):
+		return ErrPremiumRequired
+	case status == http.StatusForbidden && strings.Contains(message, "scope"):
+		return ErrScopeMissing
+	case status == http.StatusForbidden && (strings.Contains(message, "market") || strings.Contains(message, "restriction violated")):
+		return ErrContextUnavailableInMarket
+	case status == http.StatusNotFound:
+		return ErrNotFound
+	default:
+		return ErrUpstream
+	}
+}
+
+func messageOf(err error) string {
+	var apiErr spotifyAPI.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Message
+	}
+
+	return err.Error()
+}
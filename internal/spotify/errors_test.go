@@ -0,0 +1,81 @@
+package spotify
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	spotifyAPI "github.com/zmb3/spotify"
+	"golang.org/x/oauth2"
+)
+
+func TestErrorsAreClassified(t *testing.T) {
+	for _, tc := range []struct {
+		status     int
+		body       string
+		retryAfter string
+		expected   error
+	}{
+		{http.StatusNotFound, `{"error": {"status": 404, "message": "Player command failed: No active device found", "reason": "NO_ACTIVE_DEVICE"}}`, "", ErrNoActiveDevice},
+		{http.StatusForbidden, `{"error": {"status": 403, "message": "Player command failed: Premium required", "reason": "PREMIUM_REQUIRED"}}`, "", ErrPremiumRequired},
+		{http.StatusForbidden, `{"error": {"status": 403, "message": "Player command failed: Restriction violated", "reason": "UNKNOWN"}}`, "", ErrContextUnavailableInMarket},
+		{http.StatusForbidden, `{"error": {"status": 403, "message": "Insufficient client scope"}}`, "", ErrScopeMissing},
+		{http.StatusTooManyRequests, "", "5", ErrRateLimited},
+		{http.StatusUnauthorized, `{"error": {"status": 401, "message": "The access token expired"}}`, "", ErrTokenRevoked},
+		{http.StatusNotFound, `{"error": {"status": 404, "message": "Non existing id"}}`, "", ErrNotFound},
+		{http.StatusBadGateway, "", "", ErrUpstream},
+		{http.StatusBadRequest, `{"error": {"status": 400, "message": "Invalid request"}}`, "", ErrUpstream},
+	} {
+		t.Run(fmt.Sprintf("%d %s", tc.status, tc.expected), func(t *testing.T) {
+			client := NewSpotClient(&http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				return jsonResponse(tc.status, tc.body, tc.retryAfter), nil
+			})})
+
+			err := client.Pause(t.Context())
+
+			if !errors.Is(err, tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, err)
+			}
+
+			var spotifyErr *Error
+			if !errors.As(err, &spotifyErr) || spotifyErr.Status != tc.status {
+				t.Fatalf("expected *Error with status %d, got %v", tc.status, err)
+			}
+			if tc.retryAfter != "" && spotifyErr.RetryAfter != 5*time.Second {
+				t.Errorf("expected to be told to retry after 5s, got %s", spotifyErr.RetryAfter)
+			}
+		})
+	}
+}
+
+func TestFailedTokenRefreshMeansRevokedToken(t *testing.T) {
+	err := classify(&oauth2.RetrieveError{Response: &http.Response{StatusCode: http.StatusBadRequest}, ErrorCode: "invalid_grant"}, 0, 0)
+
+	if !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("expected ErrTokenRevoked, got %v", err)
+	}
+	if isRetryable(err) {
+		t.Error("expected revoked token not to be retried")
+	}
+}
+
+func TestClassifiedErrorsKeepOriginalError(t *testing.T) {
+	err := classify(spotifyAPI.Error{Status: http.StatusNotFound, Message: "Non existing id"}, http.StatusNotFound, 0)
+
+	var apiErr spotifyAPI.Error
+	if !errors.As(err, &apiErr) || apiErr.Message != "Non existing id" {
+		t.Fatalf("expected spotifyAPI.Error to be wrapped, got %v", err)
+	}
+
+	if err := fmt.Errorf("could not pause: %w", err); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected kind to survive wrapping, got %v", err)
+	}
+}
+
+func TestNoDeviceForPlaybackIsNoActiveDevice(t *testing.T) {
+	if !errors.Is(ErrNoActiveDeviceForPlayback, ErrNoActiveDevice) {
+		t.Fatal("expected ErrNoActiveDeviceForPlayback to be an ErrNoActiveDevice")
+	}
+}
@@ -1,8 +1,93 @@
 package spotify
 
+import (
+	"slices"
+	"strings"
+	"unicode"
+)
+
 func min(a, b int) int {
 	if a < b {
 		return a
 	}
 	return b
 }
+
+// minNameSimilarity is the similarity names need to have at least in order to be considered matching
+const minNameSimilarity = 0.8
+
+// mostSimilarByName returns the item whose name is the most similar to the given one, given their similarity
+// is at least minNameSimilarity. In case several items are equally similar the first one is returned.
+func mostSimilarByName(items []contextItem, name string) (contextItem, bool) {
+	normalizedName := normalizeName(name)
+	if normalizedName == "" {
+		return contextItem{}, false
+	}
+
+	var best contextItem
+	bestSimilarity := 0.0
+
+	for _, item := range items {
+		if similarity := similarityOf(normalizedName, normalizeName(item.Name)); similarity > bestSimilarity {
+			best = item
+			bestSimilarity = similarity
+		}
+	}
+
+	return best, bestSimilarity >= minNameSimilarity
+}
+
+// normalizeName lowercases the given name and reduces it to letters and digits separated by single spaces.
+func normalizeName(name string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+// similarityOf returns a value between 0 (entirely different) and 1 (equal) based on the Levenshtein distance.
+// Names of chapters usually differ by their number only, so names containing different numbers never match.
+func similarityOf(a, b string) float64 {
+	if !slices.Equal(numbersIn(a), numbersIn(b)) {
+		return 0
+	}
+
+	ra, rb := []rune(a), []rune(b)
+
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	if longest == 0 {
+		return 1
+	}
+
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+func numbersIn(name string) []string {
+	return strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsDigit(r)
+	})
+}
+
+func levenshtein(a, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(min(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
@@ -0,0 +1,59 @@
+package spotify
+
+import (
+	"encoding/json"
+
+	spotifyAPI "github.com/zmb3/spotify"
+)
+
+// PlayerState extends spotifyAPI.PlayerState by episodes and audiobook chapters. Unless asked to, Spotify does not
+// tell about episodes being played at all, spotifyAPI.PlayerState is only capable of tracks though.
+type PlayerState struct {
+	spotifyAPI.PlayerState
+	// CurrentlyPlayingType is one of "track", "episode", "ad" or "unknown"
+	CurrentlyPlayingType string `json:"currently_playing_type"`
+	// Episode is set instead of Item in case an episode is playing
+	Episode *spotifyAPI.EpisodePage `json:"-"`
+	// Chapter is set instead of Item in case a chapter of an audiobook is playing
+	Chapter *Chapter `json:"-"`
+}
+
+func (s *PlayerState) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		CurrentlyPlayingType string                     `json:"currently_playing_type"`
+		Context              spotifyAPI.PlaybackContext `json:"context"`
+		Item                 json.RawMessage            `json:"item"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, &s.PlayerState); err != nil {
+		return err
+	}
+	s.CurrentlyPlayingType = raw.CurrentlyPlayingType
+
+	if len(raw.Item) == 0 || string(raw.Item) == "null" {
+		return nil
+	}
+
+	var item struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(raw.Item, &item); err != nil {
+		return err
+	}
+
+	switch {
+	case item.Type == "chapter" || raw.Context.Type == "audiobook":
+		// Chapters might be reported as episodes, but they are played from their audiobook then
+		s.Item = nil
+		return json.Unmarshal(raw.Item, &s.Chapter)
+	case raw.CurrentlyPlayingType == "episode":
+		// The episode has been decoded as track, which lacks most of its details
+		s.Item = nil
+		return json.Unmarshal(raw.Item, &s.Episode)
+	default:
+		return nil
+	}
+}
@@ -9,186 +9,138 @@ package spotify
 // To be used with https://github.com/hexdigest/gowrap
 // Based on https://github.com/hexdigest/gowrap/blob/a00b5e810bdf0db43652c86216d4dfd2fc8c9afc/templates/retry
 import (
-	"time"
+	"context"
 
-	"github.com/rs/zerolog/log"
 	spotifyAPI "github.com/zmb3/spotify"
 )
 
 // SpotClientWithRetry implements SpotClient interface instrumented with retries
 type SpotClientWithRetry struct {
 	SpotClient
-	_retryCount int
-	_waitFor    time.Duration
+	_policy RetryPolicy
 }
 
 // NewSpotClientWithRetry returns SpotClientWithRetry
-func NewSpotClientWithRetry(base SpotClient, retryCount int, waitFor time.Duration) SpotClientWithRetry {
+func NewSpotClientWithRetry(base SpotClient, policy RetryPolicy) SpotClientWithRetry {
 	return SpotClientWithRetry{
-		SpotClient:  base,
-		_retryCount: retryCount,
-		_waitFor:    waitFor,
+		SpotClient: base,
+		_policy:    policy,
 	}
 }
 
 // CurrentUser implements SpotClient
-func (_d SpotClientWithRetry) CurrentUser() (pp1 *spotifyAPI.PrivateUser, err error) {
-	pp1, err = _d.SpotClient.CurrentUser()
-	if err == nil || _d._retryCount < 1 {
-		return
-	}
-	_ticker := time.NewTicker(_d._waitFor)
-	defer _ticker.Stop()
-	for _i := 0; _i < _d._retryCount && err != nil; _i++ {
-		<-_ticker.C
-		pp1, err = _d.SpotClient.CurrentUser()
-		if err != nil {
-			log.Warn().Msgf("Call to 'CurrentUser' only succeeded due to retrying %d time(s).", _i+1)
-		}
-	}
+func (_d SpotClientWithRetry) CurrentUser(ctx context.Context) (pp1 *spotifyAPI.PrivateUser, err error) {
+	err = _d._policy.do(ctx, "CurrentUser", func(ctx context.Context) error {
+		pp1, err = _d.SpotClient.CurrentUser(ctx)
+		return err
+	})
+	return
+}
+
+// CurrentUsersTracksOpt implements SpotClient
+func (_d SpotClientWithRetry) CurrentUsersTracksOpt(ctx context.Context, opt *spotifyAPI.Options) (pp1 *spotifyAPI.SavedTrackPage, err error) {
+	err = _d._policy.do(ctx, "CurrentUsersTracksOpt", func(ctx context.Context) error {
+		pp1, err = _d.SpotClient.CurrentUsersTracksOpt(ctx, opt)
+		return err
+	})
 	return
 }
 
 // GetAlbumTracksOpt implements SpotClient
-func (_d SpotClientWithRetry) GetAlbumTracksOpt(id spotifyAPI.ID, opt *spotifyAPI.Options) (sp1 *spotifyAPI.SimpleTrackPage, err error) {
-	sp1, err = _d.SpotClient.GetAlbumTracksOpt(id, opt)
-	if err == nil || _d._retryCount < 1 {
-		return
-	}
-	_ticker := time.NewTicker(_d._waitFor)
-	defer _ticker.Stop()
-	for _i := 0; _i < _d._retryCount && err != nil; _i++ {
-		<-_ticker.C
-		sp1, err = _d.SpotClient.GetAlbumTracksOpt(id, opt)
-		if err != nil {
-			log.Warn().Msgf("Call to 'GetAlbumTracksOpt' only succeeded due to retrying %d time(s).", _i+1)
-		}
-	}
+func (_d SpotClientWithRetry) GetAlbumTracksOpt(ctx context.Context, id spotifyAPI.ID, opt *spotifyAPI.Options) (sp1 *spotifyAPI.SimpleTrackPage, err error) {
+	err = _d._policy.do(ctx, "GetAlbumTracksOpt", func(ctx context.Context) error {
+		sp1, err = _d.SpotClient.GetAlbumTracksOpt(ctx, id, opt)
+		return err
+	})
+	return
+}
+
+// GetAudiobook implements SpotClient
+func (_d SpotClientWithRetry) GetAudiobook(ctx context.Context, id spotifyAPI.ID) (pp1 *Audiobook, err error) {
+	err = _d._policy.do(ctx, "GetAudiobook", func(ctx context.Context) error {
+		pp1, err = _d.SpotClient.GetAudiobook(ctx, id)
+		return err
+	})
+	return
+}
+
+// GetAudiobookChaptersOpt implements SpotClient
+func (_d SpotClientWithRetry) GetAudiobookChaptersOpt(ctx context.Context, id spotifyAPI.ID, opt *spotifyAPI.Options) (pp1 *ChapterPage, err error) {
+	err = _d._policy.do(ctx, "GetAudiobookChaptersOpt", func(ctx context.Context) error {
+		pp1, err = _d.SpotClient.GetAudiobookChaptersOpt(ctx, id, opt)
+		return err
+	})
 	return
 }
 
 // GetPlaylistOpt implements SpotClient
-func (_d SpotClientWithRetry) GetPlaylistOpt(playlistID spotifyAPI.ID, fields string) (fp1 *spotifyAPI.FullPlaylist, err error) {
-	fp1, err = _d.SpotClient.GetPlaylistOpt(playlistID, fields)
-	if err == nil || _d._retryCount < 1 {
-		return
-	}
-	_ticker := time.NewTicker(_d._waitFor)
-	defer _ticker.Stop()
-	for _i := 0; _i < _d._retryCount && err != nil; _i++ {
-		<-_ticker.C
-		fp1, err = _d.SpotClient.GetPlaylistOpt(playlistID, fields)
-		if err != nil {
-			log.Warn().Msgf("Call to 'GetPlaylistOpt' only succeeded due to retrying %d time(s).", _i+1)
-		}
-	}
+func (_d SpotClientWithRetry) GetPlaylistOpt(ctx context.Context, playlistID spotifyAPI.ID, fields string) (fp1 *spotifyAPI.FullPlaylist, err error) {
+	err = _d._policy.do(ctx, "GetPlaylistOpt", func(ctx context.Context) error {
+		fp1, err = _d.SpotClient.GetPlaylistOpt(ctx, playlistID, fields)
+		return err
+	})
 	return
 }
 
 // GetPlaylistTracksOpt implements SpotClient
-func (_d SpotClientWithRetry) GetPlaylistTracksOpt(playlistID spotifyAPI.ID, opt *spotifyAPI.Options, fields string) (pp1 *spotifyAPI.PlaylistTrackPage, err error) {
-	pp1, err = _d.SpotClient.GetPlaylistTracksOpt(playlistID, opt, fields)
-	if err == nil || _d._retryCount < 1 {
-		return
-	}
-	_ticker := time.NewTicker(_d._waitFor)
-	defer _ticker.Stop()
-	for _i := 0; _i < _d._retryCount && err != nil; _i++ {
-		<-_ticker.C
-		pp1, err = _d.SpotClient.GetPlaylistTracksOpt(playlistID, opt, fields)
-		if err != nil {
-			log.Warn().Msgf("Call to 'GetPlaylistTracksOpt' only succeeded due to retrying %d time(s).", _i+1)
-		}
-	}
+func (_d SpotClientWithRetry) GetPlaylistTracksOpt(ctx context.Context, playlistID spotifyAPI.ID, opt *spotifyAPI.Options, fields string) (pp1 *spotifyAPI.PlaylistTrackPage, err error) {
+	err = _d._policy.do(ctx, "GetPlaylistTracksOpt", func(ctx context.Context) error {
+		pp1, err = _d.SpotClient.GetPlaylistTracksOpt(ctx, playlistID, opt, fields)
+		return err
+	})
+	return
+}
+
+// GetShowEpisodesOpt implements SpotClient
+func (_d SpotClientWithRetry) GetShowEpisodesOpt(ctx context.Context, id spotifyAPI.ID, opt *spotifyAPI.Options) (pp1 *spotifyAPI.SimpleEpisodePage, err error) {
+	err = _d._policy.do(ctx, "GetShowEpisodesOpt", func(ctx context.Context) error {
+		pp1, err = _d.SpotClient.GetShowEpisodesOpt(ctx, id, opt)
+		return err
+	})
 	return
 }
 
 // Pause implements SpotClient
-func (_d SpotClientWithRetry) Pause() (err error) {
-	err = _d.SpotClient.Pause()
-	if err == nil || _d._retryCount < 1 {
-		return
-	}
-	_ticker := time.NewTicker(_d._waitFor)
-	defer _ticker.Stop()
-	for _i := 0; _i < _d._retryCount && err != nil; _i++ {
-		<-_ticker.C
-		err = _d.SpotClient.Pause()
-		if err != nil {
-			log.Warn().Msgf("Call to 'Pause' only succeeded due to retrying %d time(s).", _i+1)
-		}
-	}
+func (_d SpotClientWithRetry) Pause(ctx context.Context) (err error) {
+	err = _d._policy.do(ctx, "Pause", func(ctx context.Context) error {
+		err = _d.SpotClient.Pause(ctx)
+		return err
+	})
 	return
 }
 
 // PlayOpt implements SpotClient
-func (_d SpotClientWithRetry) PlayOpt(opt *spotifyAPI.PlayOptions) (err error) {
-	err = _d.SpotClient.PlayOpt(opt)
-	if err == nil || _d._retryCount < 1 {
-		return
-	}
-	_ticker := time.NewTicker(_d._waitFor)
-	defer _ticker.Stop()
-	for _i := 0; _i < _d._retryCount && err != nil; _i++ {
-		<-_ticker.C
-		err = _d.SpotClient.PlayOpt(opt)
-		if err != nil {
-			log.Warn().Msgf("Call to 'PlayOpt' only succeeded due to retrying %d time(s).", _i+1)
-		}
-	}
+func (_d SpotClientWithRetry) PlayOpt(ctx context.Context, opt *spotifyAPI.PlayOptions) (err error) {
+	err = _d._policy.do(ctx, "PlayOpt", func(ctx context.Context) error {
+		err = _d.SpotClient.PlayOpt(ctx, opt)
+		return err
+	})
 	return
 }
 
 // PlayerDevices implements SpotClient
-func (_d SpotClientWithRetry) PlayerDevices() (pa1 []spotifyAPI.PlayerDevice, err error) {
-	pa1, err = _d.SpotClient.PlayerDevices()
-	if err == nil || _d._retryCount < 1 {
-		return
-	}
-	_ticker := time.NewTicker(_d._waitFor)
-	defer _ticker.Stop()
-	for _i := 0; _i < _d._retryCount && err != nil; _i++ {
-		<-_ticker.C
-		pa1, err = _d.SpotClient.PlayerDevices()
-		if err != nil {
-			log.Warn().Msgf("Call to 'PlayerDevices' only succeeded due to retrying %d time(s).", _i+1)
-		}
-	}
+func (_d SpotClientWithRetry) PlayerDevices(ctx context.Context) (pa1 []spotifyAPI.PlayerDevice, err error) {
+	err = _d._policy.do(ctx, "PlayerDevices", func(ctx context.Context) error {
+		pa1, err = _d.SpotClient.PlayerDevices(ctx)
+		return err
+	})
 	return
 }
 
 // PlayerState implements SpotClient
-func (_d SpotClientWithRetry) PlayerState() (pp1 *spotifyAPI.PlayerState, err error) {
-	pp1, err = _d.SpotClient.PlayerState()
-	if err == nil || _d._retryCount < 1 {
-		return
-	}
-	_ticker := time.NewTicker(_d._waitFor)
-	defer _ticker.Stop()
-	for _i := 0; _i < _d._retryCount && err != nil; _i++ {
-		<-_ticker.C
-		pp1, err = _d.SpotClient.PlayerState()
-		if err != nil {
-			log.Warn().Msgf("Call to 'PlayerState' only succeeded due to retrying %d time(s).", _i+1)
-		}
-	}
+func (_d SpotClientWithRetry) PlayerState(ctx context.Context) (pp1 *PlayerState, err error) {
+	err = _d._policy.do(ctx, "PlayerState", func(ctx context.Context) error {
+		pp1, err = _d.SpotClient.PlayerState(ctx)
+		return err
+	})
 	return
 }
 
 // Shuffle implements SpotClient
-func (_d SpotClientWithRetry) Shuffle(shuffle bool) (err error) {
-	err = _d.SpotClient.Shuffle(shuffle)
-	if err == nil || _d._retryCount < 1 {
-		return
-	}
-	_ticker := time.NewTicker(_d._waitFor)
-	defer _ticker.Stop()
-	for _i := 0; _i < _d._retryCount && err != nil; _i++ {
-		<-_ticker.C
-		err = _d.SpotClient.Shuffle(shuffle)
-		if err != nil {
-			log.Warn().Msgf("Call to 'Shuffle' only succeeded due to retrying %d time(s).", _i+1)
-		}
-	}
+func (_d SpotClientWithRetry) Shuffle(ctx context.Context, shuffle bool) (err error) {
+	err = _d._policy.do(ctx, "Shuffle", func(ctx context.Context) error {
+		err = _d.SpotClient.Shuffle(ctx, shuffle)
+		return err
+	})
 	return
 }
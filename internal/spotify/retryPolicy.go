@@ -0,0 +1,178 @@
+package spotify
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog/log"
+	spotifyAPI "github.com/zmb3/spotify"
+)
+
+// Decisions taken by RetryPolicy after a call, exposed as label of the retry metric
+const (
+	decisionRetry             = "retry"
+	decisionRecovered         = "recovered"
+	decisionTerminal          = "terminal"
+	decisionAttemptsExhausted = "attempts_exhausted"
+	decisionBudgetExhausted   = "budget_exhausted"
+	decisionCanceled          = "canceled"
+)
+
+var (
+	errBudgetExhausted = errors.New("retry budget exhausted")
+
+	retryDecisionsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cassette",
+		Subsystem: "spotify",
+		Name:      "retry_decisions_total",
+		Help:      "Number of decisions taken after failed calls to Spotify's API (resp. successful ones after retrying) by method and decision.",
+	}, []string{"method", "decision"})
+)
+
+// RetryPolicy decides whether and when failed calls to Spotify's API get retried. Only server errors,
+// rate limiting and network failures are retried, errors caused by the request itself (e.g. there being
+// no active device) are returned right away.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts including the first one
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, it doubles with every further one up to MaxDelay.
+	// The actual delay is randomized between half of it and the full delay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// MaxTotal caps the time spent on a call including all attempts and delays, 0 means there is no cap.
+	// A delay requested by Spotify via 'Retry-After' is honoured as long as it fits into this budget.
+	MaxTotal time.Duration
+}
+
+// do calls the given function until it succeeds or the policy decides to give up, returning the last error.
+func (p RetryPolicy) do(ctx context.Context, method string, call func(ctx context.Context) error) error {
+	if p.MaxTotal > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, p.MaxTotal, errBudgetExhausted)
+		defer cancel()
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := call(ctx)
+		if err == nil {
+			if attempt > 1 {
+				retryDecisionsCounter.WithLabelValues(method, decisionRecovered).Inc()
+				log.Debug().Msgf("Call to '%s' succeeded after retrying %d time(s).", method, attempt-1)
+			}
+
+			return nil
+		}
+
+		delay, decision := p.next(ctx, attempt, err)
+		retryDecisionsCounter.WithLabelValues(method, decision).Inc()
+
+		if decision != decisionRetry {
+			if attempt > 1 {
+				log.Warn().Err(err).Msgf("Call to '%s' failed despite retrying %d time(s), giving up: %s.", method, attempt-1, decision)
+			}
+
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			retryDecisionsCounter.WithLabelValues(method, p.cancellationDecision(ctx)).Inc()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// next decides how to proceed after the given attempt failed with err, returning the delay before the next attempt.
+func (p RetryPolicy) next(ctx context.Context, attempt int, err error) (time.Duration, string) {
+	if ctx.Err() != nil {
+		return 0, p.cancellationDecision(ctx)
+	}
+
+	if !isRetryable(err) {
+		return 0, decisionTerminal
+	}
+
+	if attempt >= p.MaxAttempts {
+		return 0, decisionAttemptsExhausted
+	}
+
+	delay := p.backoff(attempt)
+
+	var spotifyErr *Error
+	if errors.As(err, &spotifyErr) && spotifyErr.RetryAfter > 0 {
+		delay = spotifyErr.RetryAfter
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+		return 0, decisionBudgetExhausted
+	}
+
+	return delay, decisionRetry
+}
+
+// backoff returns the randomized, exponentially growing delay before the retry following the given attempt.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+
+		if p.MaxDelay > 0 && delay >= p.MaxDelay {
+			delay = p.MaxDelay
+			break
+		}
+	}
+
+	if delay <= 0 {
+		return 0
+	}
+
+	return delay/2 + rand.N(delay/2+1)
+}
+
+func (p RetryPolicy) cancellationDecision(ctx context.Context) string {
+	if errors.Is(context.Cause(ctx), errBudgetExhausted) {
+		return decisionBudgetExhausted
+	}
+
+	return decisionCanceled
+}
+
+// isRetryable tells whether retrying the call which failed with the given error might succeed.
+func isRetryable(err error) bool {
+	if errors.Is(err, ErrTokenRevoked) {
+		return false
+	}
+
+	status := 0
+
+	var spotifyErr *Error
+	var apiErr spotifyAPI.Error
+	switch {
+	case errors.As(err, &spotifyErr):
+		status = spotifyErr.Status
+	case errors.As(err, &apiErr):
+		status = apiErr.Status
+	}
+
+	switch status {
+	case 0:
+		// The request did not get a response at all, e.g. because the connection has been reset
+		// or the attempt timed out
+		var urlErr *url.Error
+		return errors.As(err, &urlErr) || errors.Is(err, context.DeadlineExceeded)
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
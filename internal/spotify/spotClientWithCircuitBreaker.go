@@ -0,0 +1,145 @@
+package spotify
+
+// DO NOT EDIT!
+// This code is generated with http://github.com/hexdigest/gowrap tool
+// using circuitBreakerWrapper.tmpl template
+
+//go:generate gowrap gen -p github.com/florianloch/cassette/internal/spotify -i SpotClient -t circuitBreakerWrapper.tmpl -o spotClientWithCircuitBreaker.go
+
+// To be used with https://github.com/hexdigest/gowrap
+import (
+	"context"
+
+	spotifyAPI "github.com/zmb3/spotify"
+)
+
+// SpotClientWithCircuitBreaker implements SpotClient interface instrumented with circuit breakers
+type SpotClientWithCircuitBreaker struct {
+	SpotClient
+	_breakers *CircuitBreakers
+}
+
+// NewSpotClientWithCircuitBreaker returns SpotClientWithCircuitBreaker
+func NewSpotClientWithCircuitBreaker(base SpotClient, breakers *CircuitBreakers) SpotClientWithCircuitBreaker {
+	return SpotClientWithCircuitBreaker{
+		SpotClient: base,
+		_breakers:  breakers,
+	}
+}
+
+// CurrentUser implements SpotClient
+func (_d SpotClientWithCircuitBreaker) CurrentUser(ctx context.Context) (pp1 *spotifyAPI.PrivateUser, err error) {
+	err = _d._breakers.do(ctx, "CurrentUser", func() error {
+		pp1, err = _d.SpotClient.CurrentUser(ctx)
+		return err
+	})
+	return
+}
+
+// CurrentUsersTracksOpt implements SpotClient
+func (_d SpotClientWithCircuitBreaker) CurrentUsersTracksOpt(ctx context.Context, opt *spotifyAPI.Options) (pp1 *spotifyAPI.SavedTrackPage, err error) {
+	err = _d._breakers.do(ctx, "CurrentUsersTracksOpt", func() error {
+		pp1, err = _d.SpotClient.CurrentUsersTracksOpt(ctx, opt)
+		return err
+	})
+	return
+}
+
+// GetAlbumTracksOpt implements SpotClient
+func (_d SpotClientWithCircuitBreaker) GetAlbumTracksOpt(ctx context.Context, id spotifyAPI.ID, opt *spotifyAPI.Options) (sp1 *spotifyAPI.SimpleTrackPage, err error) {
+	err = _d._breakers.do(ctx, "GetAlbumTracksOpt", func() error {
+		sp1, err = _d.SpotClient.GetAlbumTracksOpt(ctx, id, opt)
+		return err
+	})
+	return
+}
+
+// GetAudiobook implements SpotClient
+func (_d SpotClientWithCircuitBreaker) GetAudiobook(ctx context.Context, id spotifyAPI.ID) (pp1 *Audiobook, err error) {
+	err = _d._breakers.do(ctx, "GetAudiobook", func() error {
+		pp1, err = _d.SpotClient.GetAudiobook(ctx, id)
+		return err
+	})
+	return
+}
+
+// GetAudiobookChaptersOpt implements SpotClient
+func (_d SpotClientWithCircuitBreaker) GetAudiobookChaptersOpt(ctx context.Context, id spotifyAPI.ID, opt *spotifyAPI.Options) (pp1 *ChapterPage, err error) {
+	err = _d._breakers.do(ctx, "GetAudiobookChaptersOpt", func() error {
+		pp1, err = _d.SpotClient.GetAudiobookChaptersOpt(ctx, id, opt)
+		return err
+	})
+	return
+}
+
+// GetPlaylistOpt implements SpotClient
+func (_d SpotClientWithCircuitBreaker) GetPlaylistOpt(ctx context.Context, playlistID spotifyAPI.ID, fields string) (fp1 *spotifyAPI.FullPlaylist, err error) {
+	err = _d._breakers.do(ctx, "GetPlaylistOpt", func() error {
+		fp1, err = _d.SpotClient.GetPlaylistOpt(ctx, playlistID, fields)
+		return err
+	})
+	return
+}
+
+// GetPlaylistTracksOpt implements SpotClient
+func (_d SpotClientWithCircuitBreaker) GetPlaylistTracksOpt(ctx context.Context, playlistID spotifyAPI.ID, opt *spotifyAPI.Options, fields string) (pp1 *spotifyAPI.PlaylistTrackPage, err error) {
+	err = _d._breakers.do(ctx, "GetPlaylistTracksOpt", func() error {
+		pp1, err = _d.SpotClient.GetPlaylistTracksOpt(ctx, playlistID, opt, fields)
+		return err
+	})
+	return
+}
+
+// GetShowEpisodesOpt implements SpotClient
+func (_d SpotClientWithCircuitBreaker) GetShowEpisodesOpt(ctx context.Context, id spotifyAPI.ID, opt *spotifyAPI.Options) (pp1 *spotifyAPI.SimpleEpisodePage, err error) {
+	err = _d._breakers.do(ctx, "GetShowEpisodesOpt", func() error {
+		pp1, err = _d.SpotClient.GetShowEpisodesOpt(ctx, id, opt)
+		return err
+	})
+	return
+}
+
+// Pause implements SpotClient
+func (_d SpotClientWithCircuitBreaker) Pause(ctx context.Context) (err error) {
+	err = _d._breakers.do(ctx, "Pause", func() error {
+		err = _d.SpotClient.Pause(ctx)
+		return err
+	})
+	return
+}
+
+// PlayOpt implements SpotClient
+func (_d SpotClientWithCircuitBreaker) PlayOpt(ctx context.Context, opt *spotifyAPI.PlayOptions) (err error) {
+	err = _d._breakers.do(ctx, "PlayOpt", func() error {
+		err = _d.SpotClient.PlayOpt(ctx, opt)
+		return err
+	})
+	return
+}
+
+// PlayerDevices implements SpotClient
+func (_d SpotClientWithCircuitBreaker) PlayerDevices(ctx context.Context) (pa1 []spotifyAPI.PlayerDevice, err error) {
+	err = _d._breakers.do(ctx, "PlayerDevices", func() error {
+		pa1, err = _d.SpotClient.PlayerDevices(ctx)
+		return err
+	})
+	return
+}
+
+// PlayerState implements SpotClient
+func (_d SpotClientWithCircuitBreaker) PlayerState(ctx context.Context) (pp1 *PlayerState, err error) {
+	err = _d._breakers.do(ctx, "PlayerState", func() error {
+		pp1, err = _d.SpotClient.PlayerState(ctx)
+		return err
+	})
+	return
+}
+
+// Shuffle implements SpotClient
+func (_d SpotClientWithCircuitBreaker) Shuffle(ctx context.Context, shuffle bool) (err error) {
+	err = _d._breakers.do(ctx, "Shuffle", func() error {
+		err = _d.SpotClient.Shuffle(ctx, shuffle)
+		return err
+	})
+	return
+}
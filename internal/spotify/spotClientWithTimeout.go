@@ -0,0 +1,186 @@
+package spotify
+
+// DO NOT EDIT!
+// This code is generated with http://github.com/hexdigest/gowrap tool
+// using timeout template
+
+//go:generate gowrap gen -p github.com/florianloch/cassette/internal/spotify -i SpotClient -t timeout -o spotClientWithTimeout.go
+
+import (
+	"context"
+	"time"
+
+	spotifyAPI "github.com/zmb3/spotify"
+)
+
+// SpotClientWithTimeout implements SpotClient interface instrumented with timeouts
+type SpotClientWithTimeout struct {
+	SpotClient
+	config SpotClientWithTimeoutConfig
+}
+
+type SpotClientWithTimeoutConfig struct {
+	CurrentUserTimeout time.Duration
+
+	CurrentUsersTracksOptTimeout time.Duration
+
+	GetAlbumTracksOptTimeout time.Duration
+
+	GetAudiobookTimeout time.Duration
+
+	GetAudiobookChaptersOptTimeout time.Duration
+
+	GetPlaylistOptTimeout time.Duration
+
+	GetPlaylistTracksOptTimeout time.Duration
+
+	GetShowEpisodesOptTimeout time.Duration
+
+	PauseTimeout time.Duration
+
+	PlayOptTimeout time.Duration
+
+	PlayerDevicesTimeout time.Duration
+
+	PlayerStateTimeout time.Duration
+
+	ShuffleTimeout time.Duration
+}
+
+// NewSpotClientWithTimeout returns SpotClientWithTimeout
+func NewSpotClientWithTimeout(base SpotClient, config SpotClientWithTimeoutConfig) SpotClientWithTimeout {
+	return SpotClientWithTimeout{
+		SpotClient: base,
+		config:     config,
+	}
+}
+
+// CurrentUser implements SpotClient
+func (_d SpotClientWithTimeout) CurrentUser(ctx context.Context) (pp1 *spotifyAPI.PrivateUser, err error) {
+	var cancelFunc func()
+	if _d.config.CurrentUserTimeout > 0 {
+		ctx, cancelFunc = context.WithTimeout(ctx, _d.config.CurrentUserTimeout)
+		defer cancelFunc()
+	}
+	return _d.SpotClient.CurrentUser(ctx)
+}
+
+// CurrentUsersTracksOpt implements SpotClient
+func (_d SpotClientWithTimeout) CurrentUsersTracksOpt(ctx context.Context, opt *spotifyAPI.Options) (pp1 *spotifyAPI.SavedTrackPage, err error) {
+	var cancelFunc func()
+	if _d.config.CurrentUsersTracksOptTimeout > 0 {
+		ctx, cancelFunc = context.WithTimeout(ctx, _d.config.CurrentUsersTracksOptTimeout)
+		defer cancelFunc()
+	}
+	return _d.SpotClient.CurrentUsersTracksOpt(ctx, opt)
+}
+
+// GetAlbumTracksOpt implements SpotClient
+func (_d SpotClientWithTimeout) GetAlbumTracksOpt(ctx context.Context, id spotifyAPI.ID, opt *spotifyAPI.Options) (sp1 *spotifyAPI.SimpleTrackPage, err error) {
+	var cancelFunc func()
+	if _d.config.GetAlbumTracksOptTimeout > 0 {
+		ctx, cancelFunc = context.WithTimeout(ctx, _d.config.GetAlbumTracksOptTimeout)
+		defer cancelFunc()
+	}
+	return _d.SpotClient.GetAlbumTracksOpt(ctx, id, opt)
+}
+
+// GetAudiobook implements SpotClient
+func (_d SpotClientWithTimeout) GetAudiobook(ctx context.Context, id spotifyAPI.ID) (pp1 *Audiobook, err error) {
+	var cancelFunc func()
+	if _d.config.GetAudiobookTimeout > 0 {
+		ctx, cancelFunc = context.WithTimeout(ctx, _d.config.GetAudiobookTimeout)
+		defer cancelFunc()
+	}
+	return _d.SpotClient.GetAudiobook(ctx, id)
+}
+
+// GetAudiobookChaptersOpt implements SpotClient
+func (_d SpotClientWithTimeout) GetAudiobookChaptersOpt(ctx context.Context, id spotifyAPI.ID, opt *spotifyAPI.Options) (pp1 *ChapterPage, err error) {
+	var cancelFunc func()
+	if _d.config.GetAudiobookChaptersOptTimeout > 0 {
+		ctx, cancelFunc = context.WithTimeout(ctx, _d.config.GetAudiobookChaptersOptTimeout)
+		defer cancelFunc()
+	}
+	return _d.SpotClient.GetAudiobookChaptersOpt(ctx, id, opt)
+}
+
+// GetPlaylistOpt implements SpotClient
+func (_d SpotClientWithTimeout) GetPlaylistOpt(ctx context.Context, playlistID spotifyAPI.ID, fields string) (fp1 *spotifyAPI.FullPlaylist, err error) {
+	var cancelFunc func()
+	if _d.config.GetPlaylistOptTimeout > 0 {
+		ctx, cancelFunc = context.WithTimeout(ctx, _d.config.GetPlaylistOptTimeout)
+		defer cancelFunc()
+	}
+	return _d.SpotClient.GetPlaylistOpt(ctx, playlistID, fields)
+}
+
+// GetPlaylistTracksOpt implements SpotClient
+func (_d SpotClientWithTimeout) GetPlaylistTracksOpt(ctx context.Context, playlistID spotifyAPI.ID, opt *spotifyAPI.Options, fields string) (pp1 *spotifyAPI.PlaylistTrackPage, err error) {
+	var cancelFunc func()
+	if _d.config.GetPlaylistTracksOptTimeout > 0 {
+		ctx, cancelFunc = context.WithTimeout(ctx, _d.config.GetPlaylistTracksOptTimeout)
+		defer cancelFunc()
+	}
+	return _d.SpotClient.GetPlaylistTracksOpt(ctx, playlistID, opt, fields)
+}
+
+// GetShowEpisodesOpt implements SpotClient
+func (_d SpotClientWithTimeout) GetShowEpisodesOpt(ctx context.Context, id spotifyAPI.ID, opt *spotifyAPI.Options) (pp1 *spotifyAPI.SimpleEpisodePage, err error) {
+	var cancelFunc func()
+	if _d.config.GetShowEpisodesOptTimeout > 0 {
+		ctx, cancelFunc = context.WithTimeout(ctx, _d.config.GetShowEpisodesOptTimeout)
+		defer cancelFunc()
+	}
+	return _d.SpotClient.GetShowEpisodesOpt(ctx, id, opt)
+}
+
+// Pause implements SpotClient
+func (_d SpotClientWithTimeout) Pause(ctx context.Context) (err error) {
+	var cancelFunc func()
+	if _d.config.PauseTimeout > 0 {
+		ctx, cancelFunc = context.WithTimeout(ctx, _d.config.PauseTimeout)
+		defer cancelFunc()
+	}
+	return _d.SpotClient.Pause(ctx)
+}
+
+// PlayOpt implements SpotClient
+func (_d SpotClientWithTimeout) PlayOpt(ctx context.Context, opt *spotifyAPI.PlayOptions) (err error) {
+	var cancelFunc func()
+	if _d.config.PlayOptTimeout > 0 {
+		ctx, cancelFunc = context.WithTimeout(ctx, _d.config.PlayOptTimeout)
+		defer cancelFunc()
+	}
+	return _d.SpotClient.PlayOpt(ctx, opt)
+}
+
+// PlayerDevices implements SpotClient
+func (_d SpotClientWithTimeout) PlayerDevices(ctx context.Context) (pa1 []spotifyAPI.PlayerDevice, err error) {
+	var cancelFunc func()
+	if _d.config.PlayerDevicesTimeout > 0 {
+		ctx, cancelFunc = context.WithTimeout(ctx, _d.config.PlayerDevicesTimeout)
+		defer cancelFunc()
+	}
+	return _d.SpotClient.PlayerDevices(ctx)
+}
+
+// PlayerState implements SpotClient
+func (_d SpotClientWithTimeout) PlayerState(ctx context.Context) (pp1 *PlayerState, err error) {
+	var cancelFunc func()
+	if _d.config.PlayerStateTimeout > 0 {
+		ctx, cancelFunc = context.WithTimeout(ctx, _d.config.PlayerStateTimeout)
+		defer cancelFunc()
+	}
+	return _d.SpotClient.PlayerState(ctx)
+}
+
+// Shuffle implements SpotClient
+func (_d SpotClientWithTimeout) Shuffle(ctx context.Context, shuffle bool) (err error) {
+	var cancelFunc func()
+	if _d.config.ShuffleTimeout > 0 {
+		ctx, cancelFunc = context.WithTimeout(ctx, _d.config.ShuffleTimeout)
+		defer cancelFunc()
+	}
+	return _d.SpotClient.Shuffle(ctx, shuffle)
+}
@@ -1,9 +1,11 @@
 package spotify
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -15,35 +17,72 @@ import (
 
 const (
 	pagingLimit = 50
+	// maxConcurrentPages is the number of pages of a context being fetched at the same time at most
+	maxConcurrentPages = 4
+	// hintLeeway is the number of items before the hinted position which are looked at as well
+	hintLeeway = 5
+	// likedSongs is the name Spotify's apps use for the user's collection
+	likedSongs = "Liked Songs"
 )
 
 var (
 	ErrTrackNotFoundInContext    = errors.New("could not find track in context")
-	ErrNoActiveDeviceForPlayback = errors.New("no (active) device available for playback")
-	ErrContextNotSuspendable     = errors.New("the current context cannot be restored! It is only possible to store playing positions in albums and playlists")
+	ErrNoActiveDeviceForPlayback = fmt.Errorf("%w: no device available for playback", ErrNoActiveDevice)
+	ErrContextNotSuspendable     = errors.New("the current context cannot be restored! It is only possible to store playing positions in albums, playlists, Liked Songs, artists, podcasts and audiobooks")
 )
 
-func isContextSuspendable(playbackContext spotifyAPI.PlaybackContext) bool {
-	t := playbackContext.Type
+func isContextSuspendable(playerState *PlayerState) bool {
+	t := playerState.PlaybackContext.Type
 
-	return t == "album" || t == "playlist"
+	if playerState.Chapter != nil {
+		// Without context the chapter's audiobook has to be known for locating the chapter
+		return t == "audiobook" || (t == "" && playerState.Chapter.Audiobook != nil && playerState.Chapter.Audiobook.URI != "")
+	}
+
+	if playerState.Episode != nil {
+		// Episodes might also be played without any context, e.g. from the list of new episodes
+		return t == "show" || t == "playlist" || t == ""
+	}
+
+	return playerState.Item != nil && (t == "album" || t == "playlist" || t == "collection" || t == "artist")
 }
 
-func CurrentPlayerState(client SpotClient) (*persistence.PlayerState, error) {
-	playerState, err := client.PlayerState()
-	var shuffleActivated bool
+// CurrentPlayerState describes what is currently playing. The states saved before hint at where to look for the item
+// within its context, the cache might be nil.
+func CurrentPlayerState(ctx context.Context, client SpotClient, cache *ContextCache, previousStates []*persistence.PlayerState) (*persistence.PlayerState, error) {
+	playerState, err := client.PlayerState(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("could not read whats currently playing: %w", err)
 	}
-	shuffleActivated = playerState.ShuffleState
-
-	currentlyPlaying := &playerState.CurrentlyPlaying
 
 	//Check whether this position could possibly restored afterwards
-	if !isContextSuspendable(currentlyPlaying.PlaybackContext) {
+	if !isContextSuspendable(playerState) {
 		return nil, ErrContextNotSuspendable
 	}
 
+	var state *persistence.PlayerState
+	switch {
+	case playerState.Chapter != nil:
+		state = currentChapterState(ctx, client, cache, previousStates, playerState)
+	case playerState.Episode != nil:
+		state = currentEpisodeState(ctx, client, cache, previousStates, playerState)
+	default:
+		state, err = currentTrackState(ctx, client, cache, previousStates, playerState)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state.Progress = playerState.Progress
+	state.ShuffleActivated = playerState.ShuffleState
+	state.SuspendedAtTs = time.Now().Unix()
+
+	return state, nil
+}
+
+func currentTrackState(ctx context.Context, client SpotClient, cache *ContextCache, previousStates []*persistence.PlayerState, playerState *PlayerState) (*persistence.PlayerState, error) {
+	currentlyPlaying := &playerState.CurrentlyPlaying
+
 	item := currentlyPlaying.Item
 	joinedArtists := ""
 	for idx, artist := range item.Artists {
@@ -53,23 +92,32 @@ func CurrentPlayerState(client SpotClient) (*persistence.PlayerState, error) {
 		}
 	}
 
-	// Ensure there are two URLs in the 'Images' slice
-	images := item.Album.Images
-	if len(images) == 0 {
-		// Kind of an assert, should not happen. In case it does it's not too important though
-		log.Error().Interface("item", item).Msg("No image URL provided for currently playing item.")
+	albumArtLargeURL, albumArtMediumURL := twoImageURLs(item.Album.Images, item)
 
-		item.Album.Images = append(images, spotifyAPI.Image{
-			URL: "",
-		})
+	// Playback of an artist cannot be started at a given track. As artists get played album by album,
+	// the track's album is resumed instead.
+	playbackContextURI := currentlyPlaying.PlaybackContext.URI
+	indexContext := currentlyPlaying.PlaybackContext
+	if indexContext.Type == "artist" {
+		playbackContextURI = item.Album.URI
+		indexContext = spotifyAPI.PlaybackContext{Type: "album", URI: item.Album.URI}
 	}
-	if len(images) == 1 {
-		log.Error().Interface("item", item).Msg("Just one URL provided for currently playing item.")
 
-		item.Album.Images = append(images, images[0])
+	// Relinked tracks are contained in their context as the track they have been relinked from
+	track := contextItem{ID: item.ID, URI: item.URI, Name: item.Name, Duration: item.Duration}
+	if item.LinkedFrom != nil {
+		track.LinkedFromID = item.LinkedFrom.ID
+		if item.LinkedFrom.URI != "" {
+			track.URI = spotifyAPI.URI(item.LinkedFrom.URI)
+		}
 	}
 
-	trackIndex, totalTracks, err := indexOfCurrentTrack(currentlyPlaying, client)
+	trackIndex, totalTracks, err := indexOfCurrentTrack(ctx, client, cache, indexContext, positionHint(previousStates, indexContext), track)
+	if errors.Is(err, ErrScopeMissing) {
+		// Sessions started before reading the user's library has been requested cannot look into Liked Songs,
+		// the user has to log in again instead of getting a slot which cannot be resumed at the right track
+		return nil, fmt.Errorf("could not get index of track in context: %w", err)
+	}
 	if err != nil {
 		// No need to stop processing this request because of this error...
 		log.Error().Err(err).Interface("item", item).Msg("Could not get index of track in context.")
@@ -84,60 +132,221 @@ func CurrentPlayerState(client SpotClient) (*persistence.PlayerState, error) {
 			Msg("Could not get link to context from response.")
 	}
 
-	playlistName := ""
-	if currentlyPlaying.PlaybackContext.Type == "playlist" {
-		playlistID := idOfContext(currentlyPlaying)
-		playlist, err := client.GetPlaylistOpt(playlistID, "name")
-		if err != nil {
-			// No need to stop processing this request because of this error...
-			log.Error().Err(err).Str("playlistID", string(playlistID)).Msg("Could not get name of playlist.")
-		} else {
-			playlistName = playlist.Name
-		}
-	}
-
 	return &persistence.PlayerState{
-		PlaybackContextURI: string(currentlyPlaying.PlaybackContext.URI),
-		PlaybackItemURI:    string(item.URI),
+		PlaybackContextURI: string(playbackContextURI),
+		PlaybackItemURI:    string(track.URI),
 		LinkToContext:      linkToContext,
 		ContextType:        currentlyPlaying.PlaybackContext.Type,
-		PlaylistName:       playlistName,
-		AlbumArtLargeURL:   item.Album.Images[0].URL,
-		AlbumArtMediumURL:  item.Album.Images[1].URL,
+		PlaylistName:       playlistName(ctx, client, currentlyPlaying.PlaybackContext),
+		AlbumArtLargeURL:   albumArtLargeURL,
+		AlbumArtMediumURL:  albumArtMediumURL,
 		TrackName:          item.Name,
 		AlbumName:          item.Album.Name,
 		ArtistName:         joinedArtists,
 		TrackIndex:         trackIndex,
 		TotalTracks:        totalTracks,
-		Progress:           currentlyPlaying.Progress,
 		Duration:           item.Duration,
-		ShuffleActivated:   shuffleActivated,
-		SuspendedAtTs:      time.Now().Unix(),
 	}, nil
 }
 
-func RestorePlayerState(client SpotClient, stateToLoad *persistence.PlayerState, deviceID string) error {
-	err := client.Shuffle(stateToLoad.ShuffleActivated)
+// currentEpisodeState describes the episode being played, its show takes the part of the album.
+func currentEpisodeState(ctx context.Context, client SpotClient, cache *ContextCache, previousStates []*persistence.PlayerState, playerState *PlayerState) *persistence.PlayerState {
+	playbackContext := playerState.PlaybackContext
+	episode := playerState.Episode
+
+	// Not every episode has its own artwork
+	images := episode.Images
+	if len(images) == 0 {
+		images = episode.Show.Images
+	}
+	albumArtLargeURL, albumArtMediumURL := twoImageURLs(images, episode)
+
+	// Unless played from a playlist the episode gets located within its show
+	indexContext := playbackContext
+	if playbackContext.Type != "playlist" {
+		indexContext = spotifyAPI.PlaybackContext{Type: "show", URI: episode.Show.URI}
+	}
+
+	episodeIndex, totalEpisodes, err := indexOfCurrentTrack(ctx, client, cache, indexContext, positionHint(previousStates, indexContext), contextItem{ID: episode.ID, URI: episode.URI, Name: episode.Name, Duration: episode.Duration_ms})
 	if err != nil {
-		return err
+		// No need to stop processing this request because of this error...
+		log.Error().Err(err).Interface("episode", episode).Msg("Could not get index of episode in context.")
+	}
+
+	linkToContext, ok := playbackContext.ExternalURLs["spotify"]
+	if !ok {
+		// Played without context, so the episode is the best we can link to
+		linkToContext = episode.ExternalURLs["spotify"]
+	}
+
+	return &persistence.PlayerState{
+		PlaybackContextURI: string(playbackContext.URI),
+		PlaybackItemURI:    string(episode.URI),
+		LinkToContext:      linkToContext,
+		ContextType:        indexContext.Type,
+		PlaylistName:       playlistName(ctx, client, playbackContext),
+		AlbumArtLargeURL:   albumArtLargeURL,
+		AlbumArtMediumURL:  albumArtMediumURL,
+		TrackName:          episode.Name,
+		AlbumName:          episode.Show.Name,
+		ArtistName:         episode.Show.Publisher,
+		TrackIndex:         episodeIndex,
+		TotalTracks:        totalEpisodes,
+		Duration:           episode.Duration_ms,
+	}
+}
+
+// currentChapterState describes the chapter being played, its audiobook takes the part of the album.
+func currentChapterState(ctx context.Context, client SpotClient, cache *ContextCache, previousStates []*persistence.PlayerState, playerState *PlayerState) *persistence.PlayerState {
+	playbackContext := playerState.PlaybackContext
+	chapter := playerState.Chapter
+
+	// Chapters being played do not necessarily contain their audiobook
+	audiobookContext := spotifyAPI.PlaybackContext{Type: "audiobook", URI: playbackContext.URI}
+	if chapter.Audiobook != nil && chapter.Audiobook.URI != "" {
+		audiobookContext.URI = chapter.Audiobook.URI
+	}
+
+	audiobook := chapter.Audiobook
+	if audiobook == nil || audiobook.Name == "" {
+		audiobook = &Audiobook{URI: audiobookContext.URI}
+
+		fetched, err := client.GetAudiobook(ctx, idOfContext(audiobookContext))
+		if err != nil {
+			// No need to stop processing this request because of this error...
+			log.Error().Err(err).Interface("chapter", chapter).Msg("Could not get audiobook of chapter.")
+		} else {
+			audiobook = fetched
+		}
+	}
+
+	images := chapter.Images
+	if len(images) == 0 {
+		images = audiobook.Images
+	}
+	albumArtLargeURL, albumArtMediumURL := twoImageURLs(images, chapter)
+
+	chapterIndex, totalChapters, err := indexOfCurrentTrack(ctx, client, cache, audiobookContext, positionHint(previousStates, audiobookContext), contextItem{ID: chapter.ID, URI: chapter.URI, Name: chapter.Name, Duration: chapter.Duration})
+	if err != nil {
+		// No need to stop processing this request because of this error...
+		log.Error().Err(err).Interface("chapter", chapter).Msg("Could not get index of chapter in audiobook.")
+	}
+
+	linkToContext, ok := playbackContext.ExternalURLs["spotify"]
+	if !ok {
+		// Played without context, so the audiobook is the best we can link to
+		linkToContext = audiobook.ExternalURLs["spotify"]
+	}
+
+	return &persistence.PlayerState{
+		// Even if the chapter has been played on its own, playback should continue with the next one once resumed
+		PlaybackContextURI: string(audiobookContext.URI),
+		PlaybackItemURI:    string(chapter.URI),
+		LinkToContext:      linkToContext,
+		ContextType:        "audiobook",
+		AlbumArtLargeURL:   albumArtLargeURL,
+		AlbumArtMediumURL:  albumArtMediumURL,
+		TrackName:          chapter.Name,
+		AlbumName:          audiobook.Name,
+		ArtistName:         joinedNames(audiobook.Authors),
+		NarratorName:       joinedNames(audiobook.Narrators),
+		TrackIndex:         chapterIndex,
+		TotalTracks:        totalChapters,
+		Duration:           chapter.Duration,
+	}
+}
+
+// twoImageURLs returns the URLs of the large and the medium sized image, there are supposed to be at least two.
+func twoImageURLs(images []spotifyAPI.Image, item interface{}) (string, string) {
+	switch len(images) {
+	case 0:
+		// Kind of an assert, should not happen. In case it does it's not too important though
+		log.Error().Interface("item", item).Msg("No image URL provided for currently playing item.")
+
+		return "", ""
+	case 1:
+		log.Error().Interface("item", item).Msg("Just one URL provided for currently playing item.")
+
+		return images[0].URL, images[0].URL
+	default:
+		return images[0].URL, images[1].URL
+	}
+}
+
+// playlistName returns the name of the playlist being played, if any. The user's collection counts as playlist.
+func playlistName(ctx context.Context, client SpotClient, playbackContext spotifyAPI.PlaybackContext) string {
+	if playbackContext.Type == "collection" {
+		return likedSongs
+	}
+
+	if playbackContext.Type != "playlist" {
+		return ""
+	}
+
+	playlistID := idOfContext(playbackContext)
+	playlist, err := client.GetPlaylistOpt(ctx, playlistID, "name")
+	if err != nil {
+		// No need to stop processing this request because of this error...
+		log.Error().Err(err).Str("playlistID", string(playlistID)).Msg("Could not get name of playlist.")
+		return ""
+	}
+
+	return playlist.Name
+}
+
+// RestoreStrategy tells how the saved item has been located within its context when restoring a player state.
+type RestoreStrategy string
+
+const (
+	// RestoreStrategyURI means the saved item has been found in its context resp. has been played on its own
+	RestoreStrategyURI RestoreStrategy = "uri"
+	// RestoreStrategyIndex means the saved item is gone, so the one at its saved index has been played instead
+	RestoreStrategyIndex RestoreStrategy = "index"
+	// RestoreStrategyName means the saved item is gone, so the one with the most similar name has been played instead
+	RestoreStrategyName RestoreStrategy = "name"
+)
+
+// RestorePlayerState resumes playback of the given state. In case the saved item cannot be found in its context
+// anymore, e.g. because the playlist has been changed, it falls back to the saved index and then to the item with
+// the most similar name. The strategy used is returned so that the user can be warned.
+func RestorePlayerState(ctx context.Context, client SpotClient, cache *ContextCache, stateToLoad *persistence.PlayerState, deviceID string) (RestoreStrategy, error) {
+	err := client.Shuffle(ctx, stateToLoad.ShuffleActivated)
+	if err != nil {
+		return "", err
 	}
 
 	stateToLoad.Progress -= min(stateToLoad.Progress, constants.JumpBackNSeconds*1e3)
 
-	contextURI := spotifyAPI.URI(stateToLoad.PlaybackContextURI)
 	itemURI := spotifyAPI.URI(stateToLoad.PlaybackItemURI)
+	strategy := RestoreStrategyURI
 	spotifyPlayOptions := &spotifyAPI.PlayOptions{
-		PlaybackContext: &contextURI,
-		PlaybackOffset:  &spotifyAPI.PlaybackOffset{URI: itemURI},
-		PositionMs:      stateToLoad.Progress,
+		PositionMs: stateToLoad.Progress,
+	}
+	if stateToLoad.PlaybackContextURI == "" {
+		// Episodes might have been played without any context
+		spotifyPlayOptions.URIs = []spotifyAPI.URI{itemURI}
+	} else {
+		contextURI := spotifyAPI.URI(stateToLoad.PlaybackContextURI)
+		spotifyPlayOptions.PlaybackContext = &contextURI
+
+		itemURI, strategy, err = locateItem(ctx, client, cache, stateToLoad)
+		if err != nil {
+			return "", err
+		}
+		spotifyPlayOptions.PlaybackOffset = &spotifyAPI.PlaybackOffset{URI: itemURI}
+
+		if strategy == RestoreStrategyIndex {
+			// The item now at the saved index most likely is another one, so it is played from its beginning
+			spotifyPlayOptions.PositionMs = 0
+		}
 	}
 
 	var id spotifyAPI.ID
 	if deviceID == "" {
 		var err error
-		id, err = currentDeviceForPlayback(client)
+		id, err = currentDeviceForPlayback(ctx, client)
 		if err != nil {
-			return err
+			return "", err
 		}
 	} else {
 		id = spotifyAPI.ID(deviceID)
@@ -145,16 +354,91 @@ func RestorePlayerState(client SpotClient, stateToLoad *persistence.PlayerState,
 
 	spotifyPlayOptions.DeviceID = &id
 
-	err = client.PlayOpt(spotifyPlayOptions)
+	err = client.PlayOpt(ctx, spotifyPlayOptions)
 	if err != nil {
-		return err
+		return "", err
+	}
+
+	return strategy, nil
+}
+
+// locateItem returns the URI of the item to resume playback with, see RestorePlayerState.
+func locateItem(ctx context.Context, client SpotClient, cache *ContextCache, state *persistence.PlayerState) (spotifyAPI.URI, RestoreStrategy, error) {
+	itemURI := spotifyAPI.URI(state.PlaybackItemURI)
+
+	playbackContext, ok := contextOfURI(spotifyAPI.URI(state.PlaybackContextURI))
+	if !ok {
+		return itemURI, RestoreStrategyURI, nil
+	}
+
+	saved := contextItem{URI: itemURI, Name: state.TrackName, Duration: state.Duration}
+	if !saved.isLocal() {
+		saved.ID = idOfURI(itemURI)
+	}
+
+	// Most likely the item is still where it has been saved
+	match, err := findInContext(ctx, client, cache, playbackContext, positionOf(state, playbackContext.Type), saved.sameAs)
+	if err != nil {
+		if itemURI == "" {
+			// States imported from old exports only know their context, the item has to be looked up
+			return "", "", err
+		}
+
+		// Not being able to look at the context should not prevent trying to resume playback
+		log.Warn().Err(err).Str("contextURI", state.PlaybackContextURI).Msg("Could not look up item to restore in its context.")
+		return itemURI, RestoreStrategyURI, nil
+	}
+
+	if match.position >= 0 {
+		// The item might be contained in the context by another URI, e.g. in case it has been relinked
+		return match.item.URI, RestoreStrategyURI, nil
+	}
+
+	items := match.items
+
+	if state.TrackIndex > 0 && state.TrackIndex <= len(items) {
+		// Other than albums and playlists the episodes of a show are counted from the oldest one
+		position := state.TrackIndex - 1
+		if playbackContext.Type == "show" {
+			position = len(items) - state.TrackIndex
+		}
+
+		if position >= 0 && position < len(items) {
+			return items[position].URI, RestoreStrategyIndex, nil
+		}
+	}
+
+	if item, ok := mostSimilarByName(items, state.TrackName); ok {
+		return item.URI, RestoreStrategyName, nil
 	}
 
-	return nil
+	return "", "", ErrTrackNotFoundInContext
 }
 
-func currentDeviceForPlayback(client SpotClient) (spotifyAPI.ID, error) {
-	devices, err := client.PlayerDevices()
+// contextOfURI tells the type of the context with the given URI, it returns false for contexts which cannot
+// be looked into.
+func contextOfURI(uri spotifyAPI.URI) (spotifyAPI.PlaybackContext, bool) {
+	splits := strings.Split(string(uri), ":")
+	if len(splits) < 3 {
+		return spotifyAPI.PlaybackContext{}, false
+	}
+
+	// The user's collection is identified by "spotify:user:<user ID>:collection"
+	typ := splits[len(splits)-2]
+	if splits[len(splits)-1] == "collection" {
+		typ = "collection"
+	}
+
+	switch typ {
+	case "album", "playlist", "collection", "show", "audiobook":
+		return spotifyAPI.PlaybackContext{Type: typ, URI: uri}, true
+	default:
+		return spotifyAPI.PlaybackContext{}, false
+	}
+}
+
+func currentDeviceForPlayback(ctx context.Context, client SpotClient) (spotifyAPI.ID, error) {
+	devices, err := client.PlayerDevices(ctx)
 
 	if err != nil {
 		return "", err
@@ -173,79 +457,130 @@ func currentDeviceForPlayback(client SpotClient) (spotifyAPI.ID, error) {
 	return devices[0].ID, nil
 }
 
-func indexOfCurrentTrack(currentlyPlaying *spotifyAPI.CurrentlyPlaying, client SpotClient) (int, int, error) {
-	typ := currentlyPlaying.PlaybackContext.Type
-
-	// Has to be "album" or "playlist" - this should be ensured upstream.
-	// So this check is basically an assert
-	isAlbum := typ == "album"
-	isPlaylist := typ == "playlist"
-	if !isAlbum && !isPlaylist {
-		log.Panic().Str("type", typ).Msg("called with context neither being 'album' nor 'playlist'")
+// indexOfCurrentTrack locates the given track, episode resp. chapter within the given context, looking at the hinted
+// position first. Differing from albums and playlists, the episodes of a show are counted from the oldest one.
+func indexOfCurrentTrack(ctx context.Context, client SpotClient, cache *ContextCache, playbackContext spotifyAPI.PlaybackContext, hint int, track contextItem) (int, int, error) {
+	match, err := findInContext(ctx, client, cache, playbackContext, hint, track.sameAs)
+	if err != nil {
+		return -1, -1, err
 	}
 
-	trackID := currentlyPlaying.Item.ID
-	contextID := idOfContext(currentlyPlaying)
+	if match.position < 0 {
+		return -1, -1, ErrTrackNotFoundInContext
+	}
 
-	offset := 0
-	limit := pagingLimit
-	options := spotifyAPI.Options{
-		Limit:  &limit,
-		Offset: &offset,
+	if playbackContext.Type == "show" {
+		// Spotify lists the latest episode first
+		return match.total - match.position, match.total, nil
 	}
-	var index int
-	var total int
 
-	for {
-		if isAlbum {
-			page, err := client.GetAlbumTracksOpt(contextID, &options)
-			if err != nil {
-				return -1, -1, err
-			}
+	return match.position + 1, match.total, nil // because the user probably does not expect zero-based counting
+}
 
-			index = findTrackInSimpleTrackPages(trackID, page)
-			total = page.Total
-		} else {
-			page, err := client.GetPlaylistTracksOpt(contextID, &options, "total,limit,items(track(id))")
-			if err != nil {
-				return -1, -1, err
-			}
+// contextItem is a track, episode resp. chapter within a context.
+type contextItem struct {
+	ID spotifyAPI.ID
+	// LinkedFromID is the ID of the track this one has been relinked from, if any
+	LinkedFromID spotifyAPI.ID
+	URI          spotifyAPI.URI
+	Name         string
+	// Duration in milliseconds, 0 if unknown
+	Duration int
+}
 
-			index = findTrackInPlaylistTrackPage(trackID, page)
-			total = page.Total
+// localFileDurationTolerance is the difference in milliseconds up to which local files are considered to be of the same
+// length. Their URIs only contain their duration in seconds and it might differ between devices.
+const localFileDurationTolerance = 2000
+
+// sameAs tells whether both items refer to the same track, episode resp. chapter. Relinked tracks match the track they
+// have been relinked from. Local files do not have an ID, they match by their URI resp. their name and duration.
+func (i contextItem) sameAs(other contextItem) bool {
+	if i.isLocal() || other.isLocal() {
+		if i.URI == other.URI {
+			return true
 		}
 
-		if index >= 0 {
-			index += offset + 1 // because the user probably does not expect zero-based counting
-			return index, total, nil
+		difference := i.Duration - other.Duration
+		if difference < 0 {
+			difference = -difference
 		}
 
-		offset += limit
-		if offset >= total {
-			return -1, -1, ErrTrackNotFoundInContext
+		return i.isLocal() && other.isLocal() &&
+			normalizeName(i.Name) == normalizeName(other.Name) &&
+			i.Duration > 0 && difference <= localFileDurationTolerance
+	}
+
+	if i.URI != "" && i.URI == other.URI {
+		return true
+	}
+
+	for _, id := range []spotifyAPI.ID{i.ID, i.LinkedFromID} {
+		if id != "" && (id == other.ID || id == other.LinkedFromID) {
+			return true
 		}
 	}
+
+	return false
 }
 
-func idOfContext(currentlyPlaying *spotifyAPI.CurrentlyPlaying) spotifyAPI.ID {
-	uri := currentlyPlaying.PlaybackContext.URI
-	splits := strings.Split(string(uri), ":")
-	return spotifyAPI.ID(splits[len(splits)-1])
+func (i contextItem) isLocal() bool {
+	return strings.HasPrefix(string(i.URI), "spotify:local:")
 }
 
-func findTrackInSimpleTrackPages(trackID spotifyAPI.ID, page *spotifyAPI.SimpleTrackPage) int {
-	for i, track := range page.Tracks {
-		if track.ID == trackID {
-			return i
+// contextMatch tells where an item has been found within its context.
+type contextMatch struct {
+	// position is zero-based in the order Spotify lists the context, -1 if no item matches
+	position int
+	total    int
+	item     contextItem
+	// items are all items of the context in case they all had to be looked at, i.e. if no item matches
+	items []contextItem
+}
+
+// findInContext looks for the item matching within the given context. The cached items of the context are looked at
+// first, followed by the page starting a bit before the hinted position resp. the first page. Only if the item is not
+// on that page, all the other pages get fetched, several at once. A negative hint means there is none.
+func findInContext(ctx context.Context, client SpotClient, cache *ContextCache, playbackContext spotifyAPI.PlaybackContext, hint int, match func(item contextItem) bool) (contextMatch, error) {
+	if items, ok := cache.get(playbackContext.URI); ok {
+		if position := indexOf(items, match); position >= 0 {
+			return contextMatch{position: position, total: len(items), item: items[position]}, nil
 		}
+		// The context might have been changed since, so its current items have to be looked at
 	}
 
-	return -1
+	// Items get added resp. removed before the one looked for every now and then
+	offset := max(hint-hintLeeway, 0)
+	page, total, err := contextPage(ctx, client, playbackContext, offset)
+	if err != nil {
+		return contextMatch{}, err
+	}
+
+	if position := indexOf(page, match); position >= 0 {
+		return contextMatch{position: offset + position, total: total, item: page[position]}, nil
+	}
+
+	var first []contextItem
+	if offset == 0 {
+		first = page
+	}
+
+	items, err := fetchContextItems(ctx, client, playbackContext, first, total, maxConcurrentPages)
+	if err != nil {
+		return contextMatch{}, err
+	}
+	cache.put(playbackContext.URI, items)
+
+	position := indexOf(items, match)
+	if position < 0 {
+		return contextMatch{position: -1, total: len(items), items: items}, nil
+	}
+
+	return contextMatch{position: position, total: len(items), item: items[position], items: items}, nil
 }
 
-func findTrackInPlaylistTrackPage(trackID spotifyAPI.ID, page *spotifyAPI.PlaylistTrackPage) int {
-	for i, track := range page.Tracks {
-		if track.Track.ID == trackID {
+func indexOf(items []contextItem, match func(item contextItem) bool) int {
+	for i, item := range items {
+		if match(item) {
 			return i
 		}
 	}
@@ -253,14 +588,217 @@ func findTrackInPlaylistTrackPage(trackID spotifyAPI.ID, page *spotifyAPI.Playli
 	return -1
 }
 
+// fetchContextItems fetches all items of the given context with at most the given number of pages being fetched at
+// the same time. The first page is not fetched again in case it is given.
+func fetchContextItems(ctx context.Context, client SpotClient, playbackContext spotifyAPI.PlaybackContext, first []contextItem, total int, parallelism int) ([]contextItem, error) {
+	if first == nil {
+		var err error
+		first, total, err = contextPage(ctx, client, playbackContext, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	numPages := 1
+	if total > pagingLimit {
+		numPages = (total + pagingLimit - 1) / pagingLimit
+	}
+
+	pages := make([][]contextItem, numPages)
+	pages[0] = first
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	semaphore := make(chan struct{}, parallelism)
+
+	for i := 1; i < numPages; i++ {
+		semaphore <- struct{}{}
+		if ctx.Err() != nil {
+			// Another page could not be fetched, so there is no point in fetching this one
+			<-semaphore
+			break
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+
+			page, _, err := contextPage(ctx, client, playbackContext, i*pagingLimit)
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+				cancel()
+				return
+			}
+
+			pages[i] = page
+		}(i)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	var items []contextItem
+	for _, page := range pages {
+		items = append(items, page...)
+	}
+
+	return items, nil
+}
+
+// positionHint returns the position the item of the state saved last for the given context has been at, -1 if
+// there is none.
+func positionHint(previousStates []*persistence.PlayerState, playbackContext spotifyAPI.PlaybackContext) int {
+	var latest *persistence.PlayerState
+	for _, state := range previousStates {
+		if state.PlaybackContextURI == string(playbackContext.URI) && state.TrackIndex > 0 &&
+			(latest == nil || state.SuspendedAtTs > latest.SuspendedAtTs) {
+			latest = state
+		}
+	}
+
+	if latest == nil {
+		return -1
+	}
+
+	return positionOf(latest, playbackContext.Type)
+}
+
+// positionOf returns the zero-based position of the saved item in the order Spotify lists the context of the
+// given type, -1 if it is unknown.
+func positionOf(state *persistence.PlayerState, contextType string) int {
+	if state.TrackIndex <= 0 {
+		return -1
+	}
+
+	if contextType == "show" {
+		// Episodes are counted from the oldest one, Spotify lists the latest one first
+		return max(state.TotalTracks-state.TrackIndex, -1)
+	}
+
+	return state.TrackIndex - 1
+}
+
+// contextPage fetches the items of the given context starting at the given offset along with the total number of items.
+func contextPage(ctx context.Context, client SpotClient, playbackContext spotifyAPI.PlaybackContext, offset int) ([]contextItem, int, error) {
+	contextID := idOfContext(playbackContext)
+
+	limit := pagingLimit
+	options := spotifyAPI.Options{
+		Limit:  &limit,
+		Offset: &offset,
+	}
+
+	// Has to be "album", "playlist", "collection", "show" or "audiobook" - this should be ensured upstream.
+	// So the default case is basically an assert
+	switch typ := playbackContext.Type; typ {
+	case "album":
+		page, err := client.GetAlbumTracksOpt(ctx, contextID, &options)
+		if err != nil {
+			return nil, -1, err
+		}
+
+		items := make([]contextItem, len(page.Tracks))
+		for i, track := range page.Tracks {
+			items[i] = contextItem{ID: track.ID, URI: track.URI, Name: track.Name, Duration: track.Duration}
+		}
+
+		return items, page.Total, nil
+	case "playlist":
+		page, err := client.GetPlaylistTracksOpt(ctx, contextID, &options, "total,limit,items(track(id,uri,name,duration_ms,linked_from(id)))")
+		if err != nil {
+			return nil, -1, err
+		}
+
+		items := make([]contextItem, len(page.Tracks))
+		for i, track := range page.Tracks {
+			items[i] = fullTrackItem(track.Track)
+		}
+
+		return items, page.Total, nil
+	case "collection":
+		// The collection is the list of the user's saved tracks
+		page, err := client.CurrentUsersTracksOpt(ctx, &options)
+		if err != nil {
+			return nil, -1, err
+		}
+
+		items := make([]contextItem, len(page.Tracks))
+		for i, track := range page.Tracks {
+			items[i] = fullTrackItem(track.FullTrack)
+		}
+
+		return items, page.Total, nil
+	case "show":
+		page, err := client.GetShowEpisodesOpt(ctx, contextID, &options)
+		if err != nil {
+			return nil, -1, err
+		}
+
+		items := make([]contextItem, len(page.Episodes))
+		for i, episode := range page.Episodes {
+			items[i] = contextItem{ID: episode.ID, URI: episode.URI, Name: episode.Name, Duration: episode.Duration_ms}
+		}
+
+		return items, page.Total, nil
+	case "audiobook":
+		page, err := client.GetAudiobookChaptersOpt(ctx, contextID, &options)
+		if err != nil {
+			return nil, -1, err
+		}
+
+		items := make([]contextItem, len(page.Chapters))
+		for i, chapter := range page.Chapters {
+			items[i] = contextItem{ID: chapter.ID, URI: chapter.URI, Name: chapter.Name, Duration: chapter.Duration}
+		}
+
+		return items, page.Total, nil
+	default:
+		log.Panic().Str("type", typ).Msg("called with context neither being 'album', 'playlist', 'collection', 'show' nor 'audiobook'")
+		return nil, -1, nil
+	}
+}
+
+func fullTrackItem(track spotifyAPI.FullTrack) contextItem {
+	item := contextItem{ID: track.ID, URI: track.URI, Name: track.Name, Duration: track.Duration}
+	if track.LinkedFrom != nil {
+		item.LinkedFromID = track.LinkedFrom.ID
+	}
+
+	return item
+}
+
+func idOfContext(playbackContext spotifyAPI.PlaybackContext) spotifyAPI.ID {
+	return idOfURI(playbackContext.URI)
+}
+
+func idOfURI(uri spotifyAPI.URI) spotifyAPI.ID {
+	splits := strings.Split(string(uri), ":")
+	return spotifyAPI.ID(splits[len(splits)-1])
+}
+
 type CondensedPlayerDevice struct {
 	ID     string `json:"id"`
 	Name   string `json:"name"`
 	Active bool   `json:"active"`
 }
 
-func ActiveSpotifyDevices(client SpotClient) ([]CondensedPlayerDevice, error) {
-	devices, err := client.PlayerDevices()
+func ActiveSpotifyDevices(ctx context.Context, client SpotClient) ([]CondensedPlayerDevice, error) {
+	devices, err := client.PlayerDevices(ctx)
 
 	if err != nil {
 		return nil, err
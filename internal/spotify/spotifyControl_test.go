@@ -0,0 +1,763 @@
+package spotify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	spotifyAPI "github.com/zmb3/spotify"
+
+	"github.com/florianloch/cassette/internal/persistence"
+)
+
+const episodePlayerState = `{
+	"shuffle_state": false,
+	"progress_ms": 61000,
+	"is_playing": true,
+	"currently_playing_type": "episode",
+	"context": null,
+	"item": {
+		"id": "episode2",
+		"uri": "spotify:episode:episode2",
+		"name": "Episode 2",
+		"duration_ms": 3600000,
+		"type": "episode",
+		"images": [],
+		"external_urls": {"spotify": "https://open.spotify.com/episode/episode2"},
+		"show": {
+			"id": "show1",
+			"uri": "spotify:show:show1",
+			"name": "A Show",
+			"publisher": "A Publisher",
+			"images": [{"url": "large"}, {"url": "medium"}]
+		}
+	}
+}`
+
+// podcastClient plays the second of three episodes of a show.
+type podcastClient struct {
+	SpotClient
+	playOptions *spotifyAPI.PlayOptions
+}
+
+func (c *podcastClient) PlayerState(ctx context.Context) (*PlayerState, error) {
+	return NewSpotClient(&http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return jsonResponse(http.StatusOK, episodePlayerState, ""), nil
+	})}).PlayerState(ctx)
+}
+
+func (c *podcastClient) GetShowEpisodesOpt(ctx context.Context, id spotifyAPI.ID, opt *spotifyAPI.Options) (*spotifyAPI.SimpleEpisodePage, error) {
+	page := &spotifyAPI.SimpleEpisodePage{Episodes: []spotifyAPI.EpisodePage{{ID: "episode3"}, {ID: "episode2"}, {ID: "episode1"}}}
+	page.Total = 3
+
+	return page, nil
+}
+
+func (c *podcastClient) Shuffle(ctx context.Context, shuffle bool) error {
+	return nil
+}
+
+func (c *podcastClient) PlayOpt(ctx context.Context, opt *spotifyAPI.PlayOptions) error {
+	c.playOptions = opt
+	return nil
+}
+
+func TestPlayerStateContainsEpisodes(t *testing.T) {
+	client := NewSpotClient(&http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if types := req.URL.Query().Get("additional_types"); types != "episode" {
+			t.Errorf("expected to ask for episodes, asked for '%s'", types)
+		}
+
+		return jsonResponse(http.StatusOK, episodePlayerState, ""), nil
+	})})
+
+	playerState, err := client.PlayerState(t.Context())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if playerState.Item != nil {
+		t.Errorf("expected no track, got %v", playerState.Item)
+	}
+	if playerState.Episode == nil || playerState.Episode.Show.Publisher != "A Publisher" {
+		t.Fatalf("expected episode including its show, got %v", playerState.Episode)
+	}
+}
+
+func TestCurrentPlayerStateOfEpisode(t *testing.T) {
+	state, err := CurrentPlayerState(t.Context(), &podcastClient{}, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := persistence.PlayerState{
+		PlaybackItemURI:   "spotify:episode:episode2",
+		LinkToContext:     "https://open.spotify.com/episode/episode2",
+		ContextType:       "show",
+		AlbumArtLargeURL:  "large",
+		AlbumArtMediumURL: "medium",
+		TrackName:         "Episode 2",
+		AlbumName:         "A Show",
+		ArtistName:        "A Publisher",
+		TrackIndex:        2,
+		TotalTracks:       3,
+		Progress:          61000,
+		Duration:          3600000,
+		SuspendedAtTs:     state.SuspendedAtTs,
+	}
+	if !reflect.DeepEqual(*state, expected) {
+		t.Errorf("expected %+v, got %+v", expected, *state)
+	}
+}
+
+func TestRestoreEpisodeWithoutContext(t *testing.T) {
+	client := &podcastClient{}
+
+	strategy, err := RestorePlayerState(t.Context(), client, nil, &persistence.PlayerState{PlaybackItemURI: "spotify:episode:episode2", Progress: 61000}, "device")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if strategy != RestoreStrategyURI {
+		t.Errorf("expected episode to be played by its URI, got %s", strategy)
+	}
+
+	opt := client.playOptions
+	if opt.PlaybackContext != nil || opt.PlaybackOffset != nil {
+		t.Errorf("expected no context to be given, got %v resp. %v", opt.PlaybackContext, opt.PlaybackOffset)
+	}
+	if len(opt.URIs) != 1 || opt.URIs[0] != "spotify:episode:episode2" {
+		t.Errorf("expected episode to be played, got %v", opt.URIs)
+	}
+	if opt.PositionMs <= 0 || opt.PositionMs > 61000 {
+		t.Errorf("expected playback to start at about the saved position, got %d", opt.PositionMs)
+	}
+}
+
+const chapterPlayerState = `{
+	"progress_ms": 90000,
+	"is_playing": true,
+	"currently_playing_type": "episode",
+	"context": {"type": "audiobook", "uri": "spotify:audiobook:book1", "external_urls": {"spotify": "https://open.spotify.com/audiobook/book1"}},
+	"item": {
+		"id": "chapter52",
+		"uri": "spotify:episode:chapter52",
+		"name": "Chapter 52",
+		"duration_ms": 1200000,
+		"type": "episode",
+		"images": [{"url": "large"}, {"url": "medium"}]
+	}
+}`
+
+// audiobookClient plays the 52nd of 60 chapters of an audiobook, the chapter does not contain its audiobook.
+type audiobookClient struct {
+	SpotClient
+	chapterPages int
+}
+
+func (c *audiobookClient) PlayerState(ctx context.Context) (*PlayerState, error) {
+	return NewSpotClient(&http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return jsonResponse(http.StatusOK, chapterPlayerState, ""), nil
+	})}).PlayerState(ctx)
+}
+
+func (c *audiobookClient) GetAudiobook(ctx context.Context, id spotifyAPI.ID) (*Audiobook, error) {
+	return &Audiobook{
+		ID:        id,
+		URI:       "spotify:audiobook:" + spotifyAPI.URI(id),
+		Name:      "A Book",
+		Authors:   []Person{{Name: "An Author"}, {Name: "Another Author"}},
+		Narrators: []Person{{Name: "A Narrator"}},
+	}, nil
+}
+
+func (c *audiobookClient) GetAudiobookChaptersOpt(ctx context.Context, id spotifyAPI.ID, opt *spotifyAPI.Options) (*ChapterPage, error) {
+	c.chapterPages++
+
+	page := &ChapterPage{Limit: *opt.Limit, Offset: *opt.Offset, Total: 60}
+	for i := *opt.Offset; i < 60 && i < *opt.Offset+*opt.Limit; i++ {
+		page.Chapters = append(page.Chapters, Chapter{ID: spotifyAPI.ID(fmt.Sprintf("chapter%d", i+1))})
+	}
+
+	return page, nil
+}
+
+func TestCurrentPlayerStateOfChapter(t *testing.T) {
+	client := &audiobookClient{}
+
+	state, err := CurrentPlayerState(t.Context(), client, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := persistence.PlayerState{
+		PlaybackContextURI: "spotify:audiobook:book1",
+		PlaybackItemURI:    "spotify:episode:chapter52",
+		LinkToContext:      "https://open.spotify.com/audiobook/book1",
+		ContextType:        "audiobook",
+		AlbumArtLargeURL:   "large",
+		AlbumArtMediumURL:  "medium",
+		TrackName:          "Chapter 52",
+		AlbumName:          "A Book",
+		ArtistName:         "An Author, Another Author",
+		NarratorName:       "A Narrator",
+		TrackIndex:         52,
+		TotalTracks:        60,
+		Progress:           90000,
+		Duration:           1200000,
+		SuspendedAtTs:      state.SuspendedAtTs,
+	}
+	if !reflect.DeepEqual(*state, expected) {
+		t.Errorf("expected %+v, got %+v", expected, *state)
+	}
+	if client.chapterPages != 2 {
+		t.Errorf("expected to page through the chapters, fetched %d pages", client.chapterPages)
+	}
+}
+
+func TestChapterWithoutAudiobookIsNotSuspendable(t *testing.T) {
+	playerState := &PlayerState{Chapter: &Chapter{ID: "chapter1"}}
+
+	if isContextSuspendable(playerState) {
+		t.Error("expected chapter without context nor audiobook not to be suspendable")
+	}
+}
+
+// libraryClient plays the given track from the given context, the user has saved 120 tracks with the one
+// being played being the 75th.
+type libraryClient struct {
+	SpotClient
+	contextType string
+	contextURI  spotifyAPI.URI
+	savedPages  atomic.Int32
+	// returned when listing the saved tracks, if set
+	savedErr error
+}
+
+func (c *libraryClient) PlayerState(ctx context.Context) (*PlayerState, error) {
+	playerState := &PlayerState{}
+	playerState.PlaybackContext = spotifyAPI.PlaybackContext{Type: c.contextType, URI: c.contextURI}
+	playerState.Item = &spotifyAPI.FullTrack{
+		SimpleTrack: spotifyAPI.SimpleTrack{ID: "track75", URI: "spotify:track:track75", Name: "Track 75"},
+		Album:       spotifyAPI.SimpleAlbum{URI: "spotify:album:album1", Name: "Album 1"},
+	}
+
+	return playerState, nil
+}
+
+func (c *libraryClient) CurrentUsersTracksOpt(ctx context.Context, opt *spotifyAPI.Options) (*spotifyAPI.SavedTrackPage, error) {
+	c.savedPages.Add(1)
+
+	if c.savedErr != nil {
+		return nil, c.savedErr
+	}
+
+	page := &spotifyAPI.SavedTrackPage{}
+	page.Total = 120
+	for i := *opt.Offset; i < 120 && i < *opt.Offset+*opt.Limit; i++ {
+		track := spotifyAPI.SavedTrack{}
+		track.ID = spotifyAPI.ID(fmt.Sprintf("track%d", i+1))
+		page.Tracks = append(page.Tracks, track)
+	}
+
+	return page, nil
+}
+
+func (c *libraryClient) GetAlbumTracksOpt(ctx context.Context, id spotifyAPI.ID, opt *spotifyAPI.Options) (*spotifyAPI.SimpleTrackPage, error) {
+	if id != "album1" {
+		return nil, fmt.Errorf("unexpected album '%s'", id)
+	}
+
+	page := &spotifyAPI.SimpleTrackPage{Tracks: []spotifyAPI.SimpleTrack{{ID: "track74"}, {ID: "track75"}}}
+	page.Total = 2
+
+	return page, nil
+}
+
+func TestCurrentPlayerStateOfLikedSongs(t *testing.T) {
+	client := &libraryClient{contextType: "collection", contextURI: "spotify:user:user1:collection"}
+
+	state, err := CurrentPlayerState(t.Context(), client, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if state.PlaybackContextURI != "spotify:user:user1:collection" || state.ContextType != "collection" {
+		t.Errorf("expected to be resumed from the collection, got '%s' of type '%s'", state.PlaybackContextURI, state.ContextType)
+	}
+	if state.TrackIndex != 75 || state.TotalTracks != 120 {
+		t.Errorf("expected track 75 of 120, got %d of %d", state.TrackIndex, state.TotalTracks)
+	}
+	if state.PlaylistName != "Liked Songs" {
+		t.Errorf("expected collection to be named 'Liked Songs', got '%s'", state.PlaylistName)
+	}
+	if pages := client.savedPages.Load(); pages != 3 {
+		t.Errorf("expected to page through all saved tracks, fetched %d pages", pages)
+	}
+}
+
+func TestCurrentPlayerStateOfLikedSongsRequiresLibraryScope(t *testing.T) {
+	missingScope := classify(spotifyAPI.Error{Status: http.StatusForbidden, Message: "Insufficient client scope"}, http.StatusForbidden, 0)
+	client := &libraryClient{contextType: "collection", contextURI: "spotify:user:user1:collection", savedErr: missingScope}
+
+	if _, err := CurrentPlayerState(t.Context(), client, nil, nil); !errors.Is(err, ErrScopeMissing) {
+		t.Fatalf("expected ErrScopeMissing, got %v", err)
+	}
+}
+
+func TestCurrentPlayerStateLooksAtHintedPositionFirst(t *testing.T) {
+	client := &libraryClient{contextType: "collection", contextURI: "spotify:user:user1:collection"}
+	previousStates := []*persistence.PlayerState{
+		{PlaybackContextURI: "spotify:user:user1:collection", TrackIndex: 12, SuspendedAtTs: 100},
+		{PlaybackContextURI: "spotify:user:user1:collection", TrackIndex: 73, SuspendedAtTs: 200},
+		{PlaybackContextURI: "spotify:album:album1", TrackIndex: 1, SuspendedAtTs: 300},
+	}
+
+	state, err := CurrentPlayerState(t.Context(), client, nil, previousStates)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if state.TrackIndex != 75 || state.TotalTracks != 120 {
+		t.Errorf("expected track 75 of 120, got %d of %d", state.TrackIndex, state.TotalTracks)
+	}
+	if pages := client.savedPages.Load(); pages != 1 {
+		t.Errorf("expected the track to be found on the page of the latest hint, fetched %d pages", pages)
+	}
+}
+
+func TestCurrentPlayerStateOfArtist(t *testing.T) {
+	state, err := CurrentPlayerState(t.Context(), &libraryClient{contextType: "artist", contextURI: "spotify:artist:artist1"}, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if state.PlaybackContextURI != "spotify:album:album1" || state.ContextType != "artist" {
+		t.Errorf("expected the track's album to be resumed, got '%s' of type '%s'", state.PlaybackContextURI, state.ContextType)
+	}
+	if state.TrackIndex != 2 || state.TotalTracks != 2 {
+		t.Errorf("expected track 2 of 2, got %d of %d", state.TrackIndex, state.TotalTracks)
+	}
+}
+
+// playlistClient plays from a playlist containing the given tracks.
+type playlistClient struct {
+	SpotClient
+	tracks []spotifyAPI.SimpleTrack
+	// linkedFrom tells which tracks of the playlist have been relinked from which ones
+	linkedFrom  map[spotifyAPI.ID]*spotifyAPI.LinkedFromInfo
+	playing     *spotifyAPI.FullTrack
+	playOptions *spotifyAPI.PlayOptions
+}
+
+func (c *playlistClient) PlayerState(ctx context.Context) (*PlayerState, error) {
+	playerState := &PlayerState{}
+	playerState.PlaybackContext = spotifyAPI.PlaybackContext{Type: "playlist", URI: "spotify:playlist:playlist1"}
+	playerState.Item = c.playing
+
+	return playerState, nil
+}
+
+func (c *playlistClient) GetPlaylistOpt(ctx context.Context, playlistID spotifyAPI.ID, fields string) (*spotifyAPI.FullPlaylist, error) {
+	playlist := &spotifyAPI.FullPlaylist{}
+	playlist.Name = "Playlist 1"
+
+	return playlist, nil
+}
+
+func (c *playlistClient) GetPlaylistTracksOpt(ctx context.Context, playlistID spotifyAPI.ID, opt *spotifyAPI.Options, fields string) (*spotifyAPI.PlaylistTrackPage, error) {
+	page := &spotifyAPI.PlaylistTrackPage{}
+	page.Total = len(c.tracks)
+	for i := *opt.Offset; i < len(c.tracks) && i < *opt.Offset+*opt.Limit; i++ {
+		track := spotifyAPI.FullTrack{SimpleTrack: c.tracks[i], LinkedFrom: c.linkedFrom[c.tracks[i].ID]}
+		page.Tracks = append(page.Tracks, spotifyAPI.PlaylistTrack{IsLocal: track.ID == "", Track: track})
+	}
+
+	return page, nil
+}
+
+func (c *playlistClient) Shuffle(ctx context.Context, shuffle bool) error {
+	return nil
+}
+
+func (c *playlistClient) PlayOpt(ctx context.Context, opt *spotifyAPI.PlayOptions) error {
+	c.playOptions = opt
+	return nil
+}
+
+func TestRestoreFallsBackIfTrackIsGone(t *testing.T) {
+	tracks := []spotifyAPI.SimpleTrack{
+		{ID: "track1", URI: "spotify:track:track1", Name: "Chapter 1"},
+		{ID: "track2", URI: "spotify:track:track2", Name: "Chapter 2 - Remastered"},
+		{ID: "track3", URI: "spotify:track:track3", Name: "Chapter 3"},
+	}
+
+	for _, tc := range []struct {
+		name          string
+		itemURI       string
+		trackName     string
+		trackIndex    int
+		expectedURI   spotifyAPI.URI
+		expectedStrat RestoreStrategy
+	}{
+		{"still there", "spotify:track:track3", "Chapter 3", 1, "spotify:track:track3", RestoreStrategyURI},
+		{"gone but index known", "spotify:track:gone", "Chapter 3", 2, "spotify:track:track2", RestoreStrategyIndex},
+		{"gone and index unknown", "spotify:track:gone", "chapter 2: remastered", -1, "spotify:track:track2", RestoreStrategyName},
+		{"gone and index out of range", "spotify:track:gone", "Chapter 3.", 7, "spotify:track:track3", RestoreStrategyName},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &playlistClient{tracks: tracks}
+			state := &persistence.PlayerState{
+				PlaybackContextURI: "spotify:playlist:playlist1",
+				PlaybackItemURI:    tc.itemURI,
+				TrackName:          tc.trackName,
+				TrackIndex:         tc.trackIndex,
+				Progress:           120000,
+			}
+
+			strategy, err := RestorePlayerState(t.Context(), client, nil, state, "device")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if strategy != tc.expectedStrat {
+				t.Errorf("expected strategy %s, got %s", tc.expectedStrat, strategy)
+			}
+			if uri := client.playOptions.PlaybackOffset.URI; uri != tc.expectedURI {
+				t.Errorf("expected %s to be played, got %s", tc.expectedURI, uri)
+			}
+			if position := client.playOptions.PositionMs; (strategy == RestoreStrategyIndex) != (position == 0) {
+				t.Errorf("expected playback to start at the beginning only if resumed by index, starts at %d", position)
+			}
+		})
+	}
+}
+
+func TestRestoreStateWithoutItem(t *testing.T) {
+	client := &playlistClient{tracks: []spotifyAPI.SimpleTrack{
+		{ID: "track1", URI: "spotify:track:track1", Name: "Chapter 1"},
+		{ID: "track2", URI: "spotify:track:track2", Name: "Chapter 2"},
+	}}
+	// Imported from an export which did not contain URIs
+	state := &persistence.PlayerState{
+		PlaybackContextURI: "spotify:playlist:playlist1",
+		TrackName:          "Chapter 2",
+		TrackIndex:         2,
+	}
+
+	strategy, err := RestorePlayerState(t.Context(), client, nil, state, "device")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if strategy != RestoreStrategyIndex || client.playOptions.PlaybackOffset.URI != "spotify:track:track2" {
+		t.Errorf("expected track at saved index to be played, got %s by %s", client.playOptions.PlaybackOffset.URI, strategy)
+	}
+}
+
+func TestRestoreFailsIfNothingMatches(t *testing.T) {
+	client := &playlistClient{tracks: []spotifyAPI.SimpleTrack{
+		{ID: "track1", URI: "spotify:track:track1", Name: "Chapter 1"},
+		{ID: "track3", URI: "spotify:track:track3", Name: "Chapter 3"},
+	}}
+	state := &persistence.PlayerState{
+		PlaybackContextURI: "spotify:playlist:playlist1",
+		PlaybackItemURI:    "spotify:track:gone",
+		TrackName:          "Chapter 4",
+		TrackIndex:         -1,
+	}
+
+	if _, err := RestorePlayerState(t.Context(), client, nil, state, "device"); !errors.Is(err, ErrTrackNotFoundInContext) {
+		t.Fatalf("expected ErrTrackNotFoundInContext, got %v", err)
+	}
+	if client.playOptions != nil {
+		t.Error("expected playback not to be started")
+	}
+}
+
+func TestCurrentPlayerStateOfRelinkedTrack(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		playing    *spotifyAPI.FullTrack
+		linkedFrom map[spotifyAPI.ID]*spotifyAPI.LinkedFromInfo
+	}{
+		{
+			"playing relinked track",
+			&spotifyAPI.FullTrack{
+				SimpleTrack: spotifyAPI.SimpleTrack{ID: "relinked", URI: "spotify:track:relinked", Name: "Track 2"},
+				LinkedFrom:  &spotifyAPI.LinkedFromInfo{ID: "track2", URI: "spotify:track:track2"},
+			},
+			nil,
+		},
+		{
+			"playlist containing relinked track",
+			&spotifyAPI.FullTrack{SimpleTrack: spotifyAPI.SimpleTrack{ID: "track2", URI: "spotify:track:track2", Name: "Track 2"}},
+			map[spotifyAPI.ID]*spotifyAPI.LinkedFromInfo{"relinked": {ID: "track2", URI: "spotify:track:track2"}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &playlistClient{
+				tracks: []spotifyAPI.SimpleTrack{
+					{ID: "track1", URI: "spotify:track:track1", Name: "Track 1"},
+					{ID: "relinked", URI: "spotify:track:relinked", Name: "Track 2"},
+					{ID: "track3", URI: "spotify:track:track3", Name: "Track 3"},
+				},
+				linkedFrom: tc.linkedFrom,
+				playing:    tc.playing,
+			}
+			if tc.linkedFrom == nil {
+				client.tracks[1] = spotifyAPI.SimpleTrack{ID: "track2", URI: "spotify:track:track2", Name: "Track 2"}
+			}
+
+			state, err := CurrentPlayerState(t.Context(), client, nil, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if state.TrackIndex != 2 || state.TotalTracks != 3 {
+				t.Errorf("expected track 2 of 3, got %d of %d", state.TrackIndex, state.TotalTracks)
+			}
+			if state.PlaybackItemURI != "spotify:track:track2" {
+				t.Errorf("expected the catalogue's track to be saved, got '%s'", state.PlaybackItemURI)
+			}
+		})
+	}
+}
+
+func TestCurrentPlayerStateOfLocalFile(t *testing.T) {
+	client := &playlistClient{
+		tracks: []spotifyAPI.SimpleTrack{
+			{URI: "spotify:local:Artist:Album:Title+1:180", Name: "Title 1", Duration: 180000},
+			{ID: "track2", URI: "spotify:track:track2", Name: "Track 2"},
+			{URI: "spotify:local:Artist:Album:Title+2:240", Name: "Title 2", Duration: 240000},
+		},
+		playing: &spotifyAPI.FullTrack{SimpleTrack: spotifyAPI.SimpleTrack{
+			URI: "spotify:local:Artist:Album:Title+2:240", Name: "Title 2", Duration: 240000,
+		}},
+	}
+
+	state, err := CurrentPlayerState(t.Context(), client, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if state.TrackIndex != 3 || state.TotalTracks != 3 {
+		t.Errorf("expected track 3 of 3, got %d of %d", state.TrackIndex, state.TotalTracks)
+	}
+}
+
+func TestRestoreLocalFile(t *testing.T) {
+	client := &playlistClient{tracks: []spotifyAPI.SimpleTrack{
+		{URI: "spotify:local:Artist:Album:Title+1:180", Name: "Title 1", Duration: 180000},
+		{URI: "spotify:local:Artist:Album:Title+2:241", Name: "Title 2", Duration: 240600},
+	}}
+	// The file's duration got rounded differently on the device it has been played on
+	state := &persistence.PlayerState{
+		PlaybackContextURI: "spotify:playlist:playlist1",
+		PlaybackItemURI:    "spotify:local:Artist:Album:Title+2:240",
+		TrackName:          "Title 2",
+		TrackIndex:         -1,
+		Duration:           240400,
+	}
+
+	strategy, err := RestorePlayerState(t.Context(), client, nil, state, "device")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if strategy != RestoreStrategyURI {
+		t.Errorf("expected strategy %s, got %s", RestoreStrategyURI, strategy)
+	}
+	if uri := client.playOptions.PlaybackOffset.URI; uri != "spotify:local:Artist:Album:Title+2:241" {
+		t.Errorf("expected the playlist's file to be played, got %s", uri)
+	}
+}
+
+func TestSameItem(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		a, b     contextItem
+		expected bool
+	}{
+		{"same ID", contextItem{ID: "track1"}, contextItem{ID: "track1"}, true},
+		{"different IDs", contextItem{ID: "track1"}, contextItem{ID: "track2"}, false},
+		{"relinked", contextItem{ID: "relinked", LinkedFromID: "track1"}, contextItem{ID: "track1"}, true},
+		{"both relinked", contextItem{ID: "relinked1", LinkedFromID: "track1"}, contextItem{ID: "relinked2", LinkedFromID: "track1"}, true},
+		{"no IDs", contextItem{Name: "Title"}, contextItem{Name: "Title"}, false},
+		{"local by URI", contextItem{URI: "spotify:local:a:b:Title:240"}, contextItem{URI: "spotify:local:a:b:Title:240"}, true},
+		{
+			"local by name and duration",
+			contextItem{URI: "spotify:local:a:b:Title:240", Name: "Title", Duration: 240400},
+			contextItem{URI: "spotify:local:a:b:Title:241", Name: "title", Duration: 241000},
+			true,
+		},
+		{
+			"local of different duration",
+			contextItem{URI: "spotify:local:a:b:Title:240", Name: "Title", Duration: 240000},
+			contextItem{URI: "spotify:local:a:c:Title:300", Name: "Title", Duration: 300000},
+			false,
+		},
+		{
+			"local and catalogue track",
+			contextItem{URI: "spotify:local:a:b:Title:240", Name: "Title", Duration: 240000},
+			contextItem{ID: "track1", URI: "spotify:track:track1", Name: "Title", Duration: 240000},
+			false,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.a.sameAs(tc.b) != tc.expected || tc.b.sameAs(tc.a) != tc.expected {
+				t.Errorf("expected %+v and %+v to match: %t", tc.a, tc.b, tc.expected)
+			}
+		})
+	}
+}
+
+func TestContextOfURI(t *testing.T) {
+	for uri, expected := range map[spotifyAPI.URI]string{
+		"spotify:album:album1":                  "album",
+		"spotify:user:user1:playlist:playlist1": "playlist",
+		"spotify:user:user1:collection":         "collection",
+		"spotify:audiobook:book1":               "audiobook",
+		"spotify:artist:artist1":                "",
+		"":                                      "",
+	} {
+		playbackContext, ok := contextOfURI(uri)
+		if ok != (expected != "") || playbackContext.Type != expected {
+			t.Errorf("expected '%s' to be of type '%s', got '%s'", uri, expected, playbackContext.Type)
+		}
+	}
+}
+
+// largePlaylistClient lists a playlist of the given number of tracks, every call takes the given time.
+type largePlaylistClient struct {
+	SpotClient
+	total   int
+	latency time.Duration
+	calls   atomic.Int32
+}
+
+func (c *largePlaylistClient) GetPlaylistTracksOpt(ctx context.Context, playlistID spotifyAPI.ID, opt *spotifyAPI.Options, fields string) (*spotifyAPI.PlaylistTrackPage, error) {
+	c.calls.Add(1)
+	time.Sleep(c.latency)
+
+	page := &spotifyAPI.PlaylistTrackPage{}
+	page.Total = c.total
+	for i := *opt.Offset; i < c.total && i < *opt.Offset+*opt.Limit; i++ {
+		id := spotifyAPI.ID(fmt.Sprintf("track%d", i+1))
+		page.Tracks = append(page.Tracks, spotifyAPI.PlaylistTrack{Track: spotifyAPI.FullTrack{SimpleTrack: spotifyAPI.SimpleTrack{ID: id}}})
+	}
+
+	return page, nil
+}
+
+func TestFindInContext(t *testing.T) {
+	playlist := spotifyAPI.PlaybackContext{Type: "playlist", URI: "spotify:playlist:playlist1"}
+
+	for _, tc := range []struct {
+		name          string
+		hint          int
+		cached        bool
+		expectedCalls int32
+	}{
+		{"without hint", -1, false, 25},
+		{"with hint", 1197, false, 1},
+		{"with outdated hint", 5000, false, 26},
+		{"cached", -1, true, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &largePlaylistClient{total: 1234}
+			var cache *ContextCache
+			if tc.cached {
+				items, err := fetchContextItems(t.Context(), client, playlist, nil, 0, maxConcurrentPages)
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				cache = NewContextCache(time.Minute)
+				cache.put(playlist.URI, items)
+				client.calls.Store(0)
+			}
+
+			match, err := findInContext(t.Context(), client, cache, playlist, tc.hint, contextItem{ID: "track1200"}.sameAs)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if match.position != 1199 || match.total != 1234 {
+				t.Errorf("expected position 1199 of 1234, got %d of %d", match.position, match.total)
+			}
+			if calls := client.calls.Load(); calls != tc.expectedCalls {
+				t.Errorf("expected %d calls, got %d", tc.expectedCalls, calls)
+			}
+		})
+	}
+}
+
+func TestFindInContextReturnsAllItemsIfNoneMatches(t *testing.T) {
+	playlist := spotifyAPI.PlaybackContext{Type: "playlist", URI: "spotify:playlist:playlist1"}
+	client := &largePlaylistClient{total: 1234}
+	cache := NewContextCache(time.Minute)
+
+	match, err := findInContext(t.Context(), client, cache, playlist, 10, contextItem{ID: "gone"}.sameAs)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if match.position != -1 || len(match.items) != 1234 || match.items[1233].ID != "track1234" {
+		t.Errorf("expected no match but all items in order, got position %d and %d items", match.position, len(match.items))
+	}
+	if items, ok := cache.get(playlist.URI); !ok || len(items) != 1234 {
+		t.Error("expected all items to be cached")
+	}
+}
+
+// BenchmarkFindInContext locates a track near the end of a playlist of 2,000 tracks, every call to Spotify
+// taking a millisecond.
+func BenchmarkFindInContext(b *testing.B) {
+	playlist := spotifyAPI.PlaybackContext{Type: "playlist", URI: "spotify:playlist:playlist1"}
+	match := contextItem{ID: "track1500"}.sameAs
+
+	b.Run("sequential", func(b *testing.B) {
+		client := &largePlaylistClient{total: 2000, latency: time.Millisecond}
+		for b.Loop() {
+			items, err := fetchContextItems(b.Context(), client, playlist, nil, 0, 1)
+			if err != nil || indexOf(items, match) != 1499 {
+				b.Fatalf("track not found: %v", err)
+			}
+		}
+		b.ReportMetric(float64(client.calls.Load())/float64(b.N), "calls/op")
+	})
+
+	for _, bc := range []struct {
+		name  string
+		hint  int
+		cache bool
+	}{
+		{"concurrent", -1, false},
+		{"hinted", 1490, false},
+		{"cached", -1, true},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			client := &largePlaylistClient{total: 2000, latency: time.Millisecond}
+			var cache *ContextCache
+			if bc.cache {
+				cache = NewContextCache(time.Hour)
+				if _, err := findInContext(b.Context(), client, cache, playlist, bc.hint, match); err != nil {
+					b.Fatalf("unexpected error: %s", err)
+				}
+				client.calls.Store(0)
+			}
+
+			for b.Loop() {
+				result, err := findInContext(b.Context(), client, cache, playlist, bc.hint, match)
+				if err != nil || result.position != 1499 {
+					b.Fatalf("track not found: %v", err)
+				}
+			}
+			b.ReportMetric(float64(client.calls.Load())/float64(b.N), "calls/op")
+		})
+	}
+}
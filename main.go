@@ -22,5 +22,19 @@ func main() {
 
 	log.Info().Str("gitCommit", gitVersion).Str("gitDate", gitAuthorDate).Str("builtAt", buildDate).Msg("")
 
-	internal.RunInProduction()
+	command := ""
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
+	switch command {
+	case "migrate":
+		internal.RunMigrateCommand(os.Args[2:])
+	case "reencrypt":
+		internal.RunReencryptCommand(os.Args[2:])
+	case "rekey":
+		internal.RunRekeyCommand(os.Args[2:])
+	default:
+		internal.RunInProduction()
+	}
 }
@@ -7,10 +7,19 @@ const URL_CSRF_TOKEN = API_PATH + "/csrfToken"
 const URL_PLAYER_STATES = API_PATH + "/playerStates"
 const URL_ACTIVE_DEVICES = API_PATH + "/activeDevices"
 const CONSENT_COOKIE_NAME = "cassette_consent"
+// Status the backend responds with in case the maximum number of slots has been reached
+const STATUS_QUOTA_EXCEEDED = 507
 
 const API = function (options) {
     const client = options ? options.axios : null || axios.create()
 
+    // Revision of the player states last fetched, slot numbers refer to it
+    let playerStatesETag = null
+
+    const ifMatch = () => {
+        return playerStatesETag ? { headers: { "If-Match": playerStatesETag } } : {}
+    }
+
     this.fetchCSRFToken = () => {
         return client.head(URL_CSRF_TOKEN).then((res) => {
             return res.headers[CSRF_HEADER_NAME]
@@ -29,16 +38,18 @@ const API = function (options) {
 
     this.fetchPlayerStates = () => {
         return client.get(URL_PLAYER_STATES).then((res) => {
+            playerStatesETag = res.headers["etag"] || null
             return preparePlayerStates(res.data)
         })
     }
 
-    // Sorts the states by LRU and adds the original index as slotNumber
+    // Sorts the states by LRU and adds the original index as slotNumber as well as the stable ID as slotID
     function preparePlayerStates(rawPlayerStates) {
         const cookedPlayerStates = rawPlayerStates.map((cur, idx) => {
             return {
                 state: cur,
                 slotNumber: idx,
+                slotID: cur.id,
             }
         })
 
@@ -49,20 +60,20 @@ const API = function (options) {
         return cookedPlayerStates
     }
 
-    this.updatePlayerState = (slotNumber) => {
-        return client.put(`${URL_PLAYER_STATES}/${slotNumber}`)
+    this.updatePlayerState = (slotID) => {
+        return client.put(`${URL_PLAYER_STATES}/${slotID}`, null, ifMatch())
     }
 
     this.storePlayerState = () => {
         return client.post(URL_PLAYER_STATES)
     }
 
-    this.deletePlayerState = (slotNumber) => {
-        return client.delete(`${URL_PLAYER_STATES}/${slotNumber}`)
+    this.deletePlayerState = (slotID) => {
+        return client.delete(`${URL_PLAYER_STATES}/${slotID}`, ifMatch())
     }
 
-    this.restoreFromPlayerState = (slotNumber, deviceID) => {
-        const url = `${URL_PLAYER_STATES}/${slotNumber}/restore${
+    this.restoreFromPlayerState = (slotID, deviceID) => {
+        const url = `${URL_PLAYER_STATES}/${slotID}/restore${
             deviceID ? `?deviceID=${deviceID}` : ""
         }`
         return client.post(url)
@@ -78,6 +89,8 @@ const API = function (options) {
 
     this.consentGiven = API.consentGiven
 
+    this.isQuotaExceeded = API.isQuotaExceeded
+
     this.URL_DATA = URL_DATA
 }
 
@@ -85,6 +98,12 @@ API.install = function (Vue, options) {
     Vue.prototype.$api = new API(options)
 }
 
+API.isQuotaExceeded = (err) => {
+    return Boolean(
+        err.response && err.response.status === STATUS_QUOTA_EXCEEDED
+    )
+}
+
 API.giveConsent = () => {
     const now = Math.floor(Date.now() / 1000)
     const maxAge = 10 * 60 * 60 * 24 * 365 // 10 years, keep this in sync with consent middleware in the backend